@@ -0,0 +1,122 @@
+/*
+Copyright 2023. Luxas Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AccessRequestSpec defines the relation a user requests to an object, and for how long
+type AccessRequestSpec struct {
+	// User is the name of the user requesting access. It must be the user creating the AccessRequest.
+	User string `json:"user"`
+	// Relation is the requested relation to the object, e.g. "assignee".
+	Relation string `json:"relation"`
+	// ObjectType is the type of the object in the authorization model, e.g. "clusterrole".
+	ObjectType string `json:"objectType"`
+	// ObjectID is the ID of the object node in the authorization model, e.g. "cluster-admin".
+	ObjectID string `json:"objectID"`
+	// Duration is for how long the access is granted, counted from when the request is approved.
+	// The controller may limit it to a maximum duration.
+	Duration metav1.Duration `json:"duration"`
+	// Reason describes why the access is needed, for the approvers and auditing.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Approvals are the approvals of the request. Approvers add their own approval to the list;
+	// approvals by the requesting user, or by users not allowed to approve, are ignored.
+	// +optional
+	Approvals []Approval `json:"approvals,omitempty"`
+}
+
+// Approval is the approval of an AccessRequest by a user
+type Approval struct {
+	// User is the name of the approving user. It must be the user adding the approval.
+	User string `json:"user"`
+	// Groups are the groups of the approving user when approving, as authenticated by the API server.
+	// They must be all the groups of the user adding the approval, such that users allowed to approve
+	// through a group can approve.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+}
+
+// AccessRequestPhase is the lifecycle phase of an AccessRequest
+type AccessRequestPhase string
+
+const (
+	// AccessRequestPending means the request waits for enough valid approvals
+	AccessRequestPending AccessRequestPhase = "Pending"
+	// AccessRequestActive means the request is approved, and the access is granted until it expires
+	AccessRequestActive AccessRequestPhase = "Active"
+	// AccessRequestExpired means the access was granted, but has expired
+	AccessRequestExpired AccessRequestPhase = "Expired"
+	// AccessRequestInvalid means the request can never be granted, e.g. as the relation cannot be requested
+	AccessRequestInvalid AccessRequestPhase = "Invalid"
+)
+
+// AccessRequestStatus defines the observed state of AccessRequest
+type AccessRequestStatus struct {
+	// Phase is the lifecycle phase of the request.
+	// +optional
+	Phase AccessRequestPhase `json:"phase,omitempty"`
+	// Message describes the phase in a human-readable way.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// ApprovedBy are the approvals that are valid. The controller checks the approvals again on every
+	// reconcile, and revokes the access if they are no longer enough.
+	// +optional
+	ApprovedBy []string `json:"approvedBy,omitempty"`
+	// ApprovedAt is when the request was approved, and the access was granted.
+	// +optional
+	ApprovedAt *metav1.Time `json:"approvedAt,omitempty"`
+	// ExpiresAt is when the granted access is removed. It is always computed from ApprovedAt and the
+	// requested duration, limited by the controller.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.user`
+//+kubebuilder:printcolumn:name="Relation",type=string,JSONPath=`.spec.relation`
+//+kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.objectType`
+//+kubebuilder:printcolumn:name="ID",type=string,JSONPath=`.spec.objectID`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Expires",type=date,JSONPath=`.status.expiresAt`
+
+// AccessRequest is a request by a user for a relation to an object in the authorization model,
+// for a limited duration. Once approved, the relation is granted until it expires.
+type AccessRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AccessRequestSpec   `json:"spec,omitempty"`
+	Status AccessRequestStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AccessRequestList contains a list of AccessRequest
+type AccessRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AccessRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AccessRequest{}, &AccessRequestList{})
+}
@@ -1,6 +1,12 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/accessrequestsyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
 type Config struct {
 	// The address the metric endpoint binds to.
@@ -25,6 +31,14 @@ type Config struct {
 	// TODO: Add OpenFGAServer here in the future
 
 	Tracing *TracingConfig `json:"tracing"`
+
+	// GarbageCollection periodically deletes tuples that no Kubernetes object generates anymore.
+	// Disabled if unset.
+	GarbageCollection *GarbageCollectionConfig `json:"garbageCollection"`
+
+	// AccessRequests lets users request relations for a limited duration through the AccessRequest API.
+	// Disabled if unset.
+	AccessRequests *AccessRequestsConfig `json:"accessRequests"`
 }
 
 func (c *Config) DynamicDefault() {
@@ -40,6 +54,18 @@ func (c *Config) Validate() error {
 	if c.OpenFGAClient == nil || c.OpenFGAClient.Address == "" {
 		return fmt.Errorf(".openFGAClient.address is required")
 	}
+	if c.AccessRequests != nil {
+		for i, t := range c.AccessRequests.Targets {
+			if t.ObjectType == "" || len(t.Relations) == 0 || t.ApproverRelation == "" {
+				return fmt.Errorf(".accessRequests.targets[%d]: objectType, relations and approverRelation are required", i)
+			}
+			if t.ApproverObject != "" {
+				if _, err := zanzibar.ParseNode(t.ApproverObject); err != nil {
+					return fmt.Errorf(".accessRequests.targets[%d].approverObject: %w", i, err)
+				}
+			}
+		}
+	}
 	return nil
 }
 
@@ -54,3 +80,21 @@ type TracingConfig struct {
 	// SampleRatio defines what fraction of spans to send to Endpoint. Should be in [0, 1] range.
 	SampleRatio float64 `json:"sampleRatio"`
 }
+
+type GarbageCollectionConfig struct {
+	// Interval between the sweeps of the store.
+	// Default: "1h"
+	Interval metav1.Duration `json:"interval"`
+	// DryRun only logs the orphaned tuples, instead of deleting them.
+	DryRun bool `json:"dryRun"`
+}
+
+type AccessRequestsConfig struct {
+	// Targets are the relations that can be requested, and who can approve them.
+	Targets []accessrequestsyncer.Target `json:"targets"`
+	// RequiredApprovals is the number of approvals needed.
+	// Default: 1
+	RequiredApprovals int `json:"requiredApprovals"`
+	// MaxDuration limits for how long access can be granted. Unlimited if unset.
+	MaxDuration metav1.Duration `json:"maxDuration"`
+}
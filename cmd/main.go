@@ -34,6 +34,7 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -45,13 +46,14 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
-	// TODO: Reactivate when adding the CRD API
-	// rebacv1alpha1 "github.com/luxas/kube-rebac-authorizer/api/v1alpha1"
+	rebacv1alpha1 "github.com/luxas/kube-rebac-authorizer/api/v1alpha1"
 	"github.com/luxas/kube-rebac-authorizer/internal/forked/kuberbacreconciliation"
 	"github.com/luxas/kube-rebac-authorizer/pkg/authorizer"
 	"github.com/luxas/kube-rebac-authorizer/pkg/authorizer/authzwebhook"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/accessrequestsyncer"
 	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/clusterrolebindingsyncer"
 	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/clusterrolesyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/garbagecollector"
 	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/genericsyncer"
 	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/rolebindingsyncer"
 	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/rolesyncer"
@@ -72,14 +74,14 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
-	// // TODO: Reactivate when adding the CRD API
-	// utilruntime.Must(rebacv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(rebacv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
-	var configFileFlag string
+	var configFileFlag, diffConfigFileFlag string
 	flag.StringVar(&configFileFlag, "config", "", "From where to load declarative config")
+	flag.StringVar(&diffConfigFileFlag, "diff-config", "", "If set, print how the authorization model of the config differs from the one of this old config, and exit")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -88,38 +90,83 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if len(diffConfigFileFlag) != 0 {
+		if err := diffConfigs(diffConfigFileFlag, configFileFlag); err != nil {
+			setupLog.Error(err, "failed to diff the authorization models")
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := run(configFileFlag); err != nil {
 		setupLog.Error(err, "failed to setup the application")
 		os.Exit(1)
 	}
 }
 
-func run(configFileFlag string) error {
-
-	if len(configFileFlag) == 0 {
-		return errors.New("a config file is required")
+// loadConfig reads, defaults and validates the config file
+func loadConfig(configFile string) (*Config, error) {
+	if len(configFile) == 0 {
+		return nil, errors.New("a config file is required")
 	}
 
-	cfgBytes, err := os.ReadFile(configFileFlag)
+	cfgBytes, err := os.ReadFile(configFile)
 	if err != nil {
-		return fmt.Errorf("unable to read config: %w", err)
+		return nil, fmt.Errorf("unable to read config: %w", err)
 	}
 
-	cfg := Config{
+	cfg := &Config{
 		MetricsAddr:    ":9001",
 		ProbeAddr:      ":9002",
 		AuthorizerAddr: ":9443",
 		HTTPSCertDir:   ".rebac",
 	}
-	err = yaml.UnmarshalStrict(cfgBytes, &cfg)
+	err = yaml.UnmarshalStrict(cfgBytes, cfg)
 	if err != nil {
-		return fmt.Errorf("unable to decode config: %w", err)
+		return nil, fmt.Errorf("unable to decode config: %w", err)
 	}
 
 	cfg.DynamicDefault()
 	err = cfg.Validate()
 	if err != nil {
-		return fmt.Errorf("config is invalid: %w", err)
+		return nil, fmt.Errorf("config is invalid: %w", err)
+	}
+	return cfg, nil
+}
+
+// authorizationSchema returns the authorization schema of all types synced with the config
+func authorizationSchema(cfg *Config) (zanzibar.AuthorizationSchema, error) {
+	as := rbacconversion.GetSchema()
+	as.Types = append(as.Types, nodeauth.GetSchema().Types...)
+	if cfg.AccessRequests != nil {
+		as.Types = append(as.Types, accessrequestsyncer.GetTypeRelation(cfg.AccessRequests.Targets))
+	}
+	return as, as.Validate()
+}
+
+// diffConfigs prints how the authorization model of the new config differs from the one of the old config,
+// e.g. for reviewing a config change before it is rolled out
+func diffConfigs(oldConfigFile, newConfigFile string) error {
+	schemas := make([]zanzibar.AuthorizationSchema, 0, 2)
+	for _, configFile := range []string{oldConfigFile, newConfigFile} {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", configFile, err)
+		}
+		as, err := authorizationSchema(cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", configFile, err)
+		}
+		schemas = append(schemas, as)
+	}
+	fmt.Print(openfga.DiffAuthorizationSchemas(schemas[0], schemas[1]).String())
+	return nil
+}
+
+func run(configFileFlag string) error {
+	cfg, err := loadConfig(configFileFlag)
+	if err != nil {
+		return err
 	}
 
 	authzHost, authzPortStr, err := net.SplitHostPort(cfg.AuthorizerAddr)
@@ -213,8 +260,10 @@ func run(configFileFlag string) error {
 		return fmt.Errorf("unable to get store %q: %w", cfg.StoreName, err)
 	}
 
-	as := rbacconversion.GetSchema()
-	as.Types = append(as.Types, nodeauth.GetSchema().Types...)
+	as, err := authorizationSchema(cfg)
+	if err != nil {
+		return err
+	}
 	// TODO: Should we have something like that the client will refuse to write a tuple when it
 	// sees its own authorization schema is "too old"?
 	openfgaTupleStore, err := storeClient.WithAuthorizationSchema(ctx, as)
@@ -222,14 +271,22 @@ func run(configFileFlag string) error {
 		return fmt.Errorf("unable to write authorization model: %w", err)
 	}
 
+	// the writes of the RBAC and AccessRequest controllers go through the high-water mark, such that the
+	// authorizer can wait for them, e.g. right after a RoleBinding was created. The bulk writes of pods,
+	// nodes and the garbage collector do not, as authorization decisions should not wait for them.
+	tupleStore := zanzibar.NewHighWaterMark(openfgaTupleStore)
+
 	converter := &rbacconversion.GenericConverter{}
+	recorder := mgr.GetEventRecorderFor("kube-rebac-authorizer")
 
 	if err = (&clusterrolesyncer.ClusterRoleReconciler{
 		Client:        mgr.GetClient(),
 		Scheme:        mgr.GetScheme(),
 		RBACConverter: converter,
-		Zanzibar:      openfgaTupleStore,
+		Zanzibar:      tupleStore,
 		TypeRelation:  &as.Types[3],
+		HighWaterMark: tupleStore,
+		Recorder:      recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterRole")
 		return err
@@ -239,8 +296,10 @@ func run(configFileFlag string) error {
 		Client:        mgr.GetClient(),
 		Scheme:        mgr.GetScheme(),
 		RBACConverter: converter,
-		Zanzibar:      openfgaTupleStore,
+		Zanzibar:      tupleStore,
 		TypeRelation:  &as.Types[0],
+		HighWaterMark: tupleStore,
+		Recorder:      recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterRoleBinding")
 		return err
@@ -250,8 +309,10 @@ func run(configFileFlag string) error {
 		Client:        mgr.GetClient(),
 		Scheme:        mgr.GetScheme(),
 		RBACConverter: converter,
-		Zanzibar:      openfgaTupleStore,
+		Zanzibar:      tupleStore,
 		TypeRelation:  &as.Types[2],
+		HighWaterMark: tupleStore,
+		Recorder:      recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Role")
 		return err
@@ -261,12 +322,22 @@ func run(configFileFlag string) error {
 		Client:        mgr.GetClient(),
 		Scheme:        mgr.GetScheme(),
 		RBACConverter: converter,
-		Zanzibar:      openfgaTupleStore,
+		Zanzibar:      tupleStore,
 		TypeRelation:  &as.Types[1],
+		HighWaterMark: tupleStore,
+		Recorder:      recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "RoleBinding")
 		return err
 	}
+	// the garbage collector considers the tuples of all kinds synced by the controllers
+	gcSources := []garbagecollector.Source{
+		{GVK: rbacv1.SchemeGroupVersion.WithKind("ClusterRoleBinding"), TypeRelation: &as.Types[0]},
+		{GVK: rbacv1.SchemeGroupVersion.WithKind("RoleBinding"), TypeRelation: &as.Types[1]},
+		{GVK: rbacv1.SchemeGroupVersion.WithKind("Role"), TypeRelation: &as.Types[2]},
+		{GVK: rbacv1.SchemeGroupVersion.WithKind("ClusterRole"), TypeRelation: &as.Types[3], Filter: rbacconversion.FilterSelectedLabelSetsOf},
+	}
+
 	genericControllerGVKs := []schema.GroupVersionKind{
 		v1.SchemeGroupVersion.WithKind("Node"),
 		v1.SchemeGroupVersion.WithKind("Pod"),
@@ -282,23 +353,72 @@ func run(configFileFlag string) error {
 			return err
 		}
 
+		gcSources = append(gcSources, garbagecollector.Source{GVK: gvk, TypeRelation: matchedType})
+
 		if err = (&genericsyncer.GenericTupleReconciler{
 			Client:       mgr.GetClient(),
 			Scheme:       mgr.GetScheme(),
 			Zanzibar:     openfgaTupleStore,
 			TypeRelation: matchedType,
 			GVK:          gvk,
+			Recorder:     recorder,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Generic"+gvk.Kind)
 			return err
 		}
 	}
 
+	if cfg.AccessRequests != nil {
+		accessRequestType, err := util.MatchOne(as.Types, func(tr zanzibar.TypeRelation) bool {
+			return tr.TypeName == accessrequestsyncer.TypeAccessRequest
+		})
+		if err != nil {
+			return err
+		}
+
+		gcSources = append(gcSources, garbagecollector.Source{
+			GVK:          rebacv1alpha1.GroupVersion.WithKind("AccessRequest"),
+			TypeRelation: accessRequestType,
+			// the phase is only updated by the next reconcile, which might not have happened yet
+			Active: accessrequestsyncer.ActiveObject,
+		})
+
+		if err = (&accessrequestsyncer.AccessRequestReconciler{
+			Client:            mgr.GetClient(),
+			Scheme:            mgr.GetScheme(),
+			Zanzibar:          tupleStore,
+			Checker:           openfgaTupleStore,
+			TypeRelation:      accessRequestType,
+			Targets:           cfg.AccessRequests.Targets,
+			RequiredApprovals: cfg.AccessRequests.RequiredApprovals,
+			MaxDuration:       cfg.AccessRequests.MaxDuration.Duration,
+			Recorder:          recorder,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "AccessRequest")
+			return err
+		}
+	}
+
+	if cfg.GarbageCollection != nil {
+		if err := (&garbagecollector.GarbageCollector{
+			Reader:   mgr.GetAPIReader(),
+			Scheme:   mgr.GetScheme(),
+			Zanzibar: openfgaTupleStore,
+			Sources:  gcSources,
+			Interval: cfg.GarbageCollection.Interval.Duration,
+			DryRun:   cfg.GarbageCollection.DryRun,
+		}).SetupWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to set up garbage collection: %w", err)
+		}
+	}
+
 	//+kubebuilder:scaffold:builder
 
 	authz := &authorizer.ReBACAuthorizer{
 		Checker:             openfgaTupleStore,
 		AuthorizationSchema: as,
+		HighWaterMark:       tupleStore,
+		RESTMapper:          mgr.GetRESTMapper(),
 	}
 
 	// Register the webhook server's authorization endpoint. The server will be started at mgr.Start
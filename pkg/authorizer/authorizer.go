@@ -4,15 +4,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/luxas/kube-rebac-authorizer/pkg/nodeauth"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
 	"github.com/luxas/kube-rebac-authorizer/pkg/util"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 // ReBACAuthorizer must implement Authorizer
@@ -21,8 +29,52 @@ var _ authorizer.Authorizer = &ReBACAuthorizer{}
 type ReBACAuthorizer struct {
 	Checker             zanzibar.Checker
 	AuthorizationSchema zanzibar.AuthorizationSchema
+
+	// HighWaterMark is optional. If set, the authorizer waits for the writes in flight through it, and
+	// the changes observed by the controllers using it, to complete before checking, such that e.g. a
+	// newly created RoleBinding that is being reconciled by the controllers is taken into account.
+	HighWaterMark *zanzibar.HighWaterMark
+	// ConsistencyTimeout bounds how long to wait for the HighWaterMark. After the timeout, the checks
+	// are performed anyways. Defaults to DefaultConsistencyTimeout.
+	ConsistencyTimeout time.Duration
+	// CheckTimeout bounds how long all the check requests of one request can take together, as they
+	// are sent in one batch. After the timeout, the request gets no opinion. Defaults to DefaultCheckTimeout.
+	CheckTimeout time.Duration
+
+	// RESTMapper is optional. It resolves the kind and scope of the resources of requests for individual
+	// objects, such that they can be checked against the types of the authorization schema, see
+	// nodeauth.GVKToTypeName. The discovery-backed RESTMapper of the manager also resolves resources of
+	// CustomResourceDefinitions created later on. Defaults to mapping the nodes, pods and secrets resources.
+	RESTMapper meta.RESTMapper
+	// NoMatchInterval is the minimum time between resolving resources the RESTMapper does not know. The
+	// discovery-backed RESTMapper reloads discovery from the API server for every unknown resource, which
+	// any user could otherwise make the authorizer do at request rate. During the interval, only resources
+	// resolved before are resolved, and others are treated as unknown. Defaults to DefaultNoMatchInterval.
+	NoMatchInterval time.Duration
+	// Clock is optional, and defaults to the real clock.
+	Clock clock.PassiveClock
+
+	resources resourceCache
+}
+
+// resourceCache caches the resources resolved by the RESTMapper, and when it last did not know a resource.
+type resourceCache struct {
+	mu          sync.Mutex
+	resolved    map[schema.GroupVersionResource]resolvedResource
+	lastNoMatch time.Time
 }
 
+type resolvedResource struct {
+	gvk        schema.GroupVersionKind
+	namespaced bool
+}
+
+const (
+	DefaultConsistencyTimeout = 1 * time.Second
+	DefaultCheckTimeout       = 3 * time.Second
+	DefaultNoMatchInterval    = 10 * time.Second
+)
+
 const (
 	RBACMatchAllAPIGroups = rbacv1.APIGroupAll
 	RBACMatchAllResources = rbacv1.ResourceAll
@@ -56,6 +108,10 @@ func (a *ReBACAuthorizer) Authorize(ctx context.Context, attrs authorizer.Attrib
 		return authorizer.DecisionNoOpinion, "", nil
 	}
 
+	if !attrs.IsResourceRequest() {
+		return a.check(ctx, attrs, nonResourceCheckRequests(user, attrs.GetPath(), attrs.GetVerb(), contextualTuples))
+	}
+
 	fullResource := attrs.GetResource()
 	hasSubresource := false
 	if len(attrs.GetSubresource()) != 0 {
@@ -65,37 +121,33 @@ func (a *ReBACAuthorizer) Authorize(ctx context.Context, attrs authorizer.Attrib
 	resourceNode := resourceNodeFunc(attrs.GetAPIGroup(), fullResource)
 	checkNode := resourceNode
 
-	if attrs.IsResourceRequest() {
-		// TODO: Is it worth caching this? Probably not?
-		wildcardNodes := make([]zanzibar.Node, 0, 5)
-		// this request matches resource:*.*
-		wildcardNodes = append(wildcardNodes, resourceNodeFunc(RBACMatchAllAPIGroups, RBACMatchAllResources))
-		// this request matches resource:{apiGroup}.*
-		wildcardNodes = append(wildcardNodes, resourceNodeFunc(attrs.GetAPIGroup(), RBACMatchAllResources))
-		// this request matches resource:*.{fullResource}
-		wildcardNodes = append(wildcardNodes, resourceNodeFunc(RBACMatchAllAPIGroups, fullResource))
-
-		// replicate behavior of rbacv1helpers.ResourceMatches; if this request is for a subresource,
-		// then match an RBAC rule of the form *.*/{subresource} and {apiGroup}.*/{subresource} too
-		// TODO: test this explicitly
-		if hasSubresource {
-			subresourceMatch := RBACMatchAllResources + "/" + attrs.GetSubresource()
-			// this request matches resource:*.*/{subresource}
-			wildcardNodes = append(wildcardNodes, resourceNodeFunc(RBACMatchAllAPIGroups, subresourceMatch))
-			// this request matches resource:{apiGroup}.*/{subresource}
-			wildcardNodes = append(wildcardNodes, resourceNodeFunc(attrs.GetAPIGroup(), subresourceMatch))
-		}
+	// TODO: Is it worth caching this? Probably not?
+	wildcardNodes := make([]zanzibar.Node, 0, 5)
+	// this request matches resource:*.*
+	wildcardNodes = append(wildcardNodes, resourceNodeFunc(RBACMatchAllAPIGroups, RBACMatchAllResources))
+	// this request matches resource:{apiGroup}.*
+	wildcardNodes = append(wildcardNodes, resourceNodeFunc(attrs.GetAPIGroup(), RBACMatchAllResources))
+	// this request matches resource:*.{fullResource}
+	wildcardNodes = append(wildcardNodes, resourceNodeFunc(RBACMatchAllAPIGroups, fullResource))
 
-		// add all these wildcard matches to the contextual tuples
-		contextualTuples = append(contextualTuples, zanzibar.
-			NewNodes(wildcardNodes...).WithRelation(ContextualRelationWildcardMatch).To(resourceNode)...)
-	} else {
-		fmt.Println("TODO")
-		// TODO: handle non-resource request path lookups! and maybe split into two functions
+	// replicate behavior of rbacv1helpers.ResourceMatches; if this request is for a subresource,
+	// then match an RBAC rule of the form *.*/{subresource} and {apiGroup}.*/{subresource} too
+	// TODO: test this explicitly
+	if hasSubresource {
+		subresourceMatch := RBACMatchAllResources + "/" + attrs.GetSubresource()
+		// this request matches resource:*.*/{subresource}
+		wildcardNodes = append(wildcardNodes, resourceNodeFunc(RBACMatchAllAPIGroups, subresourceMatch))
+		// this request matches resource:{apiGroup}.*/{subresource}
+		wildcardNodes = append(wildcardNodes, resourceNodeFunc(attrs.GetAPIGroup(), subresourceMatch))
 	}
 
+	// add all these wildcard matches to the contextual tuples
+	contextualTuples = append(contextualTuples, zanzibar.
+		NewNodes(wildcardNodes...).WithRelation(ContextualRelationWildcardMatch).To(resourceNode)...)
+
 	// TODO: Add resource name support also using contextual tuples; not dedicated types (for now?)
-	// TODO: Is namespace actually empty for cluster-wide resources?
+	// Requests for cluster-scoped resources usually have no namespace, except for namespaces themselves,
+	// e.g. "get namespaces foo" is in namespace foo, such that RoleBindings in foo apply just like in RBAC.
 	if len(attrs.GetNamespace()) != 0 {
 		contextualTuples = append(contextualTuples, user.WithRelation(ContextualRelationOperatesInNamespace).ToOne(rbacconversion.NamespaceNode(attrs.GetNamespace())))
 	}
@@ -114,60 +166,199 @@ func (a *ReBACAuthorizer) Authorize(ctx context.Context, attrs authorizer.Attrib
 		checkNode = instanceresourceNode
 	}
 
-	// issue the check request
-	allowed, err := a.Checker.CheckOne(ctx, user.WithRelation(attrs.GetVerb()).ToOne(checkNode), contextualTuples)
+	requests := []zanzibar.CheckRequest{{
+		Tuple:            user.WithRelation(attrs.GetVerb()).ToOne(checkNode),
+		ContextualTuples: contextualTuples,
+	}}
+	if individual := a.individualCheckRequest(ctx, attrs, user, contextualTuples); individual != nil {
+		requests = append(requests, *individual)
+	}
+	return a.check(ctx, attrs, requests)
+}
+
+// nonResourceCheckRequests returns the check request for a non-resource request, e.g. "get /healthz".
+// RBAC rules with a trailing "*" matching the path allow the request through contextual tuples, just
+// like wildcard resource rules.
+func nonResourceCheckRequests(user zanzibar.Node, path, verb string, contextualTuples []Tuple) []zanzibar.CheckRequest {
+	checkNode := rbacconversion.NonResourceNode(path)
+	contextualTuples = append(contextualTuples, rbacconversion.
+		NonResourceWildcardNodes(path).WithRelation(ContextualRelationWildcardMatch).To(checkNode)...)
+
+	return []zanzibar.CheckRequest{{
+		Tuple:            user.WithRelation(verb).ToOne(checkNode),
+		ContextualTuples: contextualTuples,
+	}}
+}
+
+// check issues the check requests, and allows the request if any of them is allowed, unless the request
+// is denied; see denyCheckRequests. The first request is the main one, only its errors are returned.
+// If a deny relation cannot be checked, e.g. as the backend is unavailable, the request is denied, as
+// it is unknown whether it is denied, and no later authorizer must allow it either.
+func (a *ReBACAuthorizer) check(ctx context.Context, attrs authorizer.Attributes, requests []zanzibar.CheckRequest) (authorizer.Decision, string, error) {
+	a.waitForConsistency(ctx)
+
+	denyRequests := a.denyCheckRequests(requests)
+	allowRequests := len(requests)
+
+	// issue all check requests at once, and return as soon as one of them denies, or one of them allows
+	// and there is nothing that could deny
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	allowed := false
+	var err error
+	for result := range a.batchCheck(ctx, append(requests, denyRequests...)) {
+		if result.Index >= allowRequests {
+			denied := denyRequests[result.Index-allowRequests].Tuple
+			if result.Allowed {
+				return authorizer.DecisionDeny, fmt.Sprintf("ReBAC: denied through the %s relation of %s:%s", denied.Relation, denied.Object.NodeType(), denied.Object.NodeName()), nil
+			}
+			if result.Err != nil {
+				// fail closed; an error would make the API server ask the later authorizers instead
+				log.FromContext(ctx).Error(result.Err, "deny check failed", "tuple", denied.String())
+				return authorizer.DecisionDeny, fmt.Sprintf("ReBAC: denied as the %s relation of %s:%s could not be checked", denied.Relation, denied.Object.NodeType(), denied.Object.NodeName()), nil
+			}
+			continue
+		}
+		if result.Allowed {
+			if len(denyRequests) == 0 {
+				return authorizer.DecisionAllow, "", nil
+			}
+			allowed = true
+		}
+		// TODO: figure out if the relation exists in the model before checking the individual object,
+		// to avoid it leaking to the user in the reason. Thus we ignore its error for now
+		if result.Index == 0 {
+			err = result.Err
+		}
+	}
+
 	if allowed {
 		return authorizer.DecisionAllow, "", nil
 	}
+	return noOpinion(ctx, err)
+}
 
-	// try to check it
-	individualAllowed, individualErr := a.resolveIndividual(ctx, attrs, user, contextualTuples)
-	if individualAllowed {
-		return authorizer.DecisionAllow, "", nil
+// denyCheckRequests returns the check requests for the deny relations of the requests, if the authorization
+// schema declares any, see zanzibar.TypeRelation.DenyRelations.
+func (a *ReBACAuthorizer) denyCheckRequests(requests []zanzibar.CheckRequest) []zanzibar.CheckRequest {
+	denyRequests := make([]zanzibar.CheckRequest, 0, len(requests))
+	for _, req := range requests {
+		denyRelation, ok := a.AuthorizationSchema.DenyRelation(req.Tuple.Object.NodeType(), req.Tuple.Relation)
+		if !ok {
+			continue
+		}
+		denyRequests = append(denyRequests, zanzibar.CheckRequest{
+			Tuple:            req.Tuple.User.WithRelation(denyRelation).ToOne(req.Tuple.Object),
+			ContextualTuples: req.ContextualTuples,
+		})
+	}
+	return denyRequests
+}
+
+// noOpinion returns NoOpinion, and depending on the error of the check, also an error, such that
+// the API server does not cache the decision when the backend is only temporarily unavailable.
+func noOpinion(ctx context.Context, err error) (authorizer.Decision, string, error) {
+	switch {
+	case err == nil:
+		return authorizer.DecisionNoOpinion, "", nil
+	case errors.Is(err, zanzibar.ErrUnknownType), errors.Is(err, zanzibar.ErrUnknownRelation):
+		// the request cannot be expressed in the authorization model, so there are no tuples allowing it
+		log.FromContext(ctx).V(1).Info("request not covered by the authorization model", "error", err.Error())
+		return authorizer.DecisionNoOpinion, "", nil
+	case errors.Is(err, zanzibar.ErrBackendUnavailable):
+		return authorizer.DecisionNoOpinion, "ReBAC backend unavailable", err
 	}
-	err = errors.Join(err, individualErr)
+	// other errors are not expected for well-formed requests, so raise them for alerting
+	log.FromContext(ctx).Error(err, "check failed")
+	// TODO: How to not leak sensitive info here?
+	return authorizer.DecisionNoOpinion, fmt.Sprintf("ReBAC error: %v", err), nil
+}
+
+// waitForConsistency waits for the writes in flight to complete, and the checker to be consistent
+// with them, but at most ConsistencyTimeout. It is best-effort; if waiting fails, the checks are
+// performed anyways, as being unable to authorize at all would be worse.
+func (a *ReBACAuthorizer) waitForConsistency(ctx context.Context) {
+	if a.HighWaterMark == nil {
+		return
+	}
+	timeout := a.ConsistencyTimeout
+	if timeout == 0 {
+		timeout = DefaultConsistencyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	token, err := a.HighWaterMark.Wait(ctx)
+	if err == nil {
+		err = zanzibar.WaitForConsistency(ctx, a.Checker, token)
+	}
+	if err != nil {
+		log.FromContext(ctx).Info("checking without waiting for consistency", "token", token, "error", err.Error())
+	}
+}
 
-	reason := ""
-	if err != nil { // TODO: How to not leak sensitive info here?
-		reason = fmt.Sprintf("ReBAC error: %v", err)
+func (a *ReBACAuthorizer) batchCheck(ctx context.Context, requests []zanzibar.CheckRequest) <-chan zanzibar.CheckResult {
+	timeout := a.CheckTimeout
+	if timeout == 0 {
+		timeout = DefaultCheckTimeout
 	}
-	return authorizer.DecisionNoOpinion, reason, nil
+	return zanzibar.BatchCheckWithTimeout(ctx, a.Checker, requests, timeout)
 }
 
-func (a *ReBACAuthorizer) resolveIndividual(ctx context.Context, attrs authorizer.Attributes, user zanzibar.Node, contextualTuples []zanzibar.Tuple) (bool, error) {
+// individualCheckRequest returns the check request for the individual object the request is for,
+// if the authorization schema has a type for it. Otherwise nil is returned.
+func (a *ReBACAuthorizer) individualCheckRequest(ctx context.Context, attrs authorizer.Attributes, user zanzibar.Node, contextualTuples []zanzibar.Tuple) *zanzibar.CheckRequest {
 	// this requires a individual object
 	if attrs.GetName() == "" {
-		return false, nil
+		return nil
+	}
+
+	// avoid resolving resources through discovery when the authorization schema has no types in their API group
+	groupTypePrefix := nodeauth.GVKToTypeName(schema.GroupVersionKind{Group: attrs.GetAPIGroup()})
+	if !slices.ContainsFunc(a.AuthorizationSchema.Types, func(tr zanzibar.TypeRelation) bool {
+		return strings.HasPrefix(tr.TypeName, groupTypePrefix)
+	}) {
+		return nil
 	}
 
 	// Try to check for individual access
-	gvk := toGVK(schema.GroupVersionResource{
+	gvr := schema.GroupVersionResource{
 		Group:    attrs.GetAPIGroup(),
 		Version:  attrs.GetAPIVersion(),
 		Resource: attrs.GetResource(),
-	})
+	}
+	gvk, namespaced, err := a.resolveResource(gvr)
+	if meta.IsNoMatchError(err) {
+		return nil // the resource does not exist, so neither does a type for its individual objects
+	} else if err != nil {
+		log.FromContext(ctx).Error(err, "couldn't resolve the kind of the resource", "resource", gvr.String())
+		return nil
+	}
 	typeName := nodeauth.GVKToTypeName(gvk)
 
-	_, err := util.MatchOne(a.AuthorizationSchema.Types, func(tr zanzibar.TypeRelation) bool {
+	tr, err := util.MatchOne(a.AuthorizationSchema.Types, func(tr zanzibar.TypeRelation) bool {
 		return tr.TypeName == typeName
 	})
-	if err != nil {
-		return false, nil // TODO: log error as it is a schema problem, but zero is not a problem
+	if errors.Is(err, util.ErrMultipleMatches) {
+		log.FromContext(ctx).Error(err, "the authorization schema declares the type more than once", "type", typeName)
+		return nil
+	} else if err != nil {
+		return nil // there is no type for individual objects of this resource
 	}
 
-	// TODO: Can we always rely on namespace being empty here for all non-namespaced resources?
-	nodeID := nodeauth.GenericNodeID(attrs.GetNamespace(), attrs.GetName())
+	namespace := attrs.GetNamespace()
+	if !namespaced {
+		// e.g. "get namespaces foo" is in namespace foo, but the object is cluster-scoped
+		namespace = ""
+	}
+	nodeID := tr.EncodeID(namespace, attrs.GetName())
 
 	checkNode := zanzibar.NewNode(typeName, nodeID)
 
-	// TODO: figure out if the relation exists in the model before checking, to avoid it leaking to the user in the reason
-	// Thus we ignore the error for now
-	allowed, _ := a.Checker.CheckOne(ctx, user.WithRelation(attrs.GetVerb()).ToOne(checkNode), contextualTuples)
-	if allowed {
-		return true, nil
+	return &zanzibar.CheckRequest{
+		Tuple:            user.WithRelation(attrs.GetVerb()).ToOne(checkNode),
+		ContextualTuples: contextualTuples,
 	}
-
-	return false, nil
 }
 
 // userNodeFor returns the starting user node, and contextual tuples linking the user node to
@@ -187,20 +378,73 @@ func userNodeFor(u user.Info) (zanzibar.Node, []Tuple) {
 	return userNode, contextualTuples
 }
 
-// TODO: real lookup implementation
-func toGVK(gvr schema.GroupVersionResource) schema.GroupVersionKind {
-	kind := ""
-	switch gvr.Resource {
-	case "nodes":
-		kind = "Node"
-	case "pods":
-		kind = "Pod"
-	case "secrets":
-		kind = "Secret"
-	}
-	return schema.GroupVersionKind{
-		Group:   gvr.Group,
-		Version: gvr.Version,
-		Kind:    kind,
+// defaultRESTMapper maps the resources of the nodeauth schema, for when no RESTMapper is set
+var defaultRESTMapper = func() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Node"), meta.RESTScopeRoot)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	return mapper
+}()
+
+// resolveResource returns the kind of the resource, and whether its objects are namespaced.
+// A NoMatch error is returned if the resource does not exist, see meta.IsNoMatchError.
+// The RESTMapper is asked for unknown resources at most once per NoMatchInterval.
+func (a *ReBACAuthorizer) resolveResource(gvr schema.GroupVersionResource) (schema.GroupVersionKind, bool, error) {
+	// SubjectAccessReviews can be for all versions of the resource
+	if gvr.Version == "*" {
+		gvr.Version = ""
+	}
+	interval := a.NoMatchInterval
+	if interval == 0 {
+		interval = DefaultNoMatchInterval
+	}
+
+	a.resources.mu.Lock()
+	resolved, ok := a.resources.resolved[gvr]
+	throttled := a.now().Before(a.resources.lastNoMatch.Add(interval))
+	a.resources.mu.Unlock()
+	if ok {
+		return resolved.gvk, resolved.namespaced, nil
+	} else if throttled {
+		return schema.GroupVersionKind{}, false, &meta.NoResourceMatchError{PartialResource: gvr}
+	}
+
+	gvk, namespaced, err := a.mapResource(gvr)
+
+	a.resources.mu.Lock()
+	defer a.resources.mu.Unlock()
+	if meta.IsNoMatchError(err) {
+		a.resources.lastNoMatch = a.now()
+	} else if err == nil {
+		if a.resources.resolved == nil {
+			a.resources.resolved = map[schema.GroupVersionResource]resolvedResource{}
+		}
+		a.resources.resolved[gvr] = resolvedResource{gvk: gvk, namespaced: namespaced}
+	}
+	return gvk, namespaced, err
+}
+
+// mapResource resolves the resource through the RESTMapper.
+func (a *ReBACAuthorizer) mapResource(gvr schema.GroupVersionResource) (schema.GroupVersionKind, bool, error) {
+	mapper := a.RESTMapper
+	if mapper == nil {
+		mapper = defaultRESTMapper
+	}
+	gvk, err := mapper.KindFor(gvr)
+	if err != nil {
+		return schema.GroupVersionKind{}, false, err
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupVersionKind{}, false, err
+	}
+	return gvk, mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+func (a *ReBACAuthorizer) now() time.Time {
+	if a.Clock == nil {
+		return time.Now()
 	}
+	return a.Clock.Now()
 }
@@ -3,13 +3,22 @@ package authorizer
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/nodeauth"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion/rbacconversiontesting"
 	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
+	clocktesting "k8s.io/utils/clock/testing"
 )
 
 func newResourceReq(verb, apiGroup, resource, subresource string) attrsFunc {
@@ -33,6 +42,20 @@ func newNsResourceReq(verb, apiGroup, resource, subresource, namespace string) a
 	}
 }
 
+func newNonResourceReq(verb, path string) attrsFunc {
+	return func(usr user.Info) authorizer.AttributesRecord {
+		return authorizer.AttributesRecord{
+			User: &user.DefaultInfo{
+				Name:   usr.GetName(),
+				Groups: usr.GetGroups(),
+			},
+			Verb:            verb,
+			Path:            path,
+			ResourceRequest: false,
+		}
+	}
+}
+
 type attrsFunc func(user.Info) authorizer.AttributesRecord
 
 func (f attrsFunc) withName(name string) attrsFunc {
@@ -76,6 +99,67 @@ func Test_authorizerImpl_Authorize(t *testing.T) {
 			},
 			want: authorizer.DecisionNoOpinion,
 		},
+		{
+			name: "system:masters can use any verb on any non-resource URL",
+			user: user.DefaultInfo{Name: "foo", Groups: []string{"system:masters"}},
+			attrsFuncs: []attrsFunc{
+				newNonResourceReq("get", "/metrics"),
+				newNonResourceReq("post", "/logs/kube-apiserver.log"),
+				newNonResourceReq("delete", "/debug/pprof/profile"),
+				newNonResourceReq("options", "/"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "any authenticated user can get discovery and public info non-resource URLs",
+			user: user.DefaultInfo{Name: "any", Groups: []string{"system:authenticated"}},
+			attrsFuncs: []attrsFunc{
+				newNonResourceReq("get", "/healthz"),
+				newNonResourceReq("get", "/livez"),
+				newNonResourceReq("get", "/version"),
+				newNonResourceReq("get", "/version/"),
+				newNonResourceReq("get", "/api"),
+				newNonResourceReq("get", "/apis"),
+				// matches the /apis/* and /openapi/* rules
+				newNonResourceReq("get", "/apis/apps/v1"),
+				newNonResourceReq("get", "/openapi/v3/apis/apps/v1"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "any authenticated user cannot get other non-resource URLs, or use other verbs",
+			user: user.DefaultInfo{Name: "any", Groups: []string{"system:authenticated"}},
+			attrsFuncs: []attrsFunc{
+				newNonResourceReq("get", "/metrics"),
+				newNonResourceReq("get", "/healthz/etcd"),
+				newNonResourceReq("get", "/healthzfoo"),
+				newNonResourceReq("get", "/version/foo"),
+				newNonResourceReq("get", "/"),
+				newNonResourceReq("post", "/healthz"),
+				newNonResourceReq("delete", "/apis/apps/v1"),
+			},
+			want: authorizer.DecisionNoOpinion,
+		},
+		{
+			name: "the system:monitoring group can get metrics and individual health checks",
+			user: user.DefaultInfo{Name: "prometheus", Groups: []string{"system:monitoring"}},
+			attrsFuncs: []attrsFunc{
+				newNonResourceReq("get", "/metrics"),
+				newNonResourceReq("get", "/metrics/slis"),
+				newNonResourceReq("get", "/healthz/etcd"),
+				newNonResourceReq("get", "/readyz/informer-sync"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "non-resource verbs not used by the API server are not supported",
+			user: user.DefaultInfo{Name: "foo", Groups: []string{"system:masters"}},
+			attrsFuncs: []attrsFunc{
+				newNonResourceReq("list", "/metrics"),
+			},
+			want:       authorizer.DecisionNoOpinion,
+			wantReason: "authorizer does not support non-resource verb: list",
+		},
 		{
 			name: "kcm can list/watch anything, create events, and more",
 			user: user.DefaultInfo{Name: "system:kube-controller-manager"},
@@ -196,6 +280,56 @@ func Test_authorizerImpl_Authorize(t *testing.T) {
 			},
 			want: authorizer.DecisionNoOpinion,
 		},
+		{
+			name: "a user bound to the edit clusterrole through a rolebinding can edit resources in its namespace",
+			user: user.DefaultInfo{Name: "test:user-ns-edit"},
+			attrsFuncs: []attrsFunc{
+				// aggregate-to-view examples
+				newNsResourceReq("get", "", "services", "", "default"),
+				newNsResourceReq("list", "autoscaling", "horizontalpodautoscalers", "", "default"),
+				// aggregate-to-edit examples
+				newNsResourceReq("create", "", "pods", "proxy", "default"),
+				newNsResourceReq("deletecollection", "apps", "deployments", "", "default"),
+				newNsResourceReq("update", "", "configmaps", "", "default").withName("foo"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "a user bound to the edit clusterrole through a rolebinding cannot access other namespaces or cluster-scoped resources",
+			user: user.DefaultInfo{Name: "test:user-ns-edit"},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("get", "", "services", "", "kube-system"),
+				newNsResourceReq("deletecollection", "apps", "deployments", "", "kube-public"),
+				newNsResourceReq("update", "", "configmaps", "", "kube-system").withName("foo"),
+				// across all namespaces
+				newResourceReq("list", "", "pods", ""),
+				newResourceReq("get", "", "namespaces", "").withName("default"),
+				// negative aggregate-to-admin examples
+				newNsResourceReq("update", "rbac.authorization.k8s.io", "roles", "", "default"),
+				// rolebindings never grant non-resource URLs
+				newNonResourceReq("get", "/metrics"),
+			},
+			want: authorizer.DecisionNoOpinion,
+		},
+		{
+			name: "a group bound to the view clusterrole through a rolebinding can only view resources in its namespace",
+			user: user.DefaultInfo{Name: "any", Groups: []string{"test:ns-viewers"}},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("get", "", "services", "", "kube-public"),
+				newNsResourceReq("watch", "policy", "poddisruptionbudgets", "status", "kube-public"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "a group bound to the view clusterrole through a rolebinding cannot change resources, or view other namespaces",
+			user: user.DefaultInfo{Name: "any", Groups: []string{"test:ns-viewers"}},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("update", "", "services", "", "kube-public"),
+				newNsResourceReq("get", "", "services", "", "default"),
+				newResourceReq("list", "", "services", ""),
+			},
+			want: authorizer.DecisionNoOpinion,
+		},
 		{ // NOTE: the serviceaccount is in kube-system, but the accessed resources in kube-public
 			name: "the bootstrap signer shall be able to access events and configmaps in kube-public",
 			user: user.DefaultInfo{Name: "system:serviceaccount:kube-system:bootstrap-signer"},
@@ -288,16 +422,15 @@ func Test_authorizerImpl_Authorize(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	// TODO: These now require OpenFGA to be serving on localhost:8081
-	debug, openfgaimpl := rbacconversiontesting.SetupIntegrationTest(ctx, t)
+	debug, backend := rbacconversiontesting.SetupIntegrationTest(ctx, t)
 	defer debug()
 
-	if openfgaimpl == nil {
+	if backend == nil {
 		return
 	}
 
 	a := &ReBACAuthorizer{
-		Checker: openfgaimpl,
+		Checker: backend,
 	}
 
 	for _, tt := range tests {
@@ -322,6 +455,430 @@ func Test_authorizerImpl_Authorize(t *testing.T) {
 	}
 }
 
+// denySchema extends the RBAC schema such that nobody outside the sre group may exec into pods in
+// kube-system, not even cluster admins.
+func denySchema() zanzibar.AuthorizationSchema {
+	as := rbacconversion.GetSchema()
+	for i := range as.Types {
+		tr := &as.Types[i]
+		switch tr.TypeName {
+		case rbacconversion.TypeResource:
+			tr.Incoming = append(tr.Incoming,
+				zanzibar.IncomingRelation{UserType: rbacconversion.TypeNamespace, Relation: "restricted_in"},
+				zanzibar.IncomingRelation{UserType: rbacconversion.TypeGroup, UserSetRelation: rbacconversion.ContextualRelationUserInGroup, Relation: "exempt"},
+			)
+			// everyone operating in a restricted namespace, except the exempt users
+			tr.EvaluatedUsersets["forbidden"] = zanzibar.EvaluatedUserset{
+				Difference: &zanzibar.DifferenceUserset{
+					Base: zanzibar.EvaluatedUserset{TupleToUserset: &zanzibar.TupleToUserset{
+						ReferencedRelation: ContextualRelationOperatesInNamespace,
+						FromRelation:       "restricted_in",
+					}},
+					Subtract: zanzibar.EvaluatedUserset{Relation: "exempt"},
+				},
+			}
+			tr.DenyRelations = map[string]string{"create": "forbidden", "get": "forbidden"}
+		case rbacconversion.TypeResourceInstance:
+			tr.EvaluatedUsersets["forbidden"] = zanzibar.EvaluatedUserset{TupleToUserset: &zanzibar.TupleToUserset{
+				ReferencedRelation: "forbidden",
+				FromRelation:       ContextualRelationResourceMatch,
+			}}
+			tr.DenyRelations = map[string]string{"get": "forbidden"}
+		}
+	}
+	return as
+}
+
+func Test_authorizerImpl_Authorize_deny(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       user.DefaultInfo
+		attrsFuncs []attrsFunc
+		want       authorizer.Decision
+		wantReason string
+	}{
+		{
+			name: "system:masters cannot exec into pods in kube-system",
+			user: user.DefaultInfo{Name: "foo", Groups: []string{"system:masters"}},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("create", "", "pods", "exec", "kube-system"),
+			},
+			want:       authorizer.DecisionDeny,
+			wantReason: "ReBAC: denied through the forbidden relation of resource:core.pods/exec",
+		},
+		{
+			name: "the deny relation of the individual object is checked too",
+			user: user.DefaultInfo{Name: "foo", Groups: []string{"system:masters"}},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("get", "", "pods", "exec", "kube-system").withName("foo"),
+			},
+			want:       authorizer.DecisionDeny,
+			wantReason: "ReBAC: denied through the forbidden relation of resourceinstance:core.pods/foo",
+		},
+		{
+			name: "users without access are denied, such that no later authorizer allows",
+			user: user.DefaultInfo{Name: "baduser"},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("create", "", "pods", "exec", "kube-system"),
+			},
+			want:       authorizer.DecisionDeny,
+			wantReason: "ReBAC: denied through the forbidden relation of resource:core.pods/exec",
+		},
+		{
+			name: "system:masters can still do everything else",
+			user: user.DefaultInfo{Name: "foo", Groups: []string{"system:masters"}},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("create", "", "pods", "exec", "default"),
+				newNsResourceReq("create", "", "pods", "", "kube-system"),
+				newNsResourceReq("get", "", "pods", "log", "kube-system").withName("foo"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "sre members can exec into pods in kube-system",
+			user: user.DefaultInfo{Name: "foo", Groups: []string{"system:masters", "sre"}},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("create", "", "pods", "exec", "kube-system"),
+				newNsResourceReq("get", "", "pods", "exec", "kube-system").withName("foo"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "sre members are not allowed without access",
+			user: user.DefaultInfo{Name: "bar", Groups: []string{"sre"}},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("create", "", "pods", "exec", "kube-system"),
+			},
+			want: authorizer.DecisionNoOpinion,
+		},
+	}
+
+	ctx := context.Background()
+	as := denySchema()
+	if err := as.Validate(); err != nil {
+		t.Fatalf("AuthorizationSchema.Validate() error = %v", err)
+	}
+	tuples, err := rbacconversiontesting.ConvertTestdata(ctx)
+	if err != nil {
+		t.Fatalf("ConvertTestdata() error = %v", err)
+	}
+	execNode := rbacconversion.ResourceNode("", "pods/exec")
+	tuples = append(tuples,
+		rbacconversion.NamespaceNode("kube-system").WithRelation("restricted_in").ToOne(execNode),
+		rbacconversion.GroupNode("sre").WithUserSet(rbacconversion.ContextualRelationUserInGroup).WithRelation("exempt").ToOne(execNode),
+	)
+	backend := inmemory.NewTupleStoreAndChecker(as)
+	if _, err := backend.WriteTuples(ctx, tuples, nil); err != nil {
+		t.Fatalf("backend.WriteTuples() error = %v", err)
+	}
+
+	a := &ReBACAuthorizer{
+		Checker:             backend,
+		AuthorizationSchema: as,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, attrFunc := range tt.attrsFuncs {
+				attrs := attrFunc(&tt.user)
+
+				got, got1, err := a.Authorize(ctx, attrs)
+				if err != nil {
+					t.Errorf("authorizerImpl.Authorize(%s) error = %v", printAttrs(attrs), err)
+					return
+				}
+				if got != tt.want {
+					t.Errorf("authorizerImpl.Authorize(%s) got = %v, want %v", printAttrs(attrs), got, tt.want)
+				}
+				if got1 != tt.wantReason {
+					t.Errorf("authorizerImpl.Authorize(%s) got1 = %v, want %v", printAttrs(attrs), got1, tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func Test_authorizerImpl_Authorize_denyCheckFails(t *testing.T) {
+	attrs := newNsResourceReq("create", "", "pods", "exec", "kube-system")(&user.DefaultInfo{Name: "foo", Groups: []string{"system:masters"}})
+	wantReason := "ReBAC: denied as the forbidden relation of resource:core.pods/exec could not be checked"
+
+	for _, allowed := range []bool{true, false} {
+		t.Run(fmt.Sprintf("allowed=%t", allowed), func(t *testing.T) {
+			a := &ReBACAuthorizer{
+				AuthorizationSchema: denySchema(),
+				Checker: funcChecker(func(_ context.Context, tuple zanzibar.Tuple) (bool, error) {
+					if tuple.Relation == "forbidden" {
+						return false, fmt.Errorf("%w: connection refused", zanzibar.ErrBackendUnavailable)
+					}
+					return allowed, nil
+				}),
+			}
+
+			// the request is denied, even if it would be allowed, as it is unknown whether it is denied
+			got, reason, err := a.Authorize(context.Background(), attrs)
+			if err != nil {
+				t.Fatalf("authorizerImpl.Authorize() error = %v", err)
+			}
+			if got != authorizer.DecisionDeny {
+				t.Errorf("authorizerImpl.Authorize() got = %v, want %v", got, authorizer.DecisionDeny)
+			}
+			if reason != wantReason {
+				t.Errorf("authorizerImpl.Authorize() reason = %q, want %q", reason, wantReason)
+			}
+		})
+	}
+}
+
+func Test_authorizerImpl_Authorize_individual(t *testing.T) {
+	widgets := schema.GroupVersion{Group: "example.com", Version: "v1"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{widgets})
+	mapper.Add(widgets.WithKind("Widget"), meta.RESTScopeRoot)
+	mapper.Add(widgets.WithKind("Gadget"), meta.RESTScopeNamespace)
+
+	tests := []struct {
+		name       string
+		user       user.DefaultInfo
+		attrsFuncs []attrsFunc
+		want       authorizer.Decision
+	}{
+		{
+			name: "individual objects of resolved kinds are checked",
+			user: user.DefaultInfo{Name: "alice"},
+			attrsFuncs: []attrsFunc{
+				newResourceReq("get", "example.com", "widgets", "").withName("foo"),
+				newNsResourceReq("get", "example.com", "gadgets", "", "default").withName("bar"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "the namespace of requests for cluster-scoped objects is ignored",
+			user: user.DefaultInfo{Name: "alice"},
+			attrsFuncs: []attrsFunc{
+				newNsResourceReq("get", "example.com", "widgets", "", "foo").withName("foo"),
+			},
+			want: authorizer.DecisionAllow,
+		},
+		{
+			name: "other objects and unknown resources are not allowed",
+			user: user.DefaultInfo{Name: "alice"},
+			attrsFuncs: []attrsFunc{
+				newResourceReq("get", "example.com", "widgets", "").withName("bar"),
+				newNsResourceReq("get", "example.com", "gadgets", "", "kube-system").withName("bar"),
+				newResourceReq("get", "example.com", "gizmos", "").withName("foo"),
+			},
+			want: authorizer.DecisionNoOpinion,
+		},
+		{
+			name: "other users are not allowed",
+			user: user.DefaultInfo{Name: "bob"},
+			attrsFuncs: []attrsFunc{
+				newResourceReq("get", "example.com", "widgets", "").withName("foo"),
+			},
+			want: authorizer.DecisionNoOpinion,
+		},
+	}
+
+	ctx := context.Background()
+	as := rbacconversion.GetSchema()
+	for _, typeName := range []string{"example.com.widget", "example.com.gadget"} {
+		as.Types = append(as.Types, zanzibar.TypeRelation{
+			TypeName: typeName,
+			Incoming: []zanzibar.IncomingRelation{{UserType: rbacconversion.TypeUser, Relation: "get"}},
+		})
+	}
+	if err := as.Validate(); err != nil {
+		t.Fatalf("AuthorizationSchema.Validate() error = %v", err)
+	}
+	backend := inmemory.NewTupleStoreAndChecker(as)
+	alice := rbacconversion.UserNode("alice").WithRelation("get")
+	if _, err := backend.WriteTuples(ctx, []zanzibar.Tuple{
+		alice.ToOne(zanzibar.NewNode("example.com.widget", "foo")),
+		alice.ToOne(zanzibar.NewNode("example.com.gadget", "default/bar")),
+	}, nil); err != nil {
+		t.Fatalf("backend.WriteTuples() error = %v", err)
+	}
+
+	a := &ReBACAuthorizer{
+		Checker:             backend,
+		AuthorizationSchema: as,
+		RESTMapper:          mapper,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, attrFunc := range tt.attrsFuncs {
+				attrs := attrFunc(&tt.user)
+
+				got, _, err := a.Authorize(ctx, attrs)
+				if err != nil {
+					t.Errorf("authorizerImpl.Authorize(%s) error = %v", printAttrs(attrs), err)
+					return
+				}
+				if got != tt.want {
+					t.Errorf("authorizerImpl.Authorize(%s) got = %v, want %v", printAttrs(attrs), got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// countingMapper counts the lookups of the kinds of resources
+type countingMapper struct {
+	meta.RESTMapper
+	lookups int
+}
+
+func (m *countingMapper) KindFor(gvr schema.GroupVersionResource) (schema.GroupVersionKind, error) {
+	m.lookups++
+	return m.RESTMapper.KindFor(gvr)
+}
+
+func Test_authorizerImpl_Authorize_unknownResources(t *testing.T) {
+	mapper := &countingMapper{RESTMapper: defaultRESTMapper}
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+	a := &ReBACAuthorizer{
+		Checker:             inmemory.NewTupleStoreAndChecker(*nodeauth.GetSchema()),
+		AuthorizationSchema: *nodeauth.GetSchema(),
+		RESTMapper:          mapper,
+		NoMatchInterval:     time.Minute,
+		Clock:               fakeClock,
+	}
+	authorize := func(resource, name string) {
+		t.Helper()
+		attrs := newNsResourceReq("get", "", resource, "", "default").withName(name)(&user.DefaultInfo{Name: "alice"})
+		if _, _, err := a.Authorize(context.Background(), attrs); err != nil {
+			t.Fatalf("authorizerImpl.Authorize(%s) error = %v", printAttrs(attrs), err)
+		}
+	}
+
+	// resolved resources are cached
+	authorize("pods", "foo")
+	authorize("pods", "bar")
+	if mapper.lookups != 1 {
+		t.Errorf("RESTMapper lookups = %d, want 1", mapper.lookups)
+	}
+
+	// unknown resources, whether repeated or not, are looked up once per interval
+	authorize("widgets", "foo")
+	authorize("widgets", "foo")
+	authorize("gadgets", "foo")
+	if mapper.lookups != 2 {
+		t.Errorf("RESTMapper lookups = %d, want 2", mapper.lookups)
+	}
+	// the resources resolved before still are resolved
+	authorize("pods", "baz")
+	if mapper.lookups != 2 {
+		t.Errorf("RESTMapper lookups = %d, want 2", mapper.lookups)
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	authorize("widgets", "foo")
+	if mapper.lookups != 3 {
+		t.Errorf("RESTMapper lookups = %d, want 3", mapper.lookups)
+	}
+}
+
+// funcChecker answers check requests through a function, e.g. to make them slow or fail
+type funcChecker func(ctx context.Context, tuple zanzibar.Tuple) (bool, error)
+
+func (f funcChecker) CheckOne(ctx context.Context, tuple zanzibar.Tuple, _ []zanzibar.Tuple) (bool, error) {
+	return f(ctx, tuple)
+}
+
+func Test_authorizerImpl_Authorize_batch(t *testing.T) {
+	as := rbacconversion.GetSchema()
+	as.Types = append(as.Types, nodeauth.GetSchema().Types...)
+	attrs := newNsResourceReq("get", "", "pods", "", "default").withName("foo")(&user.DefaultInfo{Name: "alice"})
+
+	t.Run("the other checks are cancelled when the first one allows", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		a := &ReBACAuthorizer{
+			AuthorizationSchema: as,
+			Checker: funcChecker(func(ctx context.Context, tuple zanzibar.Tuple) (bool, error) {
+				if tuple.Object.NodeType() == rbacconversion.TypeResourceInstance {
+					return true, nil
+				}
+				// the individual core.pod check never completes by itself
+				<-ctx.Done()
+				close(cancelled)
+				return false, ctx.Err()
+			}),
+		}
+
+		got, _, err := a.Authorize(context.Background(), attrs)
+		if err != nil || got != authorizer.DecisionAllow {
+			t.Fatalf("authorizerImpl.Authorize() = %v, %v, want allow", got, err)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the individual check was not cancelled")
+		}
+	})
+
+	t.Run("all checks share the check timeout", func(t *testing.T) {
+		a := &ReBACAuthorizer{
+			AuthorizationSchema: as,
+			CheckTimeout:        50 * time.Millisecond,
+			Checker: funcChecker(func(ctx context.Context, _ zanzibar.Tuple) (bool, error) {
+				<-ctx.Done()
+				return false, ctx.Err()
+			}),
+		}
+
+		start := time.Now()
+		got, _, _ := a.Authorize(context.Background(), attrs)
+		if got != authorizer.DecisionNoOpinion {
+			t.Errorf("authorizerImpl.Authorize() got = %v, want %v", got, authorizer.DecisionNoOpinion)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("authorizerImpl.Authorize() took %v, want about the check timeout", elapsed)
+		}
+	})
+}
+
 func printAttrs(attrs authorizer.Attributes) string {
 	return string(util.Must(json.MarshalIndent(attrs, "", "  ")))
 }
+
+func Test_noOpinion(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantReason string
+		wantErr    bool
+	}{
+		{
+			name: "no error",
+		},
+		{
+			name: "unknown relation is not an error",
+			err:  fmt.Errorf("%w: relation 'approve' not found", zanzibar.ErrUnknownRelation),
+		},
+		{
+			name:       "unavailable backend is an error, such that the decision is not cached",
+			err:        fmt.Errorf("%w: connection refused", zanzibar.ErrBackendUnavailable),
+			wantReason: "ReBAC backend unavailable",
+			wantErr:    true,
+		},
+		{
+			name:       "other errors are reported in the reason",
+			err:        fmt.Errorf("%w user:foo", zanzibar.ErrInvalidTuple),
+			wantReason: "ReBAC error: invalid tuple user:foo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason, err := noOpinion(context.Background(), tt.err)
+			if got != authorizer.DecisionNoOpinion {
+				t.Errorf("noOpinion() got = %v, want NoOpinion", got)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("noOpinion() reason = %q, want %q", reason, tt.wantReason)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("noOpinion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -35,7 +35,7 @@ func NewWebhookForAuthorizer(authz authorizer.Authorizer) *Webhook {
 			}
 			if attrs := req.Spec.NonResourceAttributes; attrs != nil {
 				ar.Path = attrs.Path
-				ar.ResourceRequest = true
+				ar.ResourceRequest = false
 				ar.Verb = attrs.Verb
 			}
 
@@ -0,0 +1,233 @@
+package accessrequestsyncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	rebacv1alpha1 "github.com/luxas/kube-rebac-authorizer/api/v1alpha1"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/syncutil"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// AccessRequestReconciler approves AccessRequests, and grants the requested relation through
+// TupleStore.WriteTuples while the request is active.
+type AccessRequestReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Zanzibar zanzibar.TupleStore
+	// Checker checks whether the approvers of a request are allowed to approve it, see Target.
+	Checker      zanzibar.Checker
+	TypeRelation *zanzibar.TypeRelation
+	Targets      []Target
+
+	// RequiredApprovals is the number of valid approvals needed, at least one.
+	RequiredApprovals int
+	// MaxDuration limits the requested duration, if set.
+	MaxDuration time.Duration
+	// Clock is optional, and defaults to the real clock.
+	Clock clock.PassiveClock
+	// Recorder is optional. If set, the tuples of the object that cannot be written are reported as events on it.
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=rebac.luxaslabs.com,resources=accessrequests,verbs=get;list;watch
+//+kubebuilder:rbac:groups=rebac.luxaslabs.com,resources=accessrequests/status,verbs=get;update;patch
+
+// Reconcile updates the phase of the AccessRequest, and reconciles its tuples; an active request
+// relates the user to the object, otherwise the request owns no tuples.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.0/pkg/reconcile
+func (r *AccessRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	logger.V(3).Info("getting accessrequest", "name", req.Name)
+
+	now := r.now()
+	ar := rebacv1alpha1.AccessRequest{}
+	var tuples []zanzibar.Tuple
+	if err := r.Client.Get(ctx, req.NamespacedName, &ar); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	} else if err != nil || ar.DeletionTimestamp != nil {
+		// The accessrequest is deleted, or being deleted, so delete all tuples it owns, by reconciling
+		// with no desired tuples. Only the name is needed for computing the node ID.
+		logger.Info("deleting tuples of deleted accessrequest", "name", req.Name)
+		ar.Name = req.Name
+	} else {
+		status, err := r.computeStatus(ctx, &ar, now)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !equality.Semantic.DeepEqual(*status, ar.Status) {
+			logger.Info("updating accessrequest status", "name", req.Name, "phase", status.Phase, "message", status.Message)
+			ar.Status = *status
+			if err := r.Client.Status().Update(ctx, &ar); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		as, err := r.Zanzibar.GetAuthorizationSchema(ctx)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		tuples, err = zanzibar.GenerateTuplesFor(as, *r.TypeRelation, ar)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	logger.V(3).Info("got tuples", "tuples", tuples)
+
+	nodeid, err := r.TypeRelation.GetID(ar)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	accessrequestnode := zanzibar.NewNode(r.TypeRelation.TypeName, nodeid)
+
+	adds, deletes, err := zanzibar.ReconcileCompute(ctx, r.Zanzibar, accessrequestnode, tuples)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	logger.V(3).Info("got reconcile result", "adds", adds, "deletes", deletes)
+
+	if result, err := syncutil.WriteAndReport(ctx, r.Zanzibar, r.Recorder, &ar, adds, deletes); err != nil {
+		return result, err
+	}
+
+	// reconcile again when the access expires, such that its tuples are removed
+	if Active(&ar, now) {
+		return ctrl.Result{RequeueAfter: ar.Status.ExpiresAt.Sub(now)}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// computeStatus returns the status of the AccessRequest at now. Expired and invalid requests stay so.
+// Otherwise, the status is computed from the spec and the approvals that are valid now, and only the
+// time of approval is kept from the status: an active request whose approvals are no longer valid is
+// pending again, and the expiry is always recomputed from the approval time and the allowed duration.
+func (r *AccessRequestReconciler) computeStatus(ctx context.Context, ar *rebacv1alpha1.AccessRequest, now time.Time) (*rebacv1alpha1.AccessRequestStatus, error) {
+	status := ar.Status.DeepCopy()
+	switch status.Phase {
+	case rebacv1alpha1.AccessRequestExpired, rebacv1alpha1.AccessRequestInvalid:
+		return status, nil
+	}
+
+	invalid := func(format string, args ...any) (*rebacv1alpha1.AccessRequestStatus, error) {
+		return &rebacv1alpha1.AccessRequestStatus{
+			Phase:   rebacv1alpha1.AccessRequestInvalid,
+			Message: fmt.Sprintf(format, args...),
+		}, nil
+	}
+	if len(ar.Spec.User) == 0 || len(ar.Spec.ObjectID) == 0 {
+		return invalid("user and objectID must be set")
+	}
+	if ar.Spec.Duration.Duration <= 0 {
+		return invalid("duration must be positive")
+	}
+	target, err := targetFor(r.Targets, ar.Spec)
+	if err != nil {
+		return invalid("%v", err)
+	}
+
+	approvedBy, err := r.validApprovals(ctx, target, ar)
+	if err != nil {
+		return nil, err
+	}
+	required := max(r.RequiredApprovals, 1)
+	if len(approvedBy) < required {
+		return &rebacv1alpha1.AccessRequestStatus{
+			Phase:   rebacv1alpha1.AccessRequestPending,
+			Message: fmt.Sprintf("%d of %d required approvals", len(approvedBy), required),
+		}, nil
+	}
+
+	// keep the time of approval of an active request, unless it is not a time the request could
+	// have been approved at. The status is stored with a precision of seconds.
+	approvedAt := now.Truncate(time.Second)
+	if status.Phase == rebacv1alpha1.AccessRequestActive && status.ApprovedAt != nil &&
+		!status.ApprovedAt.Time.After(now) && !status.ApprovedAt.Time.Before(ar.CreationTimestamp.Time) {
+		approvedAt = status.ApprovedAt.Time
+	}
+	duration := ar.Spec.Duration.Duration
+	if r.MaxDuration != 0 {
+		duration = min(duration, r.MaxDuration)
+	}
+	expiresAt := approvedAt.Add(duration)
+	if !now.Before(expiresAt) {
+		return &rebacv1alpha1.AccessRequestStatus{
+			Phase:      rebacv1alpha1.AccessRequestExpired,
+			Message:    "the access has expired",
+			ApprovedBy: approvedBy,
+			ApprovedAt: &metav1.Time{Time: approvedAt},
+			ExpiresAt:  &metav1.Time{Time: expiresAt},
+		}, nil
+	}
+	return &rebacv1alpha1.AccessRequestStatus{
+		Phase:      rebacv1alpha1.AccessRequestActive,
+		Message:    fmt.Sprintf("approved for %s", duration),
+		ApprovedBy: approvedBy,
+		ApprovedAt: &metav1.Time{Time: approvedAt},
+		ExpiresAt:  &metav1.Time{Time: expiresAt},
+	}, nil
+}
+
+// validApprovals returns the approvers that are allowed to approve the request; the requesting user
+// cannot approve their own request. Approvers can be allowed through their groups recorded with the
+// approval, which are given as contextual tuples, just like the authorizer does.
+func (r *AccessRequestReconciler) validApprovals(ctx context.Context, target *Target, ar *rebacv1alpha1.AccessRequest) ([]string, error) {
+	approverObject, err := target.approverObject(zanzibar.NewNode(ar.Spec.ObjectType, ar.Spec.ObjectID))
+	if err != nil {
+		return nil, err
+	}
+
+	approvedBy := sets.New[string]()
+	for _, approval := range ar.Spec.Approvals {
+		if len(approval.User) == 0 || approval.User == ar.Spec.User || approvedBy.Has(approval.User) {
+			continue
+		}
+		user := rbacconversion.UserNode(approval.User)
+		contextualTuples := make([]zanzibar.Tuple, 0, len(approval.Groups))
+		for _, g := range approval.Groups {
+			contextualTuples = append(contextualTuples, user.WithRelation(rbacconversion.ContextualRelationUserInGroup).ToOne(rbacconversion.GroupNode(g)))
+		}
+		allowed, err := r.Checker.CheckOne(ctx, zanzibar.Tuple{
+			User:     user,
+			Relation: target.ApproverRelation,
+			Object:   approverObject,
+		}, contextualTuples)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't check approver %q: %w", approval.User, err)
+		}
+		if allowed {
+			approvedBy.Insert(approval.User)
+		}
+	}
+	return sets.List(approvedBy), nil
+}
+
+func (r *AccessRequestReconciler) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AccessRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&rebacv1alpha1.AccessRequest{}).
+		Complete(r)
+}
@@ -0,0 +1,218 @@
+package accessrequestsyncer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rebacv1alpha1 "github.com/luxas/kube-rebac-authorizer/api/v1alpha1"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/accessrequestsyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type Tuple = zanzibar.Tuple
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rebacv1alpha1.AddToScheme(scheme))
+
+	// users bound to the access-approvers clusterrole can approve requests to be bound to any clusterrole
+	targets := []accessrequestsyncer.Target{{
+		ObjectType:       rbacconversion.TypeClusterRole,
+		Relations:        []string{rbacconversion.RelationClusterRoleAssignee},
+		ApproverRelation: rbacconversion.RelationClusterRoleAssignee,
+		ApproverObject:   "clusterrole:access-approvers",
+	}}
+	as := rbacconversion.GetSchema()
+	as.Types = append(as.Types, accessrequestsyncer.GetTypeRelation(targets))
+	assert.NoError(t, as.Validate())
+
+	store := inmemory.NewTupleStoreAndChecker(as)
+	_, err := store.WriteTuples(ctx, []Tuple{
+		zanzibar.MustParseTuple("user:bob assignee clusterrolebinding:approvers"),
+		zanzibar.MustParseTuple("clusterrolebinding:approvers#assignee assignee clusterrole:access-approvers"),
+		zanzibar.MustParseTuple("group:oncall-leads#members assignee clusterrolebinding:approvers"),
+	}, nil)
+	assert.NoError(t, err)
+
+	ar := &rebacv1alpha1.AccessRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice-admin"},
+		Spec: rebacv1alpha1.AccessRequestSpec{
+			User:       "alice",
+			Relation:   rbacconversion.RelationClusterRoleAssignee,
+			ObjectType: rbacconversion.TypeClusterRole,
+			ObjectID:   "cluster-admin",
+			Duration:   metav1.Duration{Duration: 2 * time.Hour},
+			// self-approvals, and approvals by users not allowed to approve, are ignored
+			Approvals: []rebacv1alpha1.Approval{{User: "alice"}, {User: "carol", Groups: []string{"developers"}}},
+		},
+	}
+	invalid := &rebacv1alpha1.AccessRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice-pods"},
+		Spec: rebacv1alpha1.AccessRequestSpec{
+			User:       "alice",
+			Relation:   "get",
+			ObjectType: "resource",
+			ObjectID:   "core.pods",
+			Duration:   metav1.Duration{Duration: time.Hour},
+			Approvals:  []rebacv1alpha1.Approval{{User: "bob"}},
+		},
+	}
+	clock := clocktesting.NewFakePassiveClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(ar, invalid).
+		WithStatusSubresource(&rebacv1alpha1.AccessRequest{}).
+		Build()
+
+	r := &accessrequestsyncer.AccessRequestReconciler{
+		Client:       c,
+		Scheme:       scheme,
+		Zanzibar:     store,
+		Checker:      store,
+		TypeRelation: &as.Types[len(as.Types)-1],
+		Targets:      targets,
+		MaxDuration:  time.Hour,
+		Clock:        clock,
+	}
+
+	reconcile := func(name string) (ctrl.Result, *rebacv1alpha1.AccessRequest) {
+		t.Helper()
+		key := types.NamespacedName{Name: name}
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		assert.NoError(t, err)
+		got := &rebacv1alpha1.AccessRequest{}
+		if err := c.Get(ctx, key, got); client.IgnoreNotFound(err) != nil {
+			t.Fatal(err)
+		}
+		return result, got
+	}
+	aliceIsAdmin := func() bool {
+		t.Helper()
+		allowed, err := store.CheckOne(ctx, zanzibar.MustParseTuple("user:alice assignee clusterrole:cluster-admin"), nil)
+		assert.NoError(t, err)
+		return allowed
+	}
+	grantTuples := []Tuple{
+		zanzibar.MustParseTuple("user:alice grantee accessrequest:alice-admin"),
+		zanzibar.MustParseTuple("accessrequest:alice-admin#grantee assignee clusterrole:cluster-admin"),
+	}
+	readTuples := func() []Tuple {
+		t.Helper()
+		tuples, err := store.ReadTuples(ctx, zanzibar.TupleFilter{ObjectType: rbacconversion.TypeClusterRole, ObjectName: "cluster-admin"})
+		assert.NoError(t, err)
+		grantees, err := store.ReadTuples(ctx, zanzibar.TupleFilter{ObjectType: accessrequestsyncer.TypeAccessRequest})
+		assert.NoError(t, err)
+		return append(grantees, tuples...)
+	}
+
+	// the relation cannot be requested
+	_, got := reconcile(invalid.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestInvalid, got.Status.Phase)
+
+	// no valid approvals yet
+	result, got := reconcile(ar.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestPending, got.Status.Phase)
+	assert.Equal(t, "0 of 1 required approvals", got.Status.Message)
+	assert.Zero(t, result.RequeueAfter)
+	assert.False(t, aliceIsAdmin())
+
+	// bob approves, and the requested duration is limited to the maximum
+	got.Spec.Approvals = append(got.Spec.Approvals, rebacv1alpha1.Approval{User: "bob"})
+	assert.NoError(t, c.Update(ctx, got))
+	result, got = reconcile(ar.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestActive, got.Status.Phase)
+	assert.Equal(t, []string{"bob"}, got.Status.ApprovedBy)
+	assert.Equal(t, time.Hour, got.Status.ExpiresAt.Sub(got.Status.ApprovedAt.Time))
+	assert.Equal(t, time.Hour, result.RequeueAfter)
+	assert.ElementsMatch(t, grantTuples, readTuples())
+	assert.True(t, aliceIsAdmin())
+
+	// reconciling an active request again changes nothing
+	_, got = reconcile(ar.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestActive, got.Status.Phase)
+	assert.ElementsMatch(t, grantTuples, readTuples())
+
+	// the status is recomputed from the spec, not trusted
+	approvedStatus := got.Status
+	got.Status.ExpiresAt = &metav1.Time{Time: clock.Now().Add(365 * 24 * time.Hour)}
+	got.Status.ApprovedBy = []string{"mallory"}
+	assert.NoError(t, c.Status().Update(ctx, got))
+	result, got = reconcile(ar.Name)
+	assert.Equal(t, approvedStatus, got.Status)
+	assert.Equal(t, time.Hour, result.RequeueAfter)
+
+	// the access expires
+	clock.SetTime(clock.Now().Add(result.RequeueAfter))
+	result, got = reconcile(ar.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestExpired, got.Status.Phase)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Empty(t, readTuples())
+	assert.False(t, aliceIsAdmin())
+
+	// an expired request cannot be approved again
+	got.Spec.Approvals = append(got.Spec.Approvals, rebacv1alpha1.Approval{User: "bob"})
+	assert.NoError(t, c.Update(ctx, got))
+	_, got = reconcile(ar.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestExpired, got.Status.Phase)
+	assert.Empty(t, readTuples())
+
+	// deleting an active request removes its tuples
+	active := &rebacv1alpha1.AccessRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice-admin-again"},
+		Spec:       ar.Spec,
+	}
+	active.Spec.Approvals = []rebacv1alpha1.Approval{{User: "bob"}}
+	assert.NoError(t, c.Create(ctx, active))
+	_, got = reconcile(active.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestActive, got.Status.Phase)
+	assert.True(t, aliceIsAdmin())
+	assert.NoError(t, c.Delete(ctx, got))
+	_, _ = reconcile(active.Name)
+	assert.Empty(t, readTuples())
+	assert.False(t, aliceIsAdmin())
+
+	// the access is revoked when the approvals are no longer valid
+	revoked := &rebacv1alpha1.AccessRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice-admin-revoked"},
+		Spec:       active.Spec,
+	}
+	assert.NoError(t, c.Create(ctx, revoked))
+	_, got = reconcile(revoked.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestActive, got.Status.Phase)
+	assert.True(t, aliceIsAdmin())
+	_, err = store.WriteTuples(ctx, nil, []Tuple{zanzibar.MustParseTuple("user:bob assignee clusterrolebinding:approvers")})
+	assert.NoError(t, err)
+	_, got = reconcile(revoked.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestPending, got.Status.Phase)
+	assert.Nil(t, got.Status.ExpiresAt)
+	assert.Empty(t, readTuples())
+	assert.False(t, aliceIsAdmin())
+
+	// approvers can be allowed through the groups recorded with their approval
+	byGroup := &rebacv1alpha1.AccessRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice-admin-by-group"},
+		Spec:       ar.Spec,
+	}
+	byGroup.Spec.Approvals = []rebacv1alpha1.Approval{{User: "dave"}}
+	assert.NoError(t, c.Create(ctx, byGroup))
+	_, got = reconcile(byGroup.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestPending, got.Status.Phase)
+	got.Spec.Approvals = append(got.Spec.Approvals, rebacv1alpha1.Approval{User: "dave", Groups: []string{"oncall-leads"}})
+	assert.NoError(t, c.Update(ctx, got))
+	_, got = reconcile(byGroup.Name)
+	assert.Equal(t, rebacv1alpha1.AccessRequestActive, got.Status.Phase)
+	assert.Equal(t, []string{"dave"}, got.Status.ApprovedBy)
+	assert.True(t, aliceIsAdmin())
+}
@@ -0,0 +1,109 @@
+package accessrequestsyncer
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	rebacv1alpha1 "github.com/luxas/kube-rebac-authorizer/api/v1alpha1"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	TypeAccessRequest = "accessrequest"
+	// RelationGrantee relates the requesting user to the access request, and the access request to
+	// the requested object through the userset accessrequest:<name>#grantee
+	RelationGrantee = "grantee"
+)
+
+// Target is an object type, and the relations to it that users can request.
+type Target struct {
+	// ObjectType is the type of the objects access can be requested to, e.g. "clusterrole"
+	ObjectType string `json:"objectType"`
+	// Relations are the relations that can be requested, e.g. ["assignee"]
+	Relations []string `json:"relations"`
+
+	// ApproverRelation is the relation approvers must have to the approver object, e.g. "assignee"
+	ApproverRelation string `json:"approverRelation"`
+	// ApproverObject is the node approvers must be related to, e.g. "clusterrole:access-approvers".
+	// If unset, approvers must be related to the requested object itself.
+	ApproverObject string `json:"approverObject,omitempty"`
+}
+
+// approverObject returns the node approvers of a request to the object must be related to
+func (t Target) approverObject(object zanzibar.Node) (zanzibar.Node, error) {
+	if len(t.ApproverObject) == 0 {
+		return object, nil
+	}
+	return zanzibar.ParseNode(t.ApproverObject)
+}
+
+// GetTypeRelation returns the type of access requests. Each active access request relates its user to
+// the requested object through the userset accessrequest:<name>#grantee, such that all tuples are owned
+// by the access request, and removed when it expires or is deleted.
+func GetTypeRelation(targets []Target) zanzibar.TypeRelation {
+	return zanzibar.TypeRelation{
+		TypeName: TypeAccessRequest,
+		IDExpr: zanzibar.CastIDExpr(func(ar rebacv1alpha1.AccessRequest) (string, error) {
+			return ar.Name, nil
+		}),
+		IDCodec: zanzibar.NewNodeIDCodec(TypeAccessRequest),
+		// the controller computes the phase at the time of the reconcile, such that the tuples only
+		// depend on the object
+		Condition: func(obj any) bool {
+			ar, ok := zanzibar.Cast[rebacv1alpha1.AccessRequest](obj)
+			return ok && ar.Status.Phase == rebacv1alpha1.AccessRequestActive
+		},
+		Incoming: []zanzibar.IncomingRelation{
+			{
+				UserType: rbacconversion.TypeUser,
+				Relation: RelationGrantee,
+				UserIDExpr: zanzibar.CastIncoming(func(ar rebacv1alpha1.AccessRequest) ([]string, error) {
+					return util.FilterEmpty([]string{ar.Spec.User}), nil
+				}),
+				EscapeID: true,
+			},
+		},
+		Outgoing: util.Map(targets, func(t Target) zanzibar.OutgoingRelation {
+			return zanzibar.OutgoingRelation{
+				ObjectType:      t.ObjectType,
+				UserSetRelation: RelationGrantee,
+				Relations:       t.Relations,
+				ObjectIDExpr: zanzibar.CastOutgoing(func(ar rebacv1alpha1.AccessRequest, relation string) ([]string, error) {
+					if ar.Spec.ObjectType != t.ObjectType || ar.Spec.Relation != relation {
+						return nil, nil
+					}
+					// the object ID is already the ID of the node in the graph
+					return util.FilterEmpty([]string{ar.Spec.ObjectID}), nil
+				}),
+			}
+		}),
+	}
+}
+
+// Active returns true if the access request is approved, and has not yet expired at now.
+func Active(ar *rebacv1alpha1.AccessRequest, now time.Time) bool {
+	return ar.Status.Phase == rebacv1alpha1.AccessRequestActive &&
+		ar.Status.ExpiresAt != nil && now.Before(ar.Status.ExpiresAt.Time)
+}
+
+// ActiveObject is Active for objects as listed by e.g. the garbage collector. Objects that are not access
+// requests are not active.
+func ActiveObject(obj runtime.Object, now time.Time) bool {
+	ar, ok := zanzibar.Cast[rebacv1alpha1.AccessRequest](obj)
+	return ok && Active(&ar, now)
+}
+
+// targetFor returns the target the access request asks for, or an error if the relation cannot be requested
+func targetFor(targets []Target, spec rebacv1alpha1.AccessRequestSpec) (*Target, error) {
+	target, err := util.MatchOne(targets, func(t Target) bool {
+		return t.ObjectType == spec.ObjectType && slices.Contains(t.Relations, spec.Relation)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("relation %q to type %q cannot be requested", spec.Relation, spec.ObjectType)
+	}
+	return target, nil
+}
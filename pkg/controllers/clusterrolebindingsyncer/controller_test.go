@@ -0,0 +1,171 @@
+package clusterrolebindingsyncer_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/clusterrolebindingsyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/controllertesting"
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile_delete(t *testing.T) {
+	controllertesting.DeleteTest{
+		Schema:   rbacconversion.GetSchema(),
+		TypeName: rbacconversion.TypeClusterRoleBinding,
+		Object: &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "view"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+			Subjects:   []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "alice"}},
+		},
+		WantTuples: []zanzibar.Tuple{
+			zanzibar.MustParseTuple("user:alice assignee clusterrolebinding:view"),
+			zanzibar.MustParseTuple("clusterrolebinding:view#assignee assignee clusterrole:view"),
+		},
+		NewReconciler: func(c client.Client, store zanzibar.TupleStore, tr *zanzibar.TypeRelation) reconcile.Reconciler {
+			return &clusterrolebindingsyncer.ClusterRoleBindingReconciler{
+				Client:        c,
+				Scheme:        c.Scheme(),
+				RBACConverter: &rbacconversion.GenericConverter{},
+				Zanzibar:      store,
+				TypeRelation:  tr,
+			}
+		},
+	}.Run(t)
+}
+
+func TestReconcile_expiry(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rbacv1.AddToScheme(scheme))
+
+	clock := clocktesting.NewFakePassiveClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	obj := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "incident",
+			Annotations: map[string]string{rbacconversion.AnnotationExpiresAt: "2024-01-01T13:00:00Z"},
+		},
+		RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		Subjects: []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "alice"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(obj).Build()
+
+	as := rbacconversion.GetSchema()
+	store := inmemory.NewTupleStoreAndChecker(as)
+	_, err := store.WriteTuples(ctx, []zanzibar.Tuple{zanzibar.MustParseTuple("clusterrole:view#assignee get resource:core.pods")}, nil)
+	assert.NoError(t, err)
+	tr, err := util.MatchOne(as.Types, func(tr zanzibar.TypeRelation) bool {
+		return tr.TypeName == rbacconversion.TypeClusterRoleBinding
+	})
+	assert.NoError(t, err)
+	r := &clusterrolebindingsyncer.ClusterRoleBindingReconciler{
+		Client:        c,
+		Scheme:        scheme,
+		RBACConverter: &rbacconversion.GenericConverter{},
+		Zanzibar:      store,
+		TypeRelation:  tr,
+		Clock:         clock,
+	}
+
+	reconcile := func() (time.Duration, bool) {
+		t.Helper()
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+		assert.NoError(t, err)
+		allowed, err := store.CheckOne(ctx, zanzibar.MustParseTuple("user:alice get resource:core.pods"), nil)
+		assert.NoError(t, err)
+		return result.RequeueAfter, allowed
+	}
+
+	// before the expiry, access is granted, and the binding is reconciled again at the expiry
+	requeueAfter, allowed := reconcile()
+	assert.True(t, allowed)
+	assert.Equal(t, time.Hour, requeueAfter)
+
+	// at the expiry, access stops
+	clock.SetTime(clock.Now().Add(requeueAfter))
+	requeueAfter, allowed = reconcile()
+	assert.False(t, allowed)
+	assert.Zero(t, requeueAfter)
+}
+
+// rejectingStore rejects writes of tuples of the rejected user, like OpenFGA rejects tuples it cannot store
+type rejectingStore struct {
+	zanzibar.TupleStore
+	rejectedUser string
+}
+
+func (s *rejectingStore) WriteTuples(ctx context.Context, writes, deletes []zanzibar.Tuple) (zanzibar.ConsistencyToken, error) {
+	for _, t := range writes {
+		if t.User.NodeName() == s.rejectedUser {
+			return "", &zanzibar.TupleError{Tuple: t, Err: fmt.Errorf("%w: rejected", zanzibar.ErrInvalidTuple)}
+		}
+	}
+	return s.TupleStore.WriteTuples(ctx, writes, deletes)
+}
+
+func TestReconcile_invalidTuple(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rbacv1.AddToScheme(scheme))
+
+	obj := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "view"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		Subjects: []rbacv1.Subject{
+			{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "alice"},
+			{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "mallory"},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(obj).Build()
+
+	as := rbacconversion.GetSchema()
+	backend := inmemory.NewTupleStoreAndChecker(as)
+	// bob was removed from the binding
+	_, err := backend.WriteTuples(ctx, []zanzibar.Tuple{zanzibar.MustParseTuple("user:bob assignee clusterrolebinding:view")}, nil)
+	assert.NoError(t, err)
+	tr, err := util.MatchOne(as.Types, func(tr zanzibar.TypeRelation) bool {
+		return tr.TypeName == rbacconversion.TypeClusterRoleBinding
+	})
+	assert.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	r := &clusterrolebindingsyncer.ClusterRoleBindingReconciler{
+		Client:        c,
+		Scheme:        scheme,
+		RBACConverter: &rbacconversion.GenericConverter{},
+		Zanzibar:      &rejectingStore{TupleStore: backend, rejectedUser: "mallory"},
+		TypeRelation:  tr,
+		Recorder:      recorder,
+	}
+
+	// the invalid tuple neither blocks revoking the access of bob, nor granting access to alice
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+	assert.NoError(t, err)
+	tuples, err := backend.ReadTuples(ctx, zanzibar.TupleFilter{})
+	assert.NoError(t, err)
+	zanzibar.Tuples(tuples).AssertEqualsWanted(zanzibar.Tuples{
+		zanzibar.MustParseTuple("user:alice assignee clusterrolebinding:view"),
+		zanzibar.MustParseTuple("clusterrolebinding:view#assignee assignee clusterrole:view"),
+	}, t, "ReadTuples")
+
+	// and it is reported on the binding
+	if assert.Len(t, recorder.Events, 1) {
+		event := <-recorder.Events
+		assert.True(t, strings.HasPrefix(event, "Warning InvalidTuple tuple user:mallory assignee clusterrolebinding:view"), event)
+	}
+}
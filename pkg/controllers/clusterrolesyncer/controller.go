@@ -5,13 +5,20 @@ import (
 	"strings"
 	"time"
 
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/syncutil"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // ClusterRoleReconciler reconciles a RBAC ClusterRole object
@@ -21,6 +28,10 @@ type ClusterRoleReconciler struct {
 	RBACConverter rbacconversion.RBACTupleConverter
 	Zanzibar      zanzibar.TupleStore
 	TypeRelation  *zanzibar.TypeRelation
+	// HighWaterMark is optional. If set, the authorizer waits for the observed changes to be reconciled.
+	HighWaterMark *zanzibar.HighWaterMark
+	// Recorder is optional. If set, the tuples of the object that cannot be written are reported as events on it.
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=rebac.luxaslabs.com,resources=typerelations,verbs=get;list;watch;create;update;patch;delete
@@ -38,6 +49,7 @@ type ClusterRoleReconciler struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.0/pkg/reconcile
 func (r *ClusterRoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
+	defer r.HighWaterMark.Reconciled(r.TypeRelation.TypeName + "/" + req.NamespacedName.String())
 
 	logger.V(3).Info("getting clusterrole", "name", req.Name)
 
@@ -46,18 +58,43 @@ func (r *ClusterRoleReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{Requeue: false, RequeueAfter: 100 * time.Minute}, nil
 	}
 
-	// TODO: Consider DeletionTimestamp!=nil a deletion
-	// TODO: Catch deletions too
 	cr := rbacv1.ClusterRole{}
-	if err := r.Client.Get(ctx, req.NamespacedName, &cr); err != nil {
-		return ctrl.Result{}, err
-	}
-
-	logger.V(3).Info("got clusterrole", "clusterrole", cr)
-
-	tuples, err := r.RBACConverter.ConvertClusterRoleToTuples(ctx, cr)
-	if err != nil {
+	var tuples []zanzibar.Tuple
+	if err := r.Client.Get(ctx, req.NamespacedName, &cr); client.IgnoreNotFound(err) != nil {
 		return ctrl.Result{}, err
+	} else if err != nil || cr.DeletionTimestamp != nil {
+		// The clusterrole is deleted, or being deleted, so delete all tuples it owns, by reconciling
+		// with no desired tuples. Only the name and namespace are needed for computing the node ID.
+		logger.Info("deleting tuples of deleted clusterrole", "name", req.Name)
+		cr.Name, cr.Namespace = req.Name, req.Namespace
+	} else {
+		logger.V(3).Info("got clusterrole", "clusterrole", cr)
+
+		// the other selectors, rules and URLs are still converted, as the unsupported ones only grant less
+		if err := rbacconversion.ValidateAggregationRule(cr); err != nil {
+			logger.Error(err, "clusterrole aggregates less than in RBAC", "name", cr.Name)
+			if r.Recorder != nil {
+				r.Recorder.Event(&cr, corev1.EventTypeWarning, "UnsupportedAggregationRule", err.Error())
+			}
+		}
+		if err := rbacconversion.ValidateNonResourceURLs(cr); err != nil {
+			logger.Error(err, "clusterrole grants access to less non-resource URLs than in RBAC", "name", cr.Name)
+			if r.Recorder != nil {
+				r.Recorder.Event(&cr, corev1.EventTypeWarning, "UnsupportedNonResourceURL", err.Error())
+			}
+		}
+
+		tuples, err = r.RBACConverter.ConvertClusterRoleToTuples(ctx, cr)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// only the sets of labels of the clusterrole that some aggregation rule selects are written
+		clusterRoles := rbacv1.ClusterRoleList{}
+		if err := r.Client.List(ctx, &clusterRoles); err != nil {
+			return ctrl.Result{}, err
+		}
+		tuples = rbacconversion.FilterSelectedLabelSets(tuples, clusterRoles.Items)
 	}
 
 	logger.V(3).Info("got tuples", "tuples", tuples)
@@ -76,12 +113,41 @@ func (r *ClusterRoleReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	logger.V(3).Info("got reconcile result", "adds", adds, "deletes", deletes)
 
-	return ctrl.Result{}, r.Zanzibar.WriteTuples(ctx, adds, deletes)
+	return syncutil.WriteAndReport(ctx, r.Zanzibar, r.Recorder, &cr, adds, deletes)
+}
+
+// selectedClusterRoles returns the requests for the clusterroles the aggregation rule of the clusterrole selects, such
+// that the tuples of their sets of labels are written, or deleted, when the aggregation rule changes. Both the old and
+// the new clusterrole of updates are mapped.
+func (r *ClusterRoleReconciler) selectedClusterRoles(ctx context.Context, obj client.Object) []reconcile.Request {
+	aggregating, ok := obj.(*rbacv1.ClusterRole)
+	if !ok || aggregating.AggregationRule == nil {
+		return nil
+	}
+	clusterRoles := rbacv1.ClusterRoleList{}
+	if err := r.Client.List(ctx, &clusterRoles); err != nil {
+		log.FromContext(ctx).Error(err, "couldn't list the clusterroles selected by the aggregation rule", "name", aggregating.Name)
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range clusterRoles.Items {
+		if !rbacconversion.AggregationRuleSelects(aggregating.AggregationRule, clusterRoles.Items[i].Labels) {
+			continue
+		}
+		key := client.ObjectKeyFromObject(&clusterRoles.Items[i])
+		r.HighWaterMark.Observe(r.TypeRelation.TypeName + "/" + key.String())
+		requests = append(requests, reconcile.Request{NamespacedName: key})
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterRoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&rbacv1.ClusterRole{}).
+		For(&rbacv1.ClusterRole{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			r.HighWaterMark.Observe(r.TypeRelation.TypeName + "/" + client.ObjectKeyFromObject(obj).String())
+			return true
+		}))).
+		Watches(&rbacv1.ClusterRole{}, handler.EnqueueRequestsFromMapFunc(r.selectedClusterRoles)).
 		Complete(r)
 }
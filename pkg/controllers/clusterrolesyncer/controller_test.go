@@ -0,0 +1,151 @@
+package clusterrolesyncer_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/clusterrolesyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/controllertesting"
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile_delete(t *testing.T) {
+	controllertesting.DeleteTest{
+		Schema:   rbacconversion.GetSchema(),
+		TypeName: rbacconversion.TypeClusterRole,
+		Object: &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "view"},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+		},
+		WantTuples: []zanzibar.Tuple{
+			zanzibar.MustParseTuple("clusterrole:view#assignee get resource:core.pods"),
+		},
+		NewReconciler: func(c client.Client, store zanzibar.TupleStore, tr *zanzibar.TypeRelation) reconcile.Reconciler {
+			return &clusterrolesyncer.ClusterRoleReconciler{
+				Client:        c,
+				Scheme:        c.Scheme(),
+				RBACConverter: &rbacconversion.GenericConverter{},
+				Zanzibar:      store,
+				TypeRelation:  tr,
+			}
+		},
+	}.Run(t)
+}
+
+func TestReconcile_aggregation(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rbacv1.AddToScheme(scheme))
+
+	labeled := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{
+		Name:   "labeled",
+		Labels: map[string]string{"a": "1", "b": "1", "c": "1", "d": "1", "e": "1", "f": "1", "g": "1"},
+	}}
+	aggregating := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "aggregating"},
+		AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{
+			{MatchLabels: map[string]string{"a": "1"}},
+			{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "b", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"2"}}}},
+		}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(labeled, aggregating).Build()
+
+	as := rbacconversion.GetSchema()
+	store := inmemory.NewTupleStoreAndChecker(as)
+	tr, err := util.MatchOne(as.Types, func(tr zanzibar.TypeRelation) bool {
+		return tr.TypeName == rbacconversion.TypeClusterRole
+	})
+	assert.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	r := &clusterrolesyncer.ClusterRoleReconciler{
+		Client:        c,
+		Scheme:        scheme,
+		RBACConverter: &rbacconversion.GenericConverter{},
+		Zanzibar:      store,
+		TypeRelation:  tr,
+		Recorder:      recorder,
+	}
+
+	reconcile := func(obj client.Object) []zanzibar.Tuple {
+		t.Helper()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+		assert.NoError(t, err)
+		tuples, err := store.ReadTuples(ctx, zanzibar.TupleFilter{})
+		assert.NoError(t, err)
+		return tuples
+	}
+
+	// the unsupported selector is reported on the clusterrole
+	reconcile(aggregating)
+	if assert.Len(t, recorder.Events, 1) {
+		event := <-recorder.Events
+		assert.True(t, strings.HasPrefix(event, "Warning UnsupportedAggregationRule"), event)
+	}
+
+	// of the 378 sets of labels of the clusterrole, only the one selected is written
+	zanzibar.Tuples(reconcile(labeled)).AssertEqualsWanted(zanzibar.Tuples{
+		zanzibar.MustParseTuple("clusterrole:aggregating#assignee selects clusterrole_label:a%3D1"),
+		zanzibar.MustParseTuple("clusterrole_label:a%3D1#selects assignee clusterrole:labeled"),
+	}, t, "ReadTuples")
+
+	// when the aggregation rule is deleted, nothing selects the labels anymore
+	assert.NoError(t, c.Delete(ctx, aggregating))
+	reconcile(aggregating)
+	assert.Empty(t, reconcile(labeled))
+}
+
+func TestReconcile_nonResourceURLs(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rbacv1.AddToScheme(scheme))
+
+	cr := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "monitoring"},
+		Rules:      []rbacv1.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics", "/healthz/*", "/metric*"}}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr).Build()
+
+	as := rbacconversion.GetSchema()
+	store := inmemory.NewTupleStoreAndChecker(as)
+	tr, err := util.MatchOne(as.Types, func(tr zanzibar.TypeRelation) bool {
+		return tr.TypeName == rbacconversion.TypeClusterRole
+	})
+	assert.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	r := &clusterrolesyncer.ClusterRoleReconciler{
+		Client:        c,
+		Scheme:        scheme,
+		RBACConverter: &rbacconversion.GenericConverter{},
+		Zanzibar:      store,
+		TypeRelation:  tr,
+		Recorder:      recorder,
+	}
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cr)})
+	assert.NoError(t, err)
+
+	// the unsupported non-resource URL is reported on the clusterrole, and the others are still written
+	if assert.Len(t, recorder.Events, 1) {
+		event := <-recorder.Events
+		assert.True(t, strings.HasPrefix(event, "Warning UnsupportedNonResourceURL"), event)
+	}
+	tuples, err := store.ReadTuples(ctx, zanzibar.TupleFilter{})
+	assert.NoError(t, err)
+	zanzibar.Tuples(tuples).AssertEqualsWanted(zanzibar.Tuples{
+		zanzibar.MustParseTuple("clusterrole:monitoring#assignee get nonresourceurls:/metrics"),
+		zanzibar.MustParseTuple("clusterrole:monitoring#assignee get nonresourceurls:/healthz/*"),
+	}, t, "ReadTuples")
+}
@@ -0,0 +1,250 @@
+package garbagecollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const DefaultInterval = 1 * time.Hour
+
+// DefaultBatchSize is the amount of tuples OpenFGA can delete atomically in one write.
+const DefaultBatchSize = 10
+
+// Source is a Kubernetes API kind that tuples are generated from by the given TypeRelation.
+type Source struct {
+	GVK          schema.GroupVersionKind
+	TypeRelation *zanzibar.TypeRelation
+	// Filter is optional. If set, it filters the tuples generated for all the objects of the source, given the
+	// objects, e.g. as the tuples of one object depend on the others, see rbacconversion.FilterSelectedLabelSets.
+	Filter func(objs []runtime.Object, tuples []zanzibar.Tuple) []zanzibar.Tuple
+	// Active is optional. If set, only the objects active at the time of the sweep generate tuples, e.g. as the
+	// objects expire through their status, see accessrequestsyncer.ActiveObject. Objects expired through
+	// rbacconversion.AnnotationExpiresAt never generate tuples.
+	Active func(obj runtime.Object, now time.Time) bool
+}
+
+// GarbageCollector periodically sweeps the tuple store for tuples that no longer are generated
+// by any Kubernetes object, e.g. due to missed delete events while the controllers were not running,
+// or due to bugs. Only tuples owned by the types of the sources are considered, see TypeRelation.Owns.
+type GarbageCollector struct {
+	// Reader should read directly from the API server, and not from a cache that might be out of
+	// date, as otherwise tuples of newly created objects could be considered orphaned.
+	Reader   client.Reader
+	Scheme   *runtime.Scheme
+	Zanzibar zanzibar.TupleStore
+	Sources  []Source
+
+	// Interval is the time between sweeps, DefaultInterval if unset.
+	Interval time.Duration
+	// DryRun only reports the orphaned tuples, instead of deleting them.
+	DryRun bool
+	// BatchSize is the maximum amount of orphaned tuples deleted in one write, DefaultBatchSize if unset.
+	BatchSize int
+}
+
+// SweepResult is the result of one sweep.
+type SweepResult struct {
+	// Orphaned are the tuples in the store that no object generates anymore. They are
+	// deleted, unless in dry-run mode.
+	Orphaned zanzibar.Tuples
+	// Missing are the tuples some object generates, but are not in the store. They are
+	// only reported, as the syncers are responsible for writing them.
+	Missing zanzibar.Tuples
+	// Failed are the orphaned tuples that could not be deleted. They are retried the next sweep.
+	Failed zanzibar.Tuples
+}
+
+// SetupWithManager runs the garbage collector in the background, when the manager is started.
+func (gc *GarbageCollector) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(gc)
+}
+
+// Start sweeps the store every interval until ctx is done. Errors are logged, and the sweep is
+// retried the next interval.
+func (gc *GarbageCollector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("garbagecollector")
+	interval := gc.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := gc.Sweep(ctx)
+		switch {
+		case result == nil:
+			logger.Error(err, "sweep failed")
+		case err != nil:
+			logger.Error(err, "sweep done, but some orphaned tuples could not be deleted",
+				"failed", util.Map(result.Failed, zanzibar.Tuple.String))
+			fallthrough
+		default:
+			logger.Info("sweep done", "dryRun", gc.DryRun,
+				"orphaned", util.Map(result.Orphaned, zanzibar.Tuple.String),
+				"missing", util.Map(result.Missing, zanzibar.Tuple.String))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Sweep compares all tuples in the store with the tuples generated from all objects of the sources,
+// and deletes the orphaned tuples, unless in dry-run mode. The orphaned tuples are deleted in batches
+// of BatchSize, and a batch that fails does not stop the sweep; then both the result, with the
+// tuples that could not be deleted, and the errors are returned.
+func (gc *GarbageCollector) Sweep(ctx context.Context) (*SweepResult, error) {
+	// Read the store before listing the objects; a tuple written for an object created after the
+	// store was read is not seen, so it cannot be considered orphaned.
+	existing, err := gc.Zanzibar.ReadTuples(ctx, zanzibar.TupleFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read tuples: %w", err)
+	}
+
+	now := time.Now()
+	desired := sets.New[zanzibar.Tuple]()
+	for _, src := range gc.Sources {
+		tuples, err := gc.generateTuples(ctx, src, now)
+		if err != nil {
+			return nil, err
+		}
+		desired.Insert(tuples...)
+	}
+
+	result := &SweepResult{}
+	existingSet := sets.New[zanzibar.Tuple]()
+	for _, t := range existing {
+		existingSet.Insert(t)
+		if !desired.Has(t) && gc.owned(t) {
+			result.Orphaned = append(result.Orphaned, t)
+		}
+	}
+	for t := range desired {
+		if !existingSet.Has(t) {
+			result.Missing = append(result.Missing, t)
+		}
+	}
+	sort.Sort(result.Orphaned)
+	sort.Sort(result.Missing)
+
+	if gc.DryRun {
+		return result, nil
+	}
+
+	batchSize := gc.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+	errs := []error{}
+	for i := 0; i < len(result.Orphaned); i += batchSize {
+		batch := result.Orphaned[i:min(i+batchSize, len(result.Orphaned))]
+		failed, err := gc.deleteTuples(ctx, batch)
+		if err != nil {
+			result.Failed = append(result.Failed, failed...)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return result, fmt.Errorf("couldn't delete orphaned tuples: %w", errors.Join(errs...))
+	}
+	return result, nil
+}
+
+// deleteTuples deletes the tuples, and returns the tuples that could not be deleted. If some tuple was
+// deleted concurrently, the write of the batch conflicts, so then the tuples are deleted one by one,
+// skipping the tuples that do not exist anymore.
+func (gc *GarbageCollector) deleteTuples(ctx context.Context, tuples []zanzibar.Tuple) ([]zanzibar.Tuple, error) {
+	_, err := gc.Zanzibar.WriteTuples(ctx, nil, tuples)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, zanzibar.ErrTupleConflict) {
+		return tuples, err
+	}
+	if len(tuples) == 1 {
+		// already deleted
+		return nil, nil
+	}
+
+	failed := []zanzibar.Tuple{}
+	errs := []error{}
+	for _, t := range tuples {
+		if f, err := gc.deleteTuples(ctx, []zanzibar.Tuple{t}); err != nil {
+			failed = append(failed, f...)
+			errs = append(errs, err)
+		}
+	}
+	return failed, errors.Join(errs...)
+}
+
+// owned returns true if the type of any source owns the tuple. Unlike ReconcileCompute, which owning node of the type
+// (see TypeRelation.Owners) does not matter here, as the tuple is compared to the tuples generated by all objects.
+func (gc *GarbageCollector) owned(t zanzibar.Tuple) bool {
+	return util.Has(gc.Sources, func(src Source) bool {
+		return src.TypeRelation.Owns(t)
+	})
+}
+
+// generateTuples lists all objects of the source, and generates their tuples just like the syncers.
+// Objects being deleted, expired at now (see rbacconversion.AnnotationExpiresAt), or not active at now
+// (see Source.Active), generate no tuples.
+func (gc *GarbageCollector) generateTuples(ctx context.Context, src Source, now time.Time) ([]zanzibar.Tuple, error) {
+	listGVK := src.GVK.GroupVersion().WithKind(src.GVK.Kind + "List")
+	runtimeList, err := gc.Scheme.New(listGVK)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := runtimeList.(client.ObjectList)
+	if !ok {
+		return nil, errors.New("cannot cast list to client.ObjectList")
+	}
+	if err := gc.Reader.List(ctx, list); err != nil {
+		return nil, fmt.Errorf("couldn't list %s: %w", listGVK.Kind, err)
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := gc.Zanzibar.GetAuthorizationSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []zanzibar.Tuple{}
+	for _, item := range items {
+		if obj, ok := item.(client.Object); ok && (obj.GetDeletionTimestamp() != nil || rbacconversion.Expired(obj, now)) {
+			continue
+		}
+		if src.Active != nil && !src.Active(item, now) {
+			continue
+		}
+		tuples, err := zanzibar.GenerateTuplesFor(as, *src.TypeRelation, item)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't generate tuples for %s: %w", src.GVK.Kind, err)
+		}
+		result = append(result, tuples...)
+	}
+	if src.Filter != nil {
+		result = src.Filter(items, result)
+	}
+	return result, nil
+}
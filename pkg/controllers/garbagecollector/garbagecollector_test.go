@@ -0,0 +1,227 @@
+package garbagecollector_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rebacv1alpha1 "github.com/luxas/kube-rebac-authorizer/api/v1alpha1"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/accessrequestsyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/garbagecollector"
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type Tuple = zanzibar.Tuple
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rbacv1.AddToScheme(scheme))
+
+	crb := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "view"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		Subjects:   []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "alice"}},
+	}
+	expired := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "expired",
+			Annotations: map[string]string{rbacconversion.AnnotationExpiresAt: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)},
+		},
+		RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		Subjects: []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "alice"}},
+	}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crb, expired).Build()
+
+	as := rbacconversion.GetSchema()
+	store := inmemory.NewTupleStoreAndChecker(as)
+	live := []Tuple{
+		zanzibar.MustParseTuple("clusterrolebinding:view#assignee assignee clusterrole:view"),
+	}
+	orphaned := []Tuple{
+		// alice was removed from the binding
+		zanzibar.MustParseTuple("user:bob assignee clusterrolebinding:view"),
+		// the binding was deleted
+		zanzibar.MustParseTuple("clusterrolebinding:deleted#assignee assignee clusterrole:view"),
+		zanzibar.MustParseTuple("user:alice assignee clusterrolebinding:deleted"),
+		// the binding expired
+		zanzibar.MustParseTuple("user:alice assignee clusterrolebinding:expired"),
+	}
+	notOwned := []Tuple{
+		// clusterroles are not a source, so their tuples are not touched
+		zanzibar.MustParseTuple("clusterrole:deleted#assignee get resource:core.pods"),
+	}
+	_, err := store.WriteTuples(ctx, append(append(append([]Tuple{}, live...), orphaned...), notOwned...), nil)
+	assert.NoError(t, err)
+
+	gc := &garbagecollector.GarbageCollector{
+		Reader:   reader,
+		Scheme:   scheme,
+		Zanzibar: store,
+		Sources: []garbagecollector.Source{
+			{GVK: rbacv1.SchemeGroupVersion.WithKind("ClusterRoleBinding"), TypeRelation: &as.Types[0]},
+		},
+		DryRun: true,
+	}
+
+	wantOrphaned := zanzibar.Tuples{
+		zanzibar.MustParseTuple("clusterrolebinding:deleted#assignee assignee clusterrole:view"),
+		zanzibar.MustParseTuple("user:alice assignee clusterrolebinding:deleted"),
+		zanzibar.MustParseTuple("user:alice assignee clusterrolebinding:expired"),
+		zanzibar.MustParseTuple("user:bob assignee clusterrolebinding:view"),
+	}
+	wantMissing := zanzibar.Tuples{
+		zanzibar.MustParseTuple("user:alice assignee clusterrolebinding:view"),
+	}
+
+	// dry-run only reports
+	result, err := gc.Sweep(ctx)
+	assert.NoError(t, err)
+	result.Orphaned.AssertEqualsWanted(wantOrphaned, t, "Sweep(orphaned)")
+	result.Missing.AssertEqualsWanted(wantMissing, t, "Sweep(missing)")
+	stored, err := store.ReadTuples(ctx, zanzibar.TupleFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, stored, len(live)+len(orphaned)+len(notOwned))
+
+	// otherwise, the orphaned tuples are deleted
+	gc.DryRun = false
+	result, err = gc.Sweep(ctx)
+	assert.NoError(t, err)
+	result.Orphaned.AssertEqualsWanted(wantOrphaned, t, "Sweep(orphaned)")
+	stored, err = store.ReadTuples(ctx, zanzibar.TupleFilter{})
+	assert.NoError(t, err)
+	zanzibar.Tuples(stored).AssertEqualsWanted(append(append([]Tuple{}, notOwned...), live...), t, "ReadTuples")
+}
+
+// racingStore deletes the tuple concurrently before the first write, and fails all writes of the unavailable tuple
+type racingStore struct {
+	zanzibar.TupleStore
+	concurrentlyDeleted Tuple
+	unavailable         Tuple
+	writes              int
+}
+
+func (s *racingStore) WriteTuples(ctx context.Context, writes, deletes []Tuple) (zanzibar.ConsistencyToken, error) {
+	s.writes++
+	if s.writes == 1 {
+		if _, err := s.TupleStore.WriteTuples(ctx, nil, []Tuple{s.concurrentlyDeleted}); err != nil {
+			return "", err
+		}
+	}
+	for _, t := range deletes {
+		if t == s.unavailable {
+			return "", zanzibar.ErrBackendUnavailable
+		}
+	}
+	return s.TupleStore.WriteTuples(ctx, writes, deletes)
+}
+
+func TestSweep_batches(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rbacv1.AddToScheme(scheme))
+	reader := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	as := rbacconversion.GetSchema()
+	backend := inmemory.NewTupleStoreAndChecker(as)
+	orphaned := zanzibar.Tuples{}
+	for _, user := range []string{"alice", "bob", "carol", "dave", "eve"} {
+		orphaned = append(orphaned, zanzibar.NewTuple("user", user, "assignee", "clusterrolebinding", "deleted"))
+	}
+	_, err := backend.WriteTuples(ctx, orphaned, nil)
+	assert.NoError(t, err)
+
+	store := &racingStore{TupleStore: backend, concurrentlyDeleted: orphaned[0], unavailable: orphaned[3]}
+	gc := &garbagecollector.GarbageCollector{
+		Reader:   reader,
+		Scheme:   scheme,
+		Zanzibar: store,
+		Sources: []garbagecollector.Source{
+			{GVK: rbacv1.SchemeGroupVersion.WithKind("ClusterRoleBinding"), TypeRelation: &as.Types[0]},
+		},
+		BatchSize: 2,
+	}
+
+	// the conflicting batch is deleted tuple by tuple, and the failing batch does not stop the sweep
+	result, err := gc.Sweep(ctx)
+	assert.ErrorIs(t, err, zanzibar.ErrBackendUnavailable)
+	result.Orphaned.AssertEqualsWanted(orphaned, t, "Sweep(orphaned)")
+	result.Failed.AssertEqualsWanted(orphaned[2:4], t, "Sweep(failed)")
+	stored, err := backend.ReadTuples(ctx, zanzibar.TupleFilter{})
+	assert.NoError(t, err)
+	zanzibar.Tuples(stored).AssertEqualsWanted(orphaned[2:4], t, "ReadTuples")
+}
+
+func TestSweep_active(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rebacv1alpha1.AddToScheme(scheme))
+
+	targets := []accessrequestsyncer.Target{{
+		ObjectType: rbacconversion.TypeClusterRole,
+		Relations:  []string{rbacconversion.RelationClusterRoleAssignee},
+	}}
+	as := rbacconversion.GetSchema()
+	as.Types = append(as.Types, accessrequestsyncer.GetTypeRelation(targets))
+	accessRequestType := &as.Types[len(as.Types)-1]
+
+	accessRequest := func(name string, expiresAt time.Time) *rebacv1alpha1.AccessRequest {
+		return &rebacv1alpha1.AccessRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: rebacv1alpha1.AccessRequestSpec{
+				User:       "alice",
+				Relation:   rbacconversion.RelationClusterRoleAssignee,
+				ObjectType: rbacconversion.TypeClusterRole,
+				ObjectID:   "cluster-admin",
+			},
+			Status: rebacv1alpha1.AccessRequestStatus{
+				Phase:     rebacv1alpha1.AccessRequestActive,
+				ExpiresAt: &metav1.Time{Time: expiresAt},
+			},
+		}
+	}
+	// the controller has not yet reconciled the expired access request, so it is still in the active phase
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		accessRequest("active", time.Now().Add(time.Hour)),
+		accessRequest("expired", time.Now().Add(-time.Hour)),
+	).Build()
+
+	store := inmemory.NewTupleStoreAndChecker(as)
+	live := []Tuple{
+		zanzibar.MustParseTuple("user:alice grantee accessrequest:active"),
+		zanzibar.MustParseTuple("accessrequest:active#grantee assignee clusterrole:cluster-admin"),
+	}
+	expired := []Tuple{
+		zanzibar.MustParseTuple("user:alice grantee accessrequest:expired"),
+		zanzibar.MustParseTuple("accessrequest:expired#grantee assignee clusterrole:cluster-admin"),
+	}
+	_, err := store.WriteTuples(ctx, append(append([]Tuple{}, live...), expired...), nil)
+	assert.NoError(t, err)
+
+	gc := &garbagecollector.GarbageCollector{
+		Reader:   reader,
+		Scheme:   scheme,
+		Zanzibar: store,
+		Sources: []garbagecollector.Source{{
+			GVK:          rebacv1alpha1.GroupVersion.WithKind("AccessRequest"),
+			TypeRelation: accessRequestType,
+			Active:       accessrequestsyncer.ActiveObject,
+		}},
+	}
+
+	// the tuples of the expired access request are deleted, even though its phase has not been updated yet
+	result, err := gc.Sweep(ctx)
+	assert.NoError(t, err)
+	result.Orphaned.AssertEqualsWanted(expired, t, "Sweep(orphaned)")
+	assert.Empty(t, result.Missing)
+	stored, err := store.ReadTuples(ctx, zanzibar.TupleFilter{})
+	assert.NoError(t, err)
+	zanzibar.Tuples(stored).AssertEqualsWanted(live, t, "ReadTuples")
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/syncutil"
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -19,6 +22,8 @@ type GenericTupleReconciler struct {
 	Zanzibar     zanzibar.TupleStore
 	TypeRelation *zanzibar.TypeRelation
 	GVK          schema.GroupVersionKind
+	// Recorder is optional. If set, the tuples of the object that cannot be written are reported as events on it.
+	Recorder record.EventRecorder
 }
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -40,20 +45,29 @@ func (r *GenericTupleReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
-	// TODO: Do we need to register a finalizer on synced objects, or how can we detect deletions?
-
-	if err := r.Client.Get(ctx, req.NamespacedName, obj); err != nil {
-		return ctrl.Result{}, err
-	}
-
-	logger.Info("got obj", "obj", obj)
-
-	tuples, err := zanzibar.GenerateTuplesFor(*r.TypeRelation, obj)
-	if err != nil {
+	var tuples []zanzibar.Tuple
+	if err := r.Client.Get(ctx, req.NamespacedName, obj); client.IgnoreNotFound(err) != nil {
 		return ctrl.Result{}, err
+	} else if err != nil || obj.GetDeletionTimestamp() != nil {
+		// The object is deleted, or being deleted, so delete all tuples it owns, by reconciling
+		// with no desired tuples. Only the name and namespace are needed for computing the node ID.
+		logger.Info("deleting tuples of deleted object", "name", req.Name)
+		obj.SetName(req.Name)
+		obj.SetNamespace(req.Namespace)
+	} else {
+		logger.Info("got obj", "obj", obj)
+
+		as, err := r.Zanzibar.GetAuthorizationSchema(ctx)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		tuples, err = zanzibar.GenerateTuplesFor(as, *r.TypeRelation, obj)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
-	logger.Info("got tuples", "tuples", tuples)
+	logger.Info("got tuples", "tuples", util.Map(tuples, zanzibar.Tuple.String))
 
 	nodeid, err := r.TypeRelation.GetID(obj)
 	if err != nil {
@@ -67,9 +81,9 @@ func (r *GenericTupleReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
-	logger.Info("got reconcile result", "adds", adds, "deletes", deletes)
+	logger.Info("got reconcile result", "adds", util.Map(adds, zanzibar.Tuple.String), "deletes", util.Map(deletes, zanzibar.Tuple.String))
 
-	return ctrl.Result{}, r.Zanzibar.WriteTuples(ctx, adds, deletes)
+	return syncutil.WriteAndReport(ctx, r.Zanzibar, r.Recorder, obj, adds, deletes)
 }
 
 // SetupWithManager sets up the controller with the Manager.
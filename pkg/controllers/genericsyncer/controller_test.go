@@ -0,0 +1,46 @@
+package genericsyncer_test
+
+import (
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/genericsyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/controllertesting"
+	"github.com/luxas/kube-rebac-authorizer/pkg/nodeauth"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile_delete(t *testing.T) {
+	controllertesting.DeleteTest{
+		Schema:   *nodeauth.GetSchema(),
+		TypeName: "core.pod",
+		Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				NodeName: "node-1",
+				Containers: []corev1.Container{{
+					Name: "web",
+					EnvFrom: []corev1.EnvFromSource{{
+						SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}},
+					}},
+				}},
+			},
+		},
+		WantTuples: []zanzibar.Tuple{
+			zanzibar.MustParseTuple("core.node:node-1 node_to_pod core.pod:default/web"),
+			zanzibar.MustParseTuple("core.pod:default/web pod_to_secret core.secret:default/creds"),
+		},
+		NewReconciler: func(c client.Client, store zanzibar.TupleStore, tr *zanzibar.TypeRelation) reconcile.Reconciler {
+			return &genericsyncer.GenericTupleReconciler{
+				Client:       c,
+				Scheme:       c.Scheme(),
+				Zanzibar:     store,
+				TypeRelation: tr,
+				GVK:          corev1.SchemeGroupVersion.WithKind("Pod"),
+			}
+		},
+	}.Run(t)
+}
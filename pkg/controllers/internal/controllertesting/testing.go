@@ -0,0 +1,99 @@
+package controllertesting
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// DeleteTest tests that a reconciler deletes all tuples of an object when the object is deleted.
+type DeleteTest struct {
+	// Schema is the authorization schema of the tuples, and TypeName the type of the object in it.
+	Schema   zanzibar.AuthorizationSchema
+	TypeName string
+	// Object is reconciled first, which must write WantTuples, and then deleted. It must be of a
+	// kind in the client-go scheme.
+	Object     client.Object
+	WantTuples []zanzibar.Tuple
+	// NewReconciler returns the reconciler under test, reading from the client and writing to the store.
+	NewReconciler func(c client.Client, store zanzibar.TupleStore, tr *zanzibar.TypeRelation) reconcile.Reconciler
+}
+
+type deleteCase struct {
+	name       string
+	finalizers []string
+	delete     func(ctx context.Context, c client.Client, obj client.Object) error
+}
+
+// Run runs the test for the object being deleted, for its deletion being blocked by a finalizer, and,
+// if the object is namespaced, for its namespace being deleted.
+func (dt DeleteTest) Run(t *testing.T) {
+	tests := []deleteCase{
+		{name: "deleted", delete: deleteObject},
+		// the tuples are deleted already while the deletion is blocked by the finalizer
+		{name: "being deleted", finalizers: []string{"example.com/keep"}, delete: deleteObject},
+	}
+	if len(dt.Object.GetNamespace()) != 0 {
+		tests = append(tests, deleteCase{name: "namespace deleted", delete: deleteNamespace})
+	}
+
+	tr, err := util.MatchOne(dt.Schema.Types, func(tr zanzibar.TypeRelation) bool {
+		return tr.TypeName == dt.TypeName
+	})
+	if err != nil {
+		t.Fatalf("type %s: %v", dt.TypeName, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			obj := dt.Object.DeepCopyObject().(client.Object)
+			obj.SetFinalizers(tt.finalizers)
+			objs := []client.Object{obj}
+			if namespace := obj.GetNamespace(); len(namespace) != 0 {
+				objs = append(objs, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()
+			store := inmemory.NewTupleStoreAndChecker(dt.Schema)
+			r := dt.NewReconciler(c, store, tr)
+
+			reconcile := func() []zanzibar.Tuple {
+				t.Helper()
+				_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+				assert.NoError(t, err)
+				tuples, err := store.ReadTuples(ctx, zanzibar.TupleFilter{})
+				assert.NoError(t, err)
+				return tuples
+			}
+
+			zanzibar.Tuples(reconcile()).AssertEqualsWanted(dt.WantTuples, t, "ReadTuples")
+
+			assert.NoError(t, tt.delete(ctx, c, obj))
+			assert.Empty(t, reconcile())
+		})
+	}
+}
+
+func deleteObject(ctx context.Context, c client.Client, obj client.Object) error {
+	return c.Delete(ctx, obj)
+}
+
+// deleteNamespace deletes the namespace of the object, and then all objects of its kind in the namespace,
+// like the namespace controller of the API server does, which the fake client does not run.
+func deleteNamespace(ctx context.Context, c client.Client, obj client.Object) error {
+	if err := c.Delete(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: obj.GetNamespace()}}); err != nil {
+		return err
+	}
+	return c.DeleteAllOf(ctx, obj, client.InNamespace(obj.GetNamespace()))
+}
@@ -0,0 +1,31 @@
+package syncutil
+
+import (
+	"context"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// WriteAndReport writes the tuples computed for obj through zanzibar.WriteValidTuples. The invalid tuples are
+// logged, and reported as events on obj if recorder is non-nil. Permanent errors are returned as terminal
+// errors, as retrying the same write fails the same way until the object changes.
+func WriteAndReport(ctx context.Context, store zanzibar.TupleStore, recorder record.EventRecorder, obj runtime.Object, adds, deletes []zanzibar.Tuple) (ctrl.Result, error) {
+	_, invalid, err := zanzibar.WriteValidTuples(ctx, store, adds, deletes)
+	for _, tupleErr := range invalid {
+		// the other tuples are written, but writing this tuple fails the same way until the object changes
+		log.FromContext(ctx).Error(tupleErr, "skipped invalid tuple")
+		if recorder != nil {
+			recorder.Event(obj, corev1.EventTypeWarning, "InvalidTuple", tupleErr.Error())
+		}
+	}
+	if zanzibar.IsPermanent(err) {
+		return ctrl.Result{}, reconcile.TerminalError(err)
+	}
+	return ctrl.Result{}, err
+}
@@ -5,13 +5,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/syncutil"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 // RoleBindingReconciler reconciles a RBAC RoleBinding object
@@ -21,6 +26,13 @@ type RoleBindingReconciler struct {
 	RBACConverter rbacconversion.RBACTupleConverter
 	Zanzibar      zanzibar.TupleStore
 	TypeRelation  *zanzibar.TypeRelation
+	// HighWaterMark is optional. If set, the authorizer waits for the observed changes to be reconciled.
+	HighWaterMark *zanzibar.HighWaterMark
+	// Clock is optional, and defaults to the real clock. Whether the binding is expired is
+	// evaluated at the time of the reconcile, see rbacconversion.AnnotationExpiresAt.
+	Clock clock.PassiveClock
+	// Recorder is optional. If set, the tuples of the object that cannot be written are reported as events on it.
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=rebac.luxaslabs.com,resources=typerelations,verbs=get;list;watch;create;update;patch;delete
@@ -38,6 +50,7 @@ type RoleBindingReconciler struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.0/pkg/reconcile
 func (r *RoleBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
+	defer r.HighWaterMark.Reconciled(r.TypeRelation.TypeName + "/" + req.NamespacedName.String())
 
 	logger.V(3).Info("getting rolebinding", "name", req.Name)
 
@@ -46,16 +59,23 @@ func (r *RoleBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{Requeue: false, RequeueAfter: 100 * time.Minute}, nil
 	}
 
+	now := r.now()
 	cr := rbacv1.RoleBinding{}
-	if err := r.Client.Get(ctx, req.NamespacedName, &cr); err != nil {
-		return ctrl.Result{}, err
-	}
-
-	logger.V(3).Info("got rolebinding", "rolebinding", cr)
-
-	tuples, err := r.RBACConverter.ConvertRoleBindingToTuples(ctx, cr)
-	if err != nil {
+	var tuples []zanzibar.Tuple
+	if err := r.Client.Get(ctx, req.NamespacedName, &cr); client.IgnoreNotFound(err) != nil {
 		return ctrl.Result{}, err
+	} else if err != nil || cr.DeletionTimestamp != nil || rbacconversion.Expired(&cr, now) {
+		// The rolebinding is deleted, being deleted, or expired, so delete all tuples it owns, by reconciling
+		// with no desired tuples. Only the name and namespace are needed for computing the node ID.
+		logger.Info("deleting tuples of deleted or expired rolebinding", "name", req.Name)
+		cr.Name, cr.Namespace = req.Name, req.Namespace
+	} else {
+		logger.V(3).Info("got rolebinding", "rolebinding", cr)
+
+		tuples, err = r.RBACConverter.ConvertRoleBindingToTuples(ctx, cr)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	logger.V(3).Info("got tuples", "tuples", tuples)
@@ -74,12 +94,27 @@ func (r *RoleBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	logger.V(3).Info("got reconcile result", "adds", adds, "deletes", deletes)
 
-	return ctrl.Result{}, r.Zanzibar.WriteTuples(ctx, adds, deletes)
+	if result, err := syncutil.WriteAndReport(ctx, r.Zanzibar, r.Recorder, &cr, adds, deletes); err != nil {
+		return result, err
+	}
+
+	// reconcile again when the rolebinding expires, such that its tuples are removed
+	return ctrl.Result{RequeueAfter: rbacconversion.ExpiresIn(&cr, now)}, nil
+}
+
+func (r *RoleBindingReconciler) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *RoleBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&rbacv1.RoleBinding{}).
+		For(&rbacv1.RoleBinding{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			r.HighWaterMark.Observe(r.TypeRelation.TypeName + "/" + client.ObjectKeyFromObject(obj).String())
+			return true
+		}))).
 		Complete(r)
 }
@@ -0,0 +1,39 @@
+package rolebindingsyncer_test
+
+import (
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/controllertesting"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/rolebindingsyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile_delete(t *testing.T) {
+	controllertesting.DeleteTest{
+		Schema:   rbacconversion.GetSchema(),
+		TypeName: rbacconversion.TypeNamespacedRoleBinding,
+		Object: &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit", Namespace: "default"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "edit"},
+			Subjects:   []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "alice"}},
+		},
+		WantTuples: []zanzibar.Tuple{
+			zanzibar.MustParseTuple("rolebinding:default/edit#namespaced_assignee namespaced_assignee role:default/edit"),
+			zanzibar.MustParseTuple("user:alice namespaced_assignee rolebinding:default/edit"),
+		},
+		NewReconciler: func(c client.Client, store zanzibar.TupleStore, tr *zanzibar.TypeRelation) reconcile.Reconciler {
+			return &rolebindingsyncer.RoleBindingReconciler{
+				Client:        c,
+				Scheme:        c.Scheme(),
+				RBACConverter: &rbacconversion.GenericConverter{},
+				Zanzibar:      store,
+				TypeRelation:  tr,
+			}
+		},
+	}.Run(t)
+}
@@ -5,13 +5,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/syncutil"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 // RoleReconciler reconciles a RBAC Role object
@@ -21,6 +25,10 @@ type RoleReconciler struct {
 	RBACConverter rbacconversion.RBACTupleConverter
 	Zanzibar      zanzibar.TupleStore
 	TypeRelation  *zanzibar.TypeRelation
+	// HighWaterMark is optional. If set, the authorizer waits for the observed changes to be reconciled.
+	HighWaterMark *zanzibar.HighWaterMark
+	// Recorder is optional. If set, the tuples of the object that cannot be written are reported as events on it.
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=rebac.luxaslabs.com,resources=typerelations,verbs=get;list;watch;create;update;patch;delete
@@ -38,6 +46,7 @@ type RoleReconciler struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.0/pkg/reconcile
 func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
+	defer r.HighWaterMark.Reconciled(r.TypeRelation.TypeName + "/" + req.NamespacedName.String())
 
 	logger.V(3).Info("getting role", "name", req.Name)
 
@@ -47,15 +56,21 @@ func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	}
 
 	cr := rbacv1.Role{}
-	if err := r.Client.Get(ctx, req.NamespacedName, &cr); err != nil {
-		return ctrl.Result{}, err
-	}
-
-	logger.V(3).Info("got role", "role", cr)
-
-	tuples, err := r.RBACConverter.ConvertRoleToTuples(ctx, cr)
-	if err != nil {
+	var tuples []zanzibar.Tuple
+	if err := r.Client.Get(ctx, req.NamespacedName, &cr); client.IgnoreNotFound(err) != nil {
 		return ctrl.Result{}, err
+	} else if err != nil || cr.DeletionTimestamp != nil {
+		// The role is deleted, or being deleted, so delete all tuples it owns, by reconciling
+		// with no desired tuples. Only the name and namespace are needed for computing the node ID.
+		logger.Info("deleting tuples of deleted role", "name", req.Name)
+		cr.Name, cr.Namespace = req.Name, req.Namespace
+	} else {
+		logger.V(3).Info("got role", "role", cr)
+
+		tuples, err = r.RBACConverter.ConvertRoleToTuples(ctx, cr)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	logger.V(3).Info("got tuples", "tuples", tuples)
@@ -74,12 +89,15 @@ func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 
 	logger.V(3).Info("got reconcile result", "adds", adds, "deletes", deletes)
 
-	return ctrl.Result{}, r.Zanzibar.WriteTuples(ctx, adds, deletes)
+	return syncutil.WriteAndReport(ctx, r.Zanzibar, r.Recorder, &cr, adds, deletes)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *RoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&rbacv1.Role{}).
+		For(&rbacv1.Role{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			r.HighWaterMark.Observe(r.TypeRelation.TypeName + "/" + client.ObjectKeyFromObject(obj).String())
+			return true
+		}))).
 		Complete(r)
 }
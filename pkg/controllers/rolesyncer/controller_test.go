@@ -0,0 +1,38 @@
+package rolesyncer_test
+
+import (
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/internal/controllertesting"
+	"github.com/luxas/kube-rebac-authorizer/pkg/controllers/rolesyncer"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile_delete(t *testing.T) {
+	controllertesting.DeleteTest{
+		Schema:   rbacconversion.GetSchema(),
+		TypeName: rbacconversion.TypeNamespacedRole,
+		Object: &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit", Namespace: "default"},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"update"}}},
+		},
+		WantTuples: []zanzibar.Tuple{
+			zanzibar.MustParseTuple("namespace:default contains role:default/edit"),
+			zanzibar.MustParseTuple("role:default/edit#assignee update resource:core.pods"),
+		},
+		NewReconciler: func(c client.Client, store zanzibar.TupleStore, tr *zanzibar.TypeRelation) reconcile.Reconciler {
+			return &rolesyncer.RoleReconciler{
+				Client:        c,
+				Scheme:        c.Scheme(),
+				RBACConverter: &rbacconversion.GenericConverter{},
+				Zanzibar:      store,
+				TypeRelation:  tr,
+			}
+		},
+	}.Run(t)
+}
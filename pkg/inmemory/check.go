@@ -0,0 +1,285 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// maxResolutionDepth mirrors the default ResolveNodeLimit of OpenFGA, that is, how deeply
+// nested a check request can become before it errors out.
+const maxResolutionDepth = 25
+
+var errResolutionDepthExceeded = errors.New("resolution depth exceeded")
+
+// typeSystem is the authorization schema compiled into a lookup table for evaluation.
+// The semantics are the same as for the model produced by openfga.BuildAuthorizationModel:
+// Incoming and Outgoing relations define directly assignable relations, and if a relation
+// also has an EvaluatedUserset, the direct relation is unioned with the rewrite.
+type typeSystem map[string]map[string]*relationDefinition
+
+type relationDefinition struct {
+	// directlyRelated contains all user types (with an optional userset relation) that
+	// can be directly related to the object through this relation. If empty, the
+	// relation is not directly assignable.
+	directlyRelated sets.Set[typeUserset]
+	// rewrite is the evaluated userset for this relation, if any.
+	rewrite *zanzibar.EvaluatedUserset
+}
+
+type typeUserset struct {
+	TypeName        string
+	UserSetRelation string
+}
+
+func newTypeSystem(as zanzibar.AuthorizationSchema) typeSystem {
+	ts := typeSystem{}
+
+	for _, tr := range as.Types {
+		ts.getOrCreateType(tr.TypeName)
+
+		for _, incoming := range tr.Incoming {
+			ts.getOrCreateType(incoming.UserType)
+			ts.getOrCreateRelation(tr.TypeName, incoming.Relation).directlyRelated.Insert(typeUserset{
+				TypeName:        incoming.UserType,
+				UserSetRelation: incoming.UserSetRelation,
+			})
+		}
+
+		for _, outgoing := range tr.Outgoing {
+			for _, relation := range outgoing.Relations {
+				ts.getOrCreateRelation(outgoing.ObjectType, relation).directlyRelated.Insert(typeUserset{
+					TypeName:        tr.TypeName,
+					UserSetRelation: outgoing.UserSetRelation,
+				})
+			}
+		}
+	}
+
+	for _, tr := range as.Types {
+		for relation := range tr.EvaluatedUsersets {
+			// take a copy of the map value to be able to refer to it
+			rewrite := tr.EvaluatedUsersets[relation]
+			ts.getOrCreateRelation(tr.TypeName, relation).rewrite = &rewrite
+		}
+	}
+
+	return ts
+}
+
+func (ts typeSystem) getOrCreateType(typeName string) map[string]*relationDefinition {
+	relations, ok := ts[typeName]
+	if !ok {
+		relations = map[string]*relationDefinition{}
+		ts[typeName] = relations
+	}
+	return relations
+}
+
+func (ts typeSystem) getOrCreateRelation(typeName, relation string) *relationDefinition {
+	relations := ts.getOrCreateType(typeName)
+	rd, ok := relations[relation]
+	if !ok {
+		rd = &relationDefinition{directlyRelated: sets.New[typeUserset]()}
+		relations[relation] = rd
+	}
+	return rd
+}
+
+func (ts typeSystem) getRelation(typeName, relation string) (*relationDefinition, error) {
+	relations, ok := ts[typeName]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", zanzibar.ErrUnknownType, typeName)
+	}
+	rd, ok := relations[relation]
+	if !ok {
+		return nil, fmt.Errorf("%w %q for type %q", zanzibar.ErrUnknownRelation, relation, typeName)
+	}
+	return rd, nil
+}
+
+// validateTuple makes sure the tuple can be written according to the schema, that is, that
+// the user type (and userset relation) is directly assignable to the object through the relation.
+func (ts typeSystem) validateTuple(t Tuple) error {
+	rd, err := ts.getRelation(t.Object.NodeType(), t.Relation)
+	if err != nil {
+		return fmt.Errorf("%w: %w", zanzibar.ErrInvalidTuple, err)
+	}
+	if !rd.directlyRelated.Has(typeUserset{TypeName: t.User.NodeType(), UserSetRelation: t.GetUserSetRelation()}) {
+		return fmt.Errorf("%w: user type is not directly assignable to the relation", zanzibar.ErrInvalidTuple)
+	}
+	return nil
+}
+
+// resolver evaluates one check request, with its contextual tuples, against the store.
+type resolver struct {
+	ts         typeSystem
+	stored     map[objectRelation]sets.Set[Tuple]
+	contextual map[objectRelation]sets.Set[Tuple]
+	// visiting contains all (object, relation, user) triples on the current resolution path,
+	// for detecting cycles in the graph.
+	visiting sets.Set[resolveKey]
+}
+
+func newResolver(ts typeSystem, tuple Tuple, contextualTuples []Tuple) (*resolver, error) {
+	if !tuple.Valid() {
+		return nil, fmt.Errorf("cannot check %w %s", zanzibar.ErrInvalidTuple, tuple)
+	}
+
+	contextual := map[objectRelation]sets.Set[Tuple]{}
+	for _, ct := range contextualTuples {
+		if !ct.Valid() {
+			return nil, fmt.Errorf("contextual tuple: %w %s", zanzibar.ErrInvalidTuple, ct)
+		}
+		ct = normalizeTuple(ct)
+		key := objectRelationFor(ct.Object.NodeType(), ct.Object.NodeName(), ct.Relation)
+		if _, ok := contextual[key]; !ok {
+			contextual[key] = sets.New[Tuple]()
+		}
+		contextual[key].Insert(ct)
+	}
+
+	return &resolver{
+		ts:         ts,
+		contextual: contextual,
+		visiting:   sets.New[resolveKey](),
+	}, nil
+}
+
+type resolveKey struct {
+	object   zanzibar.Node
+	relation string
+	user     zanzibar.Node
+}
+
+// tuplesFor returns both the stored and the contextual tuples for the given object and relation.
+func (r *resolver) tuplesFor(object zanzibar.Node, relation string) []Tuple {
+	key := objectRelationFor(object.NodeType(), object.NodeName(), relation)
+	return append(r.stored[key].UnsortedList(), r.contextual[key].UnsortedList()...)
+}
+
+// check answers whether user is related to object through relation.
+func (r *resolver) check(ctx context.Context, object zanzibar.Node, relation string, user zanzibar.Node, depth int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if depth >= maxResolutionDepth {
+		return false, errResolutionDepthExceeded
+	}
+
+	rd, err := r.ts.getRelation(object.NodeType(), relation)
+	if err != nil {
+		return false, err
+	}
+
+	// A cycle in the graph can never make the user related, so just stop evaluating that branch
+	key := resolveKey{object: object, relation: relation, user: user}
+	if r.visiting.Has(key) {
+		return false, nil
+	}
+	r.visiting.Insert(key)
+	defer r.visiting.Delete(key)
+
+	if rd.directlyRelated.Len() != 0 {
+		allowed, err := r.checkDirect(ctx, object, relation, user, depth)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+
+	if rd.rewrite != nil {
+		return r.checkRewrite(ctx, object, *rd.rewrite, user, depth)
+	}
+	return false, nil
+}
+
+// checkDirect looks for a tuple from the user to the object, or a tuple from a userset
+// which the user is part of to the object.
+func (r *resolver) checkDirect(ctx context.Context, object zanzibar.Node, relation string, user zanzibar.Node, depth int) (bool, error) {
+	var errs []error
+	for _, t := range r.tuplesFor(object, relation) {
+		if t.User == user {
+			return true, nil
+		}
+
+		us, ok := t.GetUserSet()
+		if !ok {
+			continue
+		}
+		allowed, err := r.check(ctx, zanzibar.NewNode(us.NodeType(), us.NodeName()), us.UserSetRelation(), user, depth+1)
+		if allowed {
+			return true, nil
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return false, errors.Join(errs...)
+}
+
+func (r *resolver) checkRewrite(ctx context.Context, object zanzibar.Node, rewrite zanzibar.EvaluatedUserset, user zanzibar.Node, depth int) (bool, error) {
+	switch {
+	case len(rewrite.Union) != 0:
+		var errs []error
+		for _, child := range rewrite.Union {
+			allowed, err := r.checkRewrite(ctx, object, child, user, depth)
+			if allowed {
+				return true, nil
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return false, errors.Join(errs...)
+
+	case len(rewrite.Intersection) != 0:
+		for _, child := range rewrite.Intersection {
+			allowed, err := r.checkRewrite(ctx, object, child, user, depth)
+			if err != nil || !allowed {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case rewrite.Difference != nil:
+		allowed, err := r.checkRewrite(ctx, object, rewrite.Difference.Base, user, depth)
+		if err != nil || !allowed {
+			return false, err
+		}
+		subtracted, err := r.checkRewrite(ctx, object, rewrite.Difference.Subtract, user, depth)
+		if err != nil {
+			return false, err
+		}
+		return !subtracted, nil
+
+	case len(rewrite.Relation) != 0:
+		return r.check(ctx, object, rewrite.Relation, user, depth+1)
+
+	case rewrite.TupleToUserset != nil:
+		var errs []error
+		for _, t := range r.tuplesFor(object, rewrite.TupleToUserset.FromRelation) {
+			// Only objects, not usersets, can be used in the tupleset, just like in OpenFGA
+			if zanzibar.IsUserSet(t.User) {
+				continue
+			}
+			// If the referenced relation does not exist on the tupleset type, the tuple is skipped
+			if _, err := r.ts.getRelation(t.User.NodeType(), rewrite.TupleToUserset.ReferencedRelation); err != nil {
+				continue
+			}
+			allowed, err := r.check(ctx, t.User, rewrite.TupleToUserset.ReferencedRelation, user, depth+1)
+			if allowed {
+				return true, nil
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return false, errors.Join(errs...)
+	}
+
+	// An empty rewrite never matches anyone
+	return false, nil
+}
@@ -0,0 +1,186 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+)
+
+// expand works like check, but returns the tree of all branches through which user is related
+// to object through relation. If the user is not related, nil is returned.
+func (r *resolver) expand(ctx context.Context, object zanzibar.Node, relation string, user zanzibar.Node, depth int) (*zanzibar.UsersetTree, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if depth >= maxResolutionDepth {
+		return nil, errResolutionDepthExceeded
+	}
+
+	rd, err := r.ts.getRelation(object.NodeType(), relation)
+	if err != nil {
+		return nil, err
+	}
+
+	key := resolveKey{object: object, relation: relation, user: user}
+	if r.visiting.Has(key) {
+		return nil, nil
+	}
+	r.visiting.Insert(key)
+	defer r.visiting.Delete(key)
+
+	var children []*zanzibar.UsersetTree
+	var errs []error
+	if rd.directlyRelated.Len() != 0 {
+		tree, err := r.expandDirect(ctx, object, relation, user, depth)
+		if tree != nil {
+			children = append(children, tree)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if rd.rewrite != nil {
+		tree, err := r.expandRewrite(ctx, object, relation, *rd.rewrite, user, depth)
+		if tree != nil {
+			children = append(children, tree)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	switch len(children) {
+	case 0:
+		return nil, errors.Join(errs...)
+	case 1:
+		return children[0], nil
+	}
+	// both the direct relation and the rewrite lead to the user
+	return &zanzibar.UsersetTree{
+		Object:   object,
+		Relation: relation,
+		Kind:     zanzibar.UsersetTreeUnion,
+		Children: children,
+	}, nil
+}
+
+func (r *resolver) expandDirect(ctx context.Context, object zanzibar.Node, relation string, user zanzibar.Node, depth int) (*zanzibar.UsersetTree, error) {
+	tree := &zanzibar.UsersetTree{
+		Object:   object,
+		Relation: relation,
+		Kind:     zanzibar.UsersetTreeDirect,
+	}
+	var errs []error
+	for _, t := range r.sortedTuplesFor(object, relation) {
+		if t.User == user {
+			tree.Users = append(tree.Users, t.User)
+			continue
+		}
+
+		us, ok := t.GetUserSet()
+		if !ok {
+			continue
+		}
+		child, err := r.expand(ctx, zanzibar.NewNode(us.NodeType(), us.NodeName()), us.UserSetRelation(), user, depth+1)
+		if child != nil {
+			tree.Users = append(tree.Users, t.User)
+			tree.Children = append(tree.Children, child)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(tree.Users) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return tree, nil
+}
+
+func (r *resolver) expandRewrite(ctx context.Context, object zanzibar.Node, relation string, rewrite zanzibar.EvaluatedUserset, user zanzibar.Node, depth int) (*zanzibar.UsersetTree, error) {
+	tree := &zanzibar.UsersetTree{
+		Object:   object,
+		Relation: relation,
+	}
+
+	switch {
+	case len(rewrite.Union) != 0:
+		tree.Kind = zanzibar.UsersetTreeUnion
+		var errs []error
+		for _, child := range rewrite.Union {
+			childTree, err := r.expandRewrite(ctx, object, relation, child, user, depth)
+			if childTree != nil {
+				tree.Children = append(tree.Children, childTree)
+			} else if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(tree.Children) == 0 {
+			return nil, errors.Join(errs...)
+		}
+		return tree, nil
+
+	case len(rewrite.Intersection) != 0:
+		tree.Kind = zanzibar.UsersetTreeIntersection
+		for _, child := range rewrite.Intersection {
+			childTree, err := r.expandRewrite(ctx, object, relation, child, user, depth)
+			if err != nil || childTree == nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, childTree)
+		}
+		return tree, nil
+
+	case rewrite.Difference != nil:
+		tree.Kind = zanzibar.UsersetTreeDifference
+		base, err := r.expandRewrite(ctx, object, relation, rewrite.Difference.Base, user, depth)
+		if err != nil || base == nil {
+			return nil, err
+		}
+		subtracted, err := r.checkRewrite(ctx, object, rewrite.Difference.Subtract, user, depth)
+		if err != nil || subtracted {
+			return nil, err
+		}
+		tree.Children = []*zanzibar.UsersetTree{base}
+		return tree, nil
+
+	case len(rewrite.Relation) != 0:
+		tree.Kind = zanzibar.UsersetTreeComputed
+		child, err := r.expand(ctx, object, rewrite.Relation, user, depth+1)
+		if err != nil || child == nil {
+			return nil, err
+		}
+		tree.Children = []*zanzibar.UsersetTree{child}
+		return tree, nil
+
+	case rewrite.TupleToUserset != nil:
+		tree.Kind = zanzibar.UsersetTreeTupleToUserset
+		tree.Tupleset = rewrite.TupleToUserset.FromRelation
+		var errs []error
+		for _, t := range r.sortedTuplesFor(object, rewrite.TupleToUserset.FromRelation) {
+			if zanzibar.IsUserSet(t.User) {
+				continue
+			}
+			if _, err := r.ts.getRelation(t.User.NodeType(), rewrite.TupleToUserset.ReferencedRelation); err != nil {
+				continue
+			}
+			child, err := r.expand(ctx, t.User, rewrite.TupleToUserset.ReferencedRelation, user, depth+1)
+			if child != nil {
+				tree.Children = append(tree.Children, child)
+			} else if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(tree.Children) == 0 {
+			return nil, errors.Join(errs...)
+		}
+		return tree, nil
+	}
+
+	return nil, nil
+}
+
+// sortedTuplesFor is like tuplesFor, but sorted, such that the expanded tree is deterministic.
+func (r *resolver) sortedTuplesFor(object zanzibar.Node, relation string) zanzibar.Tuples {
+	tuples := zanzibar.Tuples(r.tuplesFor(object, relation))
+	sort.Sort(tuples)
+	return tuples
+}
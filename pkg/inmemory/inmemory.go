@@ -0,0 +1,223 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// NewTupleStoreAndChecker creates a new, empty, in-process tuple store that evaluates
+// check requests directly against the given authorization schema. It is meant for unit
+// tests, CI and offline tooling where a live OpenFGA server is not available, but it can
+// be used as an in-memory backend too, as long as the graph fits in memory.
+func NewTupleStoreAndChecker(as zanzibar.AuthorizationSchema) *TupleStoreAndChecker {
+	return &TupleStoreAndChecker{
+		storeID:  strconv.FormatUint(storeCounter.Add(1), 10),
+		as:       as,
+		ts:       newTypeSystem(as),
+		tuples:   sets.New[Tuple](),
+		byObject: map[objectRelation]sets.Set[Tuple]{},
+	}
+}
+
+type Tuple = zanzibar.Tuple
+
+var _ zanzibar.Checker = &TupleStoreAndChecker{}
+var _ zanzibar.TupleStore = &TupleStoreAndChecker{}
+var _ zanzibar.Expander = &TupleStoreAndChecker{}
+var _ zanzibar.Lister = &TupleStoreAndChecker{}
+var _ zanzibar.BatchChecker = &TupleStoreAndChecker{}
+var _ zanzibar.ConsistencyWaiter = &TupleStoreAndChecker{}
+
+// storeCounter gives every store in the process an unique ID, which is part of its consistency tokens
+var storeCounter atomic.Uint64
+
+type TupleStoreAndChecker struct {
+	storeID string
+	as      zanzibar.AuthorizationSchema
+	ts      typeSystem
+
+	// mu guards revision, tuples and byObject
+	mu sync.RWMutex
+	// revision is incremented for every write
+	revision uint64
+	tuples   sets.Set[Tuple]
+	// byObject indexes all tuples by their object node and relation, which is the
+	// lookup performed when resolving check requests
+	byObject map[objectRelation]sets.Set[Tuple]
+}
+
+// objectRelation is the key of the object index; the object type, name and relation
+type objectRelation [3]string
+
+func objectRelationFor(objectType, objectName, relation string) objectRelation {
+	return objectRelation{objectType, objectName, relation}
+}
+
+func (s *TupleStoreAndChecker) GetAuthorizationSchema(_ context.Context) (*zanzibar.AuthorizationSchema, error) {
+	return &s.as, nil
+}
+
+func (s *TupleStoreAndChecker) ReadTuples(_ context.Context, filter zanzibar.TupleFilter) ([]Tuple, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := zanzibar.Tuples{}
+	for tuple := range s.tuples {
+		if filter.Matches(tuple) {
+			result = append(result, tuple)
+		}
+	}
+	// make the output deterministic, as map iteration order is not
+	sort.Sort(result)
+	return result, nil
+}
+
+// WriteTuples applies all writes and deletes atomically. Just like OpenFGA, it is an
+// error to write a tuple that already exists, or delete a tuple that does not exist.
+// The errors of all invalid tuples are returned, as zanzibar.TupleErrors.
+func (s *TupleStoreAndChecker) WriteTuples(_ context.Context, writes, deletes []Tuple) (zanzibar.ConsistencyToken, error) {
+	errs := []error{}
+	for _, tuple := range append(append([]Tuple{}, writes...), deletes...) {
+		if !tuple.Valid() {
+			errs = append(errs, &zanzibar.TupleError{Tuple: tuple, Err: zanzibar.ErrInvalidTuple})
+		}
+	}
+	if len(errs) != 0 {
+		return "", errors.Join(errs...)
+	}
+	writes = util.Map(writes, normalizeTuple)
+	deletes = util.Map(deletes, normalizeTuple)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// validate everything before mutating the store, such that the write is all-or-nothing
+	seen := sets.New[Tuple]()
+	for _, tuple := range writes {
+		if err := s.ts.validateTuple(tuple); err != nil {
+			errs = append(errs, &zanzibar.TupleError{Tuple: tuple, Err: err})
+			continue
+		}
+		if s.tuples.Has(tuple) || seen.Has(tuple) {
+			errs = append(errs, &zanzibar.TupleError{Tuple: tuple, Err: fmt.Errorf("cannot write: %w: tuple already exists", zanzibar.ErrTupleConflict)})
+			continue
+		}
+		seen.Insert(tuple)
+	}
+	for _, tuple := range deletes {
+		if !s.tuples.Has(tuple) || seen.Has(tuple) {
+			errs = append(errs, &zanzibar.TupleError{Tuple: tuple, Err: fmt.Errorf("cannot delete: %w: tuple does not exist", zanzibar.ErrTupleConflict)})
+			continue
+		}
+		seen.Insert(tuple)
+	}
+	if len(errs) != 0 {
+		return "", errors.Join(errs...)
+	}
+
+	for _, tuple := range deletes {
+		s.tuples.Delete(tuple)
+		key := objectRelationFor(tuple.Object.NodeType(), tuple.Object.NodeName(), tuple.Relation)
+		s.byObject[key].Delete(tuple)
+		if s.byObject[key].Len() == 0 {
+			delete(s.byObject, key)
+		}
+	}
+	for _, tuple := range writes {
+		s.tuples.Insert(tuple)
+		key := objectRelationFor(tuple.Object.NodeType(), tuple.Object.NodeName(), tuple.Relation)
+		if _, ok := s.byObject[key]; !ok {
+			s.byObject[key] = sets.New[Tuple]()
+		}
+		s.byObject[key].Insert(tuple)
+	}
+	s.revision++
+	return s.consistencyToken(s.revision), nil
+}
+
+// WaitForConsistency returns directly for tokens issued by this store, as writes are visible to
+// all checks as soon as WriteTuples returns.
+func (s *TupleStoreAndChecker) WaitForConsistency(_ context.Context, token zanzibar.ConsistencyToken) error {
+	storeID, revision, ok := strings.Cut(string(token), "/")
+	if !ok || storeID != s.storeID {
+		return fmt.Errorf("%w: %q", zanzibar.ErrUnknownConsistencyToken, token)
+	}
+	rev, err := strconv.ParseUint(revision, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", zanzibar.ErrUnknownConsistencyToken, token)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if rev > s.revision {
+		return fmt.Errorf("%w: revision %d has not been written", zanzibar.ErrUnknownConsistencyToken, rev)
+	}
+	return nil
+}
+
+// consistencyToken is of the form "{storeID}/{revision}"
+func (s *TupleStoreAndChecker) consistencyToken(revision uint64) zanzibar.ConsistencyToken {
+	return zanzibar.ConsistencyToken(s.storeID + "/" + strconv.FormatUint(revision, 10))
+}
+
+func (s *TupleStoreAndChecker) CheckOne(ctx context.Context, tuple Tuple, contextualTuples []Tuple) (bool, error) {
+	r, err := newResolver(s.ts, tuple, contextualTuples)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r.stored = s.byObject
+
+	return r.check(ctx, normalizeNode(tuple.Object), tuple.Relation, normalizeNode(tuple.User), 0)
+}
+
+func (s *TupleStoreAndChecker) BatchCheck(ctx context.Context, requests []zanzibar.CheckRequest) <-chan zanzibar.CheckResult {
+	return zanzibar.ConcurrentBatchCheck(ctx, s, requests)
+}
+
+func (s *TupleStoreAndChecker) ExpandOne(ctx context.Context, tuple Tuple, contextualTuples []Tuple) (*zanzibar.UsersetTree, error) {
+	r, err := newResolver(s.ts, tuple, contextualTuples)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r.stored = s.byObject
+
+	return r.expand(ctx, normalizeNode(tuple.Object), tuple.Relation, normalizeNode(tuple.User), 0)
+}
+
+// normalizeTuple makes sure the user and object nodes are of the same underlying types as the ones
+// created by zanzibar.NewNode and WithUserSet, such that tuples can be compared and used as map keys.
+func normalizeTuple(t Tuple) Tuple {
+	return Tuple{
+		User:     normalizeNode(t.User),
+		Relation: t.Relation,
+		Object:   zanzibar.NewNode(t.Object.NodeType(), t.Object.NodeName()),
+	}
+}
+
+func normalizeNode(n zanzibar.Node) zanzibar.Node {
+	normalized := zanzibar.NewNode(n.NodeType(), n.NodeName())
+	if us, ok := zanzibar.ToUserSet(n); ok && len(us.UserSetRelation()) != 0 {
+		return normalized.WithUserSet(us.UserSetRelation())
+	}
+	return normalized
+}
@@ -0,0 +1,362 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/stretchr/testify/assert"
+)
+
+type Tuple = zanzibar.Tuple
+
+// writeTuples discards the consistency token, for brevity in the tests
+func writeTuples(ctx context.Context, s *inmemory.TupleStoreAndChecker, writes, deletes []Tuple) error {
+	_, err := s.WriteTuples(ctx, writes, deletes)
+	return err
+}
+
+// testSchema is a small document-sharing model exercising all kinds of rewrites
+func testSchema() zanzibar.AuthorizationSchema {
+	return zanzibar.AuthorizationSchema{
+		Types: []zanzibar.TypeRelation{
+			{
+				TypeName: "group",
+				Incoming: []zanzibar.IncomingRelation{
+					{UserType: "user", Relation: "members"},
+				},
+			},
+			{
+				TypeName: "folder",
+				Incoming: []zanzibar.IncomingRelation{
+					{UserType: "user", Relation: "owner"},
+					{UserType: "group", UserSetRelation: "members", Relation: "owner"},
+				},
+			},
+			{
+				TypeName: "document",
+				Incoming: []zanzibar.IncomingRelation{
+					{UserType: "folder", Relation: "parent"},
+					{UserType: "user", Relation: "editor"},
+					{UserType: "user", Relation: "blocked"},
+					{UserType: "user", Relation: "approved"},
+				},
+				EvaluatedUsersets: map[string]zanzibar.EvaluatedUserset{
+					// editors are the direct editors unioned with the owners of the parent folder
+					"editor": {
+						TupleToUserset: &zanzibar.TupleToUserset{
+							ReferencedRelation: "owner",
+							FromRelation:       "parent",
+						},
+					},
+					"viewer": {
+						Relation: "editor",
+					},
+					"publisher": {
+						Intersection: []zanzibar.EvaluatedUserset{
+							{Relation: "editor"},
+							{Relation: "approved"},
+						},
+					},
+					"writer": {
+						Difference: &zanzibar.DifferenceUserset{
+							Base:     zanzibar.EvaluatedUserset{Relation: "editor"},
+							Subtract: zanzibar.EvaluatedUserset{Relation: "blocked"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestCheckOne(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewTupleStoreAndChecker(testSchema())
+	assert.NoError(t, writeTuples(ctx, s, []Tuple{
+		zanzibar.NewTuple("user", "alice", "owner", "folder", "clients"),
+		zanzibar.NewUserSetTuple("group", "sales", "members", "owner", "folder", "clients"),
+		zanzibar.NewTuple("folder", "clients", "parent", "document", "customercase"),
+		zanzibar.NewTuple("user", "bob", "editor", "document", "customercase"),
+		zanzibar.NewTuple("user", "bob", "approved", "document", "customercase"),
+		zanzibar.NewTuple("user", "alice", "blocked", "document", "customercase"),
+	}, nil))
+
+	tests := []struct {
+		name             string
+		tuple            Tuple
+		contextualTuples []Tuple
+		want             bool
+		wantErr          error
+	}{
+		{
+			name:  "direct",
+			tuple: zanzibar.NewTuple("user", "bob", "editor", "document", "customercase"),
+			want:  true,
+		},
+		{
+			name:  "tuple to userset",
+			tuple: zanzibar.NewTuple("user", "alice", "editor", "document", "customercase"),
+			want:  true,
+		},
+		{
+			name:  "computed userset",
+			tuple: zanzibar.NewTuple("user", "alice", "viewer", "document", "customercase"),
+			want:  true,
+		},
+		{
+			name:  "userset member without contextual tuple",
+			tuple: zanzibar.NewTuple("user", "charlie", "viewer", "document", "customercase"),
+			want:  false,
+		},
+		{
+			name:  "userset member through contextual tuple",
+			tuple: zanzibar.NewTuple("user", "charlie", "viewer", "document", "customercase"),
+			contextualTuples: []Tuple{
+				zanzibar.NewTuple("user", "charlie", "members", "group", "sales"),
+			},
+			want: true,
+		},
+		{
+			name:  "intersection matches",
+			tuple: zanzibar.NewTuple("user", "bob", "publisher", "document", "customercase"),
+			want:  true,
+		},
+		{
+			name:  "intersection does not match",
+			tuple: zanzibar.NewTuple("user", "alice", "publisher", "document", "customercase"),
+			want:  false,
+		},
+		{
+			name:  "difference matches",
+			tuple: zanzibar.NewTuple("user", "bob", "writer", "document", "customercase"),
+			want:  true,
+		},
+		{
+			name:  "difference subtracts",
+			tuple: zanzibar.NewTuple("user", "alice", "writer", "document", "customercase"),
+			want:  false,
+		},
+		{
+			name:    "unknown relation",
+			tuple:   zanzibar.NewTuple("user", "alice", "notexist", "document", "customercase"),
+			wantErr: zanzibar.ErrUnknownRelation,
+		},
+		{
+			name:    "unknown type",
+			tuple:   zanzibar.NewTuple("user", "alice", "viewer", "notexist", "customercase"),
+			wantErr: zanzibar.ErrUnknownType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.CheckOne(ctx, tt.tuple, tt.contextualTuples)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWriteAndReadTuples(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewTupleStoreAndChecker(testSchema())
+
+	aliceOwner := zanzibar.NewTuple("user", "alice", "owner", "folder", "clients")
+	salesOwner := zanzibar.NewUserSetTuple("group", "sales", "members", "owner", "folder", "clients")
+	parent := zanzibar.NewTuple("folder", "clients", "parent", "document", "customercase")
+	assert.NoError(t, writeTuples(ctx, s, []Tuple{aliceOwner, salesOwner, parent}, nil))
+
+	// writing an existing tuple, or deleting a non-existent tuple, is a conflict
+	assert.ErrorIs(t, writeTuples(ctx, s, []Tuple{aliceOwner}, nil), zanzibar.ErrTupleConflict)
+	assert.ErrorIs(t, writeTuples(ctx, s, nil, []Tuple{zanzibar.NewTuple("user", "bob", "owner", "folder", "clients")}), zanzibar.ErrTupleConflict)
+	// writing a tuple not allowed by the schema is an error, and the errors of all invalid tuples are returned
+	invalid := zanzibar.NewTuple("group", "sales", "owner", "folder", "clients")
+	err := writeTuples(ctx, s, []Tuple{invalid, aliceOwner}, nil)
+	assert.ErrorIs(t, err, zanzibar.ErrInvalidTuple)
+	assert.ErrorIs(t, err, zanzibar.ErrTupleConflict)
+	var tupleErr *zanzibar.TupleError
+	if assert.ErrorAs(t, err, &tupleErr) {
+		assert.Equal(t, invalid, tupleErr.Tuple)
+	}
+
+	tests := []struct {
+		name    string
+		filter  zanzibar.TupleFilter
+		want    zanzibar.Tuples
+		wantErr bool
+	}{
+		{
+			name:   "all tuples",
+			filter: zanzibar.TupleFilter{},
+			want:   []Tuple{aliceOwner, salesOwner, parent},
+		},
+		{
+			name:   "object",
+			filter: zanzibar.TupleFilter{ObjectType: "folder", ObjectName: "clients"},
+			want:   []Tuple{aliceOwner, salesOwner},
+		},
+		{
+			name:   "user type only",
+			filter: zanzibar.TupleFilter{UserType: "group"},
+			want:   []Tuple{salesOwner},
+		},
+		{
+			name:   "user without userset does not match usersets",
+			filter: zanzibar.TupleFilter{UserType: "group", UserName: "sales"},
+			want:   []Tuple{},
+		},
+		{
+			name:   "userset wildcard",
+			filter: zanzibar.TupleFilter{UserType: "group", UserName: "sales", UserSetRelation: zanzibar.TupleFilterWildcardUserSetRelation},
+			want:   []Tuple{salesOwner},
+		},
+		{
+			name:   "relation",
+			filter: zanzibar.TupleFilter{Relation: "parent"},
+			want:   []Tuple{parent},
+		},
+		{
+			name:   "multiple relations",
+			filter: zanzibar.TupleFilter{Relations: []string{"parent", "owner"}, UserType: "user"},
+			want:   []Tuple{aliceOwner},
+		},
+		{
+			name:   "object type only",
+			filter: zanzibar.TupleFilter{ObjectType: "document"},
+			want:   []Tuple{parent},
+		},
+		{
+			name:    "invalid filter",
+			filter:  zanzibar.TupleFilter{ObjectName: "clients"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ReadTuples(ctx, tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TupleStoreAndChecker.ReadTuples() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			zanzibar.Tuples(got).AssertEqualsWanted(tt.want, t, "TupleStoreAndChecker.ReadTuples")
+		})
+	}
+
+	assert.NoError(t, writeTuples(ctx, s, nil, []Tuple{aliceOwner}))
+	allowed, err := s.CheckOne(ctx, zanzibar.NewTuple("user", "alice", "owner", "folder", "clients"), nil)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestExpandOne(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewTupleStoreAndChecker(testSchema())
+	assert.NoError(t, writeTuples(ctx, s, []Tuple{
+		zanzibar.NewTuple("user", "alice", "owner", "folder", "clients"),
+		zanzibar.NewUserSetTuple("group", "sales", "members", "owner", "folder", "clients"),
+		zanzibar.NewTuple("folder", "clients", "parent", "document", "customercase"),
+		zanzibar.NewTuple("user", "bob", "editor", "document", "customercase"),
+		zanzibar.NewTuple("user", "bob", "approved", "document", "customercase"),
+		zanzibar.NewTuple("user", "alice", "blocked", "document", "customercase"),
+	}, nil))
+
+	tests := []struct {
+		name             string
+		tuple            Tuple
+		contextualTuples []Tuple
+		want             string
+	}{
+		{
+			name:  "direct",
+			tuple: zanzibar.NewTuple("user", "bob", "editor", "document", "customercase"),
+			want:  "document:customercase#editor Direct [user:bob]\n",
+		},
+		{
+			name:  "userset member through contextual tuple",
+			tuple: zanzibar.NewTuple("user", "charlie", "viewer", "document", "customercase"),
+			contextualTuples: []Tuple{
+				zanzibar.NewTuple("user", "charlie", "members", "group", "sales"),
+			},
+			want: "document:customercase#viewer Computed\n" +
+				"  document:customercase#editor TupleToUserset from parent\n" +
+				"    folder:clients#owner Direct [group:sales#members]\n" +
+				"      group:sales#members Direct [user:charlie]\n",
+		},
+		{
+			name:  "intersection",
+			tuple: zanzibar.NewTuple("user", "bob", "publisher", "document", "customercase"),
+			want: "document:customercase#publisher Intersection\n" +
+				"  document:customercase#publisher Computed\n" +
+				"    document:customercase#editor Direct [user:bob]\n" +
+				"  document:customercase#publisher Computed\n" +
+				"    document:customercase#approved Direct [user:bob]\n",
+		},
+		{
+			name:  "difference subtracts",
+			tuple: zanzibar.NewTuple("user", "alice", "writer", "document", "customercase"),
+			want:  "<not related>",
+		},
+		{
+			name:  "not related",
+			tuple: zanzibar.NewTuple("user", "charlie", "viewer", "document", "customercase"),
+			want:  "<not related>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ExpandOne(ctx, tt.tuple, tt.contextualTuples)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func TestListObjectsAndUsers(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewTupleStoreAndChecker(testSchema())
+	assert.NoError(t, writeTuples(ctx, s, []Tuple{
+		zanzibar.NewTuple("user", "alice", "owner", "folder", "clients"),
+		zanzibar.NewUserSetTuple("group", "sales", "members", "owner", "folder", "clients"),
+		zanzibar.NewTuple("folder", "clients", "parent", "document", "customercase"),
+		zanzibar.NewTuple("user", "bob", "editor", "document", "customercase"),
+		zanzibar.NewTuple("user", "bob", "editor", "document", "roadmap"),
+		zanzibar.NewTuple("user", "alice", "blocked", "document", "customercase"),
+	}, nil))
+
+	objects, err := s.ListObjects(ctx, zanzibar.NewNode("user", "bob"), "viewer", "document", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []zanzibar.Node{zanzibar.NewNode("document", "customercase"), zanzibar.NewNode("document", "roadmap")}, objects)
+
+	objects, err = s.ListObjects(ctx, zanzibar.NewNode("user", "charlie"), "viewer", "document", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []zanzibar.Node{}, objects)
+
+	// contextual tuples are taken into account, also for finding candidates
+	objects, err = s.ListObjects(ctx, zanzibar.NewNode("user", "charlie"), "viewer", "document", []Tuple{
+		zanzibar.NewTuple("user", "charlie", "members", "group", "sales"),
+		zanzibar.NewTuple("folder", "clients", "parent", "document", "contextual"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []zanzibar.Node{zanzibar.NewNode("document", "contextual"), zanzibar.NewNode("document", "customercase")}, objects)
+
+	users, err := s.ListUsers(ctx, zanzibar.NewNode("document", "customercase"), "writer", "user", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []zanzibar.Node{zanzibar.NewNode("user", "bob")}, users)
+
+	users, err = s.ListUsers(ctx, zanzibar.NewNode("document", "customercase"), "viewer", "user", []Tuple{
+		zanzibar.NewTuple("user", "charlie", "members", "group", "sales"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []zanzibar.Node{zanzibar.NewNode("user", "alice"), zanzibar.NewNode("user", "bob"), zanzibar.NewNode("user", "charlie")}, users)
+
+	_, err = s.ListObjects(ctx, zanzibar.NewNode("user", "bob"), "notexist", "document", nil)
+	assert.Error(t, err)
+}
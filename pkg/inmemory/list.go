@@ -0,0 +1,66 @@
+package inmemory
+
+import (
+	"context"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ListObjects checks all objects of objectType found in the store or the contextual tuples.
+func (s *TupleStoreAndChecker) ListObjects(ctx context.Context, user zanzibar.Node, relation, objectType string, contextualTuples []Tuple) ([]zanzibar.Node, error) {
+	if _, err := s.ts.getRelation(objectType, relation); err != nil {
+		return nil, err
+	}
+	return s.list(ctx, objectType, contextualTuples, func(candidate zanzibar.Node) Tuple {
+		return Tuple{User: user, Relation: relation, Object: candidate}
+	})
+}
+
+// ListUsers checks all users of userType found in the store or the contextual tuples.
+func (s *TupleStoreAndChecker) ListUsers(ctx context.Context, object zanzibar.Node, relation, userType string, contextualTuples []Tuple) ([]zanzibar.Node, error) {
+	if _, err := s.ts.getRelation(object.NodeType(), relation); err != nil {
+		return nil, err
+	}
+	return s.list(ctx, userType, contextualTuples, func(candidate zanzibar.Node) Tuple {
+		return Tuple{User: candidate, Relation: relation, Object: object}
+	})
+}
+
+// list returns all nodes of nodeType for which the tuple returned by tupleFor can be checked
+func (s *TupleStoreAndChecker) list(ctx context.Context, nodeType string, contextualTuples []Tuple, tupleFor func(candidate zanzibar.Node) Tuple) ([]zanzibar.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candidates := sets.New[zanzibar.Node]()
+	for _, t := range append(s.tuples.UnsortedList(), contextualTuples...) {
+		if !t.Valid() {
+			continue // invalid contextual tuples are reported by newResolver
+		}
+		for _, n := range []zanzibar.Node{t.User, t.Object} {
+			if n.NodeType() == nodeType {
+				candidates.Insert(zanzibar.NewNode(n.NodeType(), n.NodeName()))
+			}
+		}
+	}
+
+	result := []zanzibar.Node{}
+	for candidate := range candidates {
+		tuple := tupleFor(candidate)
+		r, err := newResolver(s.ts, tuple, contextualTuples)
+		if err != nil {
+			return nil, err
+		}
+		r.stored = s.byObject
+
+		allowed, err := r.check(ctx, normalizeNode(tuple.Object), tuple.Relation, normalizeNode(tuple.User), 0)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			result = append(result, candidate)
+		}
+	}
+	zanzibar.SortNodes(result)
+	return result, nil
+}
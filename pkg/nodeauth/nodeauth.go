@@ -2,7 +2,6 @@ package nodeauth
 
 import (
 	"fmt"
-	"net/url"
 	"strings"
 
 	"github.com/luxas/kube-rebac-authorizer/pkg/util"
@@ -19,14 +18,12 @@ func GVKToTypeName(gvk schema.GroupVersionKind) string {
 	return fmt.Sprintf("%s.%s", groupName, strings.ToLower(gvk.Kind))
 }
 
-func GenericNodeID(namespace, name string) string {
-	escapedName := url.QueryEscape(name)
-	if len(namespace) == 0 {
-		return escapedName
-	}
-
-	return url.QueryEscape(namespace) + "/" + escapedName
-}
+// The codecs of the node IDs of the types, see zanzibar.NodeIDCodec
+var (
+	nodeIDs   = zanzibar.NewNodeIDCodec("core.node")
+	podIDs    = zanzibar.NewNodeIDCodec("core.pod")
+	secretIDs = zanzibar.NewNodeIDCodec("core.secret")
+)
 
 func GetSchema() *zanzibar.AuthorizationSchema {
 	return &zanzibar.AuthorizationSchema{
@@ -36,9 +33,9 @@ func GetSchema() *zanzibar.AuthorizationSchema {
 				IDExpr: zanzibar.CastIDExpr(func(n *v1.Node) (string, error) {
 					// TODO: This make this generic as it probably needs to be the same for every object; find if the type is namespaced or not from discovery
 					// TODO: Try casting to client.Object again
-					return GenericNodeID("", n.Name), nil
+					return n.Name, nil
 				}),
-				EscapeID: false, // TODO: does this hold?
+				IDCodec: nodeIDs,
 				Incoming: []zanzibar.IncomingRelation{
 					/*{
 						UserType:        "resourceinstance",
@@ -52,24 +49,26 @@ func GetSchema() *zanzibar.AuthorizationSchema {
 						UserType: "user",
 						Relation: "get",
 						UserIDExpr: zanzibar.CastIncoming(func(n *v1.Node) ([]string, error) {
-							return []string{url.QueryEscape("system:node:" + n.Name)}, nil
+							return []string{"system:node:" + n.Name}, nil
 						}),
+						EscapeID: true,
 					},
 				},
 			},
 			{
 				TypeName: "core.pod",
 				IDExpr: zanzibar.CastIDExpr(func(p *v1.Pod) (string, error) {
-					return GenericNodeID(p.Namespace, p.Name), nil
+					return p.Namespace + "/" + p.Name, nil
 				}),
-				EscapeID: false, // TODO: does this hold?
+				IDCodec: podIDs,
 				Incoming: []zanzibar.IncomingRelation{
 					{
 						UserType: "core.node",
 						Relation: "node_to_pod",
 						UserIDExpr: zanzibar.CastIncoming(func(p *v1.Pod) ([]string, error) {
-							return []string{GenericNodeID("", p.Spec.NodeName)}, nil // TODO: Escaping?
+							return []string{p.Spec.NodeName}, nil
 						}),
+						EscapeID: true,
 					},
 				},
 				Outgoing: []zanzibar.OutgoingRelation{
@@ -80,7 +79,7 @@ func GetSchema() *zanzibar.AuthorizationSchema {
 							return util.FlatMap(p.Spec.Containers, func(c v1.Container) []string {
 								return util.FlatMap(c.EnvFrom, func(env v1.EnvFromSource) []string {
 									if env.SecretRef != nil {
-										return []string{GenericNodeID(p.Namespace, env.SecretRef.Name)}
+										return []string{secretIDs.Encode(p.Namespace, env.SecretRef.Name)}
 									}
 									return nil
 								})
@@ -99,6 +98,7 @@ func GetSchema() *zanzibar.AuthorizationSchema {
 			},
 			{
 				TypeName: "core.secret",
+				IDCodec:  secretIDs,
 				EvaluatedUsersets: map[string]zanzibar.EvaluatedUserset{
 					"get": {
 						TupleToUserset: &zanzibar.TupleToUserset{
@@ -0,0 +1,181 @@
+package openfga
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ModelDiff is the semantic difference between two authorization models.
+type ModelDiff struct {
+	AddedTypes   []string
+	RemovedTypes []string
+	// Relations contains all added, removed and changed relations, sorted by type and relation.
+	// The relations of added and removed types are included.
+	Relations []RelationDiff
+}
+
+type RelationDiffKind string
+
+const (
+	RelationAdded   RelationDiffKind = "Added"
+	RelationRemoved RelationDiffKind = "Removed"
+	RelationChanged RelationDiffKind = "Changed"
+)
+
+// RelationDiff describes how one relation of a type differs.
+type RelationDiff struct {
+	Type     string
+	Relation string
+	Kind     RelationDiffKind
+	// Old and New are the definitions of the relation in a DSL-like format, where the
+	// directly related user types and all set operations are sorted, e.g.
+	// "[group#members, user] or (editor and approved)". Old is empty for added
+	// relations, New is empty for removed relations.
+	Old string
+	New string
+}
+
+// Empty returns true if the models are semantically equal.
+func (d *ModelDiff) Empty() bool {
+	return len(d.AddedTypes) == 0 && len(d.RemovedTypes) == 0 && len(d.Relations) == 0
+}
+
+// String renders the diff in a human-readable format, e.g. for code review.
+func (d *ModelDiff) String() string {
+	if d.Empty() {
+		return "no changes\n"
+	}
+	sb := &strings.Builder{}
+	for _, t := range d.AddedTypes {
+		fmt.Fprintf(sb, "+ type %s\n", t)
+	}
+	for _, t := range d.RemovedTypes {
+		fmt.Fprintf(sb, "- type %s\n", t)
+	}
+	for _, r := range d.Relations {
+		switch r.Kind {
+		case RelationAdded:
+			fmt.Fprintf(sb, "+ %s#%s: %s\n", r.Type, r.Relation, r.New)
+		case RelationRemoved:
+			fmt.Fprintf(sb, "- %s#%s: %s\n", r.Type, r.Relation, r.Old)
+		case RelationChanged:
+			fmt.Fprintf(sb, "~ %s#%s: %s => %s\n", r.Type, r.Relation, r.Old, r.New)
+		}
+	}
+	return sb.String()
+}
+
+// DiffAuthorizationSchemas compares the OpenFGA models built from the two schemas.
+func DiffAuthorizationSchemas(oldSchema, newSchema zanzibar.AuthorizationSchema) *ModelDiff {
+	return DiffAuthorizationModels(BuildAuthorizationModel(oldSchema), BuildAuthorizationModel(newSchema))
+}
+
+// DiffAuthorizationModels reports the added, removed and changed types and relations between
+// the two models. Relations are compared semantically, that is, the order of directly related
+// user types and of the children of unions and intersections does not matter.
+func DiffAuthorizationModels(oldModel, newModel *openfgav1.AuthorizationModel) *ModelDiff {
+	oldRelations := modelRelations(oldModel)
+	newRelations := modelRelations(newModel)
+
+	d := &ModelDiff{}
+	oldTypes := sets.KeySet(oldRelations)
+	newTypes := sets.KeySet(newRelations)
+	if added := newTypes.Difference(oldTypes); added.Len() != 0 {
+		d.AddedTypes = sets.List(added)
+	}
+	if removed := oldTypes.Difference(newTypes); removed.Len() != 0 {
+		d.RemovedTypes = sets.List(removed)
+	}
+
+	for _, typeName := range sets.List(oldTypes.Union(newTypes)) {
+		oldDefs, newDefs := oldRelations[typeName], newRelations[typeName]
+		for _, relation := range sets.List(sets.KeySet(oldDefs).Union(sets.KeySet(newDefs))) {
+			oldDef, inOld := oldDefs[relation]
+			newDef, inNew := newDefs[relation]
+			rd := RelationDiff{Type: typeName, Relation: relation, Old: oldDef, New: newDef}
+			switch {
+			case !inOld:
+				rd.Kind = RelationAdded
+			case !inNew:
+				rd.Kind = RelationRemoved
+			case oldDef != newDef:
+				rd.Kind = RelationChanged
+			default:
+				continue
+			}
+			d.Relations = append(d.Relations, rd)
+		}
+	}
+	return d
+}
+
+// modelRelations returns the canonical definition of every relation, indexed by type and relation
+func modelRelations(model *openfgav1.AuthorizationModel) map[string]map[string]string {
+	result := map[string]map[string]string{}
+	for _, td := range model.GetTypeDefinitions() {
+		relations := map[string]string{}
+		for relation, userset := range td.GetRelations() {
+			directlyRelated := []string{}
+			if meta, ok := td.GetMetadata().GetRelations()[relation]; ok {
+				for _, rr := range meta.GetDirectlyRelatedUserTypes() {
+					directlyRelated = append(directlyRelated, relationReferenceString(rr))
+				}
+			}
+			sort.Strings(directlyRelated)
+			relations[relation] = usersetString(userset, directlyRelated, true)
+		}
+		result[td.GetType()] = relations
+	}
+	return result
+}
+
+func relationReferenceString(rr *openfgav1.RelationReference) string {
+	switch {
+	case len(rr.GetRelation()) != 0:
+		return rr.GetType() + "#" + rr.GetRelation()
+	case rr.GetWildcard() != nil:
+		return rr.GetType() + ":*"
+	}
+	return rr.GetType()
+}
+
+func usersetString(us *openfgav1.Userset, directlyRelated []string, topLevel bool) string {
+	childStrings := func(children []*openfgav1.Userset, operator string) string {
+		strs := make([]string, 0, len(children))
+		for _, child := range children {
+			strs = append(strs, usersetString(child, directlyRelated, false))
+		}
+		sort.Strings(strs)
+		str := strings.Join(strs, " "+operator+" ")
+		if topLevel {
+			return str
+		}
+		return "(" + str + ")"
+	}
+
+	switch u := us.GetUserset().(type) {
+	case *openfgav1.Userset_This:
+		return "[" + strings.Join(directlyRelated, ", ") + "]"
+	case *openfgav1.Userset_ComputedUserset:
+		return u.ComputedUserset.GetRelation()
+	case *openfgav1.Userset_TupleToUserset:
+		return u.TupleToUserset.GetComputedUserset().GetRelation() + " from " + u.TupleToUserset.GetTupleset().GetRelation()
+	case *openfgav1.Userset_Union:
+		return childStrings(u.Union.GetChild(), "or")
+	case *openfgav1.Userset_Intersection:
+		return childStrings(u.Intersection.GetChild(), "and")
+	case *openfgav1.Userset_Difference:
+		str := usersetString(u.Difference.GetBase(), directlyRelated, false) + " but not " +
+			usersetString(u.Difference.GetSubtract(), directlyRelated, false)
+		if topLevel {
+			return str
+		}
+		return "(" + str + ")"
+	}
+	return "<empty>"
+}
@@ -0,0 +1,96 @@
+package openfga
+
+import (
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/nodeauth"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffAuthorizationSchemas(t *testing.T) {
+	fullSchema := func() zanzibar.AuthorizationSchema {
+		as := rbacconversion.GetSchema()
+		as.Types = append(as.Types, nodeauth.GetSchema().Types...)
+		return as
+	}
+	document := func(viewer zanzibar.EvaluatedUserset) zanzibar.AuthorizationSchema {
+		return zanzibar.AuthorizationSchema{
+			Types: []zanzibar.TypeRelation{{
+				TypeName: "document",
+				Incoming: []zanzibar.IncomingRelation{
+					{UserType: "user", Relation: "editor"},
+					{UserType: "user", Relation: "owner"},
+					{UserType: "group", UserSetRelation: "members", Relation: "editor"},
+				},
+				EvaluatedUsersets: map[string]zanzibar.EvaluatedUserset{
+					"viewer": viewer,
+				},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		oldSchema zanzibar.AuthorizationSchema
+		newSchema zanzibar.AuthorizationSchema
+		want      *ModelDiff
+	}{
+		{
+			name:      "equal",
+			oldSchema: fullSchema(),
+			newSchema: fullSchema(),
+			want:      &ModelDiff{},
+		},
+		{
+			name:      "union children reordered",
+			oldSchema: document(zanzibar.EvaluatedUserset{Union: []zanzibar.EvaluatedUserset{{Relation: "editor"}, {Relation: "owner"}}}),
+			newSchema: document(zanzibar.EvaluatedUserset{Union: []zanzibar.EvaluatedUserset{{Relation: "owner"}, {Relation: "editor"}}}),
+			want:      &ModelDiff{},
+		},
+		{
+			name:      "rewrite changed",
+			oldSchema: document(zanzibar.EvaluatedUserset{Relation: "editor"}),
+			newSchema: document(zanzibar.EvaluatedUserset{Union: []zanzibar.EvaluatedUserset{{Relation: "owner"}, {Relation: "editor"}}}),
+			want: &ModelDiff{
+				Relations: []RelationDiff{
+					{Type: "document", Relation: "viewer", Kind: RelationChanged, Old: "editor", New: "editor or owner"},
+				},
+			},
+		},
+		{
+			name:      "type without relations added",
+			oldSchema: document(zanzibar.EvaluatedUserset{Relation: "editor"}),
+			newSchema: func() zanzibar.AuthorizationSchema {
+				as := document(zanzibar.EvaluatedUserset{Relation: "editor"})
+				as.Types = append(as.Types, zanzibar.TypeRelation{TypeName: "folder"})
+				return as
+			}(),
+			want: &ModelDiff{AddedTypes: []string{"folder"}},
+		},
+		{
+			name:      "type and relations removed",
+			oldSchema: document(zanzibar.EvaluatedUserset{Relation: "editor"}),
+			newSchema: zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{{
+				TypeName: "document",
+				Incoming: []zanzibar.IncomingRelation{{UserType: "user", Relation: "editor"}},
+			}}},
+			want: &ModelDiff{
+				RemovedTypes: []string{"group"},
+				Relations: []RelationDiff{
+					{Type: "document", Relation: "editor", Kind: RelationChanged, Old: "[group#members, user]", New: "[user]"},
+					{Type: "document", Relation: "owner", Kind: RelationRemoved, Old: "[user]"},
+					{Type: "document", Relation: "viewer", Kind: RelationRemoved, Old: "editor"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffAuthorizationSchemas(tt.oldSchema, tt.newSchema)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, len(tt.want.Relations) == 0 && len(tt.want.AddedTypes) == 0 && len(tt.want.RemovedTypes) == 0, got.Empty())
+		})
+	}
+}
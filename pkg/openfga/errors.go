@@ -0,0 +1,68 @@
+package openfga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// openFGAErrors maps the gRPC status codes OpenFGA (and gRPC itself) returns to the errors of the
+// zanzibar package. OpenFGA encodes its own error codes, e.g. ErrorCode_type_not_found, directly as
+// the status code.
+var openFGAErrors = map[codes.Code]error{
+	codes.Unavailable:       zanzibar.ErrBackendUnavailable,
+	codes.DeadlineExceeded:  zanzibar.ErrBackendUnavailable,
+	codes.ResourceExhausted: zanzibar.ErrBackendUnavailable,
+	codes.Aborted:           zanzibar.ErrBackendUnavailable,
+
+	codes.Code(openfgav1.InternalErrorCode_unavailable):        zanzibar.ErrBackendUnavailable,
+	codes.Code(openfgav1.InternalErrorCode_deadline_exceeded):  zanzibar.ErrBackendUnavailable,
+	codes.Code(openfgav1.InternalErrorCode_resource_exhausted): zanzibar.ErrBackendUnavailable,
+	codes.Code(openfgav1.InternalErrorCode_aborted):            zanzibar.ErrBackendUnavailable,
+
+	codes.Code(openfgav1.ErrorCode_type_not_found):     zanzibar.ErrUnknownType,
+	codes.Code(openfgav1.ErrorCode_relation_not_found): zanzibar.ErrUnknownRelation,
+	codes.Code(openfgav1.ErrorCode_unknown_relation):   zanzibar.ErrUnknownRelation,
+
+	codes.Code(openfgav1.ErrorCode_invalid_tuple):                                zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_invalid_user):                                 zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_invalid_object_format):                        zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_object_invalid_pattern):                       zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_object_too_long):                              zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_relation_too_long):                            zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_tuple_key_value_not_specified):                zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_invalid_contextual_tuple):                     zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_duplicate_contextual_tuple):                   zanzibar.ErrInvalidTuple,
+	codes.Code(openfgav1.ErrorCode_cannot_allow_duplicate_tuples_in_one_request): zanzibar.ErrInvalidTuple,
+	// returned when writing a tuple that already exists, or deleting one that does not exist, as the
+	// tuples are validated before writing, see validateTuples
+	codes.Code(openfgav1.ErrorCode_write_failed_due_to_invalid_input): zanzibar.ErrTupleConflict,
+}
+
+// convertError wraps the error returned by the OpenFGA client with the matching error of the
+// zanzibar package, if any. The original error stays wrapped, for its status details.
+func convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	// the context of the caller expired, the backend did not necessarily fail
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", zanzibar.ErrBackendUnavailable, err)
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if sentinel, ok := openFGAErrors[st.Code()]; ok {
+		return fmt.Errorf("%w: %w", sentinel, err)
+	}
+	return err
+}
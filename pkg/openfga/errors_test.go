@@ -0,0 +1,69 @@
+package openfga
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConvertError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name: "connection refused",
+			err:  status.Error(codes.Unavailable, "connection refused"),
+			want: zanzibar.ErrBackendUnavailable,
+		},
+		{
+			name: "deadline of the caller",
+			err:  context.DeadlineExceeded,
+			want: zanzibar.ErrBackendUnavailable,
+		},
+		{
+			name: "type not found",
+			err:  status.Error(codes.Code(openfgav1.ErrorCode_type_not_found), "type 'foo' not found"),
+			want: zanzibar.ErrUnknownType,
+		},
+		{
+			name: "relation not found",
+			err:  status.Error(codes.Code(openfgav1.ErrorCode_relation_not_found), "relation 'foo#bar' not found"),
+			want: zanzibar.ErrUnknownRelation,
+		},
+		{
+			name: "tuple already exists",
+			err:  status.Error(codes.Code(openfgav1.ErrorCode_write_failed_due_to_invalid_input), "cannot write a tuple which already exists"),
+			want: zanzibar.ErrTupleConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertError(tt.err)
+			if tt.err == nil {
+				assert.NoError(t, got)
+				return
+			}
+			assert.ErrorIs(t, got, tt.want)
+			// the original error is kept
+			assert.ErrorIs(t, got, tt.err)
+		})
+	}
+
+	// a canceled context and unknown errors are returned as-is
+	assert.Equal(t, context.Canceled, convertError(context.Canceled))
+	unknown := errors.New("unknown")
+	assert.Equal(t, unknown, convertError(unknown))
+	assert.False(t, zanzibar.IsPermanent(convertError(status.Error(codes.Unavailable, ""))))
+	assert.True(t, zanzibar.IsPermanent(convertError(status.Error(codes.Code(openfgav1.ErrorCode_invalid_tuple), ""))))
+}
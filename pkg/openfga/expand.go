@@ -0,0 +1,284 @@
+package openfga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/openfga/openfga/pkg/tuple"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// maxExpandDepth mirrors the default ResolveNodeLimit of OpenFGA
+const maxExpandDepth = 25
+
+var errExpandDepthExceeded = errors.New("expand depth exceeded")
+
+// ExpandOne first checks whether the user is related to the object at all, and if so, uses
+// the Expand API recursively to build the resolution tree. The OpenFGA Expand API does not
+// support contextual tuples, so they are merged into the tree client-side, and every userset
+// in the tree is pruned using check requests (that do support contextual tuples), such that
+// only the branches leading to the user are kept.
+func (o *TupleStoreAndChecker) ExpandOne(ctx context.Context, tuple Tuple, contextualTuples []Tuple) (*zanzibar.UsersetTree, error) {
+	resp, err := o.check(ctx, tuple, contextualTuples)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Allowed {
+		return nil, nil
+	}
+
+	e := &expander{
+		o:                o,
+		user:             tuple.User,
+		userString:       tupleToOpenFGA(tuple).User,
+		contextualTuples: contextualTuples,
+	}
+	tree, err := e.expand(ctx, tuple.Object, tuple.Relation, 0)
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		return nil, fmt.Errorf("user %s is related to %s#%s, but no resolution tree could be found",
+			e.userString, nodeString(tuple.Object.NodeType(), tuple.Object.NodeName()), tuple.Relation)
+	}
+	tree.Resolution = resp.Resolution
+	return tree, nil
+}
+
+// expander builds the resolution tree for one user
+type expander struct {
+	o                *TupleStoreAndChecker
+	user             zanzibar.Node
+	userString       string
+	contextualTuples []Tuple
+}
+
+// expandIfRelated expands object#relation only if the user is related to it
+func (e *expander) expandIfRelated(ctx context.Context, object zanzibar.Node, relation string, depth int) (*zanzibar.UsersetTree, error) {
+	resp, err := e.o.check(ctx, Tuple{User: e.user, Relation: relation, Object: object}, e.contextualTuples)
+	if err != nil || !resp.Allowed {
+		return nil, err
+	}
+	return e.expand(ctx, object, relation, depth)
+}
+
+func (e *expander) expand(ctx context.Context, object zanzibar.Node, relation string, depth int) (*zanzibar.UsersetTree, error) {
+	if depth >= maxExpandDepth {
+		return nil, errExpandDepthExceeded
+	}
+	resp, err := e.o.fgaClient.Expand(ctx, &openfgav1.ExpandRequest{
+		StoreId:              e.o.storeID,
+		AuthorizationModelId: e.o.authzModel.Id,
+		TupleKey: &openfgav1.TupleKey{
+			Relation: relation,
+			Object:   nodeString(object.NodeType(), object.NodeName()),
+		},
+	})
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return e.convert(ctx, resp.GetTree().GetRoot(), depth)
+}
+
+// convert converts an OpenFGA expand node to a pruned UsersetTree, or nil, if the user
+// cannot be found in the node
+func (e *expander) convert(ctx context.Context, node *openfgav1.UsersetTree_Node, depth int) (*zanzibar.UsersetTree, error) {
+	object, relation, err := parseUserset(node.GetName())
+	if err != nil {
+		return nil, err
+	}
+	tree := &zanzibar.UsersetTree{
+		Object:   object,
+		Relation: relation,
+	}
+
+	switch n := node.GetValue().(type) {
+	case *openfgav1.UsersetTree_Node_Leaf:
+		switch leaf := n.Leaf.GetValue().(type) {
+		case *openfgav1.UsersetTree_Leaf_Users:
+			tree.Kind = zanzibar.UsersetTreeDirect
+			return e.convertUsers(ctx, tree, leaf.Users.GetUsers(), depth)
+
+		case *openfgav1.UsersetTree_Leaf_Computed:
+			tree.Kind = zanzibar.UsersetTreeComputed
+			computedObject, computedRelation, err := parseUserset(leaf.Computed.GetUserset())
+			if err != nil {
+				return nil, err
+			}
+			child, err := e.expandIfRelated(ctx, computedObject, computedRelation, depth+1)
+			if err != nil || child == nil {
+				return nil, err
+			}
+			tree.Children = []*zanzibar.UsersetTree{child}
+			return tree, nil
+
+		case *openfgav1.UsersetTree_Leaf_TupleToUserset:
+			tree.Kind = zanzibar.UsersetTreeTupleToUserset
+			_, tupleset, err := parseUserset(leaf.TupleToUserset.GetTupleset())
+			if err != nil {
+				return nil, err
+			}
+			tree.Tupleset = tupleset
+			return e.convertTupleToUserset(ctx, tree, leaf.TupleToUserset.GetComputed(), depth)
+		}
+
+	case *openfgav1.UsersetTree_Node_Union:
+		tree.Kind = zanzibar.UsersetTreeUnion
+		for _, childNode := range n.Union.GetNodes() {
+			child, err := e.convert(ctx, childNode, depth)
+			if err != nil {
+				return nil, err
+			}
+			if child != nil {
+				tree.Children = append(tree.Children, child)
+			}
+		}
+		if len(tree.Children) == 0 {
+			return nil, nil
+		}
+		return tree, nil
+
+	case *openfgav1.UsersetTree_Node_Intersection:
+		tree.Kind = zanzibar.UsersetTreeIntersection
+		for _, childNode := range n.Intersection.GetNodes() {
+			child, err := e.convert(ctx, childNode, depth)
+			if err != nil || child == nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, child)
+		}
+		return tree, nil
+
+	case *openfgav1.UsersetTree_Node_Difference:
+		tree.Kind = zanzibar.UsersetTreeDifference
+		base, err := e.convert(ctx, n.Difference.GetBase(), depth)
+		if err != nil || base == nil {
+			return nil, err
+		}
+		subtracted, err := e.convert(ctx, n.Difference.GetSubtract(), depth)
+		if err != nil || subtracted != nil {
+			return nil, err
+		}
+		tree.Children = []*zanzibar.UsersetTree{base}
+		return tree, nil
+	}
+
+	return nil, fmt.Errorf("unknown expand node %q", node.GetName())
+}
+
+func (e *expander) convertUsers(ctx context.Context, tree *zanzibar.UsersetTree, storedUsers []string, depth int) (*zanzibar.UsersetTree, error) {
+	users := sets.New(storedUsers...)
+	for _, ct := range e.contextualTuples {
+		if ct.Relation == tree.Relation && ct.Object.NodeType() == tree.Object.NodeType() && ct.Object.NodeName() == tree.Object.NodeName() {
+			users.Insert(tupleToOpenFGA(ct).User)
+		}
+	}
+
+	for _, user := range sets.List(users) {
+		userObject, userSetRelation := tuple.SplitObjectRelation(user)
+		userType, userName := tuple.SplitObject(userObject)
+
+		if len(userSetRelation) == 0 {
+			// the user itself, or a wildcard covering the user
+			if user == e.userString || (userName == "*" && userType == e.user.NodeType() && !zanzibar.IsUserSet(e.user)) {
+				tree.Users = append(tree.Users, zanzibar.NewNode(userType, userName))
+			}
+			continue
+		}
+
+		if user == e.userString {
+			tree.Users = append(tree.Users, zanzibar.NewNode(userType, userName).WithUserSet(userSetRelation))
+			continue
+		}
+		child, err := e.expandIfRelated(ctx, zanzibar.NewNode(userType, userName), userSetRelation, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			tree.Users = append(tree.Users, zanzibar.NewNode(userType, userName).WithUserSet(userSetRelation))
+			tree.Children = append(tree.Children, child)
+		}
+	}
+	if len(tree.Users) == 0 {
+		return nil, nil
+	}
+	return tree, nil
+}
+
+func (e *expander) convertTupleToUserset(ctx context.Context, tree *zanzibar.UsersetTree, computed []*openfgav1.UsersetTree_Computed, depth int) (*zanzibar.UsersetTree, error) {
+	usersets := sets.New[string]()
+	for _, c := range computed {
+		usersets.Insert(c.GetUserset())
+	}
+	// Add the objects pointed to by contextual tupleset tuples, which Expand does not know about
+	referencedRelations := referencedRelationsFor(&e.o.as, tree.Object.NodeType(), tree.Relation, tree.Tupleset)
+	for _, ct := range e.contextualTuples {
+		if ct.Relation != tree.Tupleset || zanzibar.IsUserSet(ct.User) ||
+			ct.Object.NodeType() != tree.Object.NodeType() || ct.Object.NodeName() != tree.Object.NodeName() {
+			continue
+		}
+		for referencedRelation := range referencedRelations {
+			usersets.Insert(withUserSetRelation(nodeString(ct.User.NodeType(), ct.User.NodeName()), referencedRelation))
+		}
+	}
+
+	for _, userset := range sets.List(usersets) {
+		object, relation, err := parseUserset(userset)
+		if err != nil {
+			return nil, err
+		}
+		child, err := e.expandIfRelated(ctx, object, relation, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			tree.Children = append(tree.Children, child)
+		}
+	}
+	if len(tree.Children) == 0 {
+		return nil, nil
+	}
+	return tree, nil
+}
+
+// referencedRelationsFor returns all relations referenced through the given tupleset
+// relation in the rewrite of objectType#relation
+func referencedRelationsFor(as *zanzibar.AuthorizationSchema, objectType, relation, tupleset string) sets.Set[string] {
+	result := sets.New[string]()
+	var walk func(eu zanzibar.EvaluatedUserset)
+	walk = func(eu zanzibar.EvaluatedUserset) {
+		for _, child := range append(append([]zanzibar.EvaluatedUserset{}, eu.Union...), eu.Intersection...) {
+			walk(child)
+		}
+		if eu.Difference != nil {
+			walk(eu.Difference.Base)
+			walk(eu.Difference.Subtract)
+		}
+		if eu.TupleToUserset != nil && eu.TupleToUserset.FromRelation == tupleset {
+			result.Insert(eu.TupleToUserset.ReferencedRelation)
+		}
+	}
+	for _, tr := range as.Types {
+		if tr.TypeName != objectType {
+			continue
+		}
+		if eu, ok := tr.EvaluatedUsersets[relation]; ok {
+			walk(eu)
+		}
+	}
+	return result
+}
+
+// parseUserset parses a "type:name#relation" string
+func parseUserset(userset string) (zanzibar.Node, string, error) {
+	object, relation := tuple.SplitObjectRelation(userset)
+	objectType, objectName := tuple.SplitObject(object)
+	if len(objectType) == 0 || len(objectName) == 0 || len(relation) == 0 || strings.Contains(relation, "#") {
+		return nil, "", fmt.Errorf("invalid userset %q", userset)
+	}
+	return zanzibar.NewNode(objectType, objectName), relation, nil
+}
@@ -0,0 +1,129 @@
+package openfga
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func leafUsers(name string, users ...string) *openfgav1.UsersetTree_Node {
+	return &openfgav1.UsersetTree_Node{Name: name, Value: &openfgav1.UsersetTree_Node_Leaf{Leaf: &openfgav1.UsersetTree_Leaf{
+		Value: &openfgav1.UsersetTree_Leaf_Users{Users: &openfgav1.UsersetTree_Users{Users: users}},
+	}}}
+}
+
+func TestExpandOne(t *testing.T) {
+	// document#viewer is [user] or editor or viewer from parent, and folder#viewer is [user, group#members]
+	as := zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{{
+		TypeName: "document",
+		EvaluatedUsersets: map[string]zanzibar.EvaluatedUserset{
+			"viewer": {Union: []zanzibar.EvaluatedUserset{
+				{Relation: "editor"},
+				{TupleToUserset: &zanzibar.TupleToUserset{FromRelation: "parent", ReferencedRelation: "viewer"}},
+			}},
+		},
+	}}}
+	// the trees as returned by Expand, which does not know about contextual tuples
+	trees := map[string]*openfgav1.UsersetTree_Node{
+		"document:roadmap#viewer": {Name: "document:roadmap#viewer", Value: &openfgav1.UsersetTree_Node_Union{Union: &openfgav1.UsersetTree_Nodes{
+			Nodes: []*openfgav1.UsersetTree_Node{
+				leafUsers("document:roadmap#viewer", "user:bob"),
+				{Name: "document:roadmap#viewer", Value: &openfgav1.UsersetTree_Node_Leaf{Leaf: &openfgav1.UsersetTree_Leaf{
+					Value: &openfgav1.UsersetTree_Leaf_Computed{Computed: &openfgav1.UsersetTree_Computed{Userset: "document:roadmap#editor"}},
+				}}},
+				{Name: "document:roadmap#viewer", Value: &openfgav1.UsersetTree_Node_Leaf{Leaf: &openfgav1.UsersetTree_Leaf{
+					Value: &openfgav1.UsersetTree_Leaf_TupleToUserset{TupleToUserset: &openfgav1.UsersetTree_TupleToUserset{
+						Tupleset: "document:roadmap#parent",
+						Computed: []*openfgav1.UsersetTree_Computed{{Userset: "folder:stored#viewer"}},
+					}},
+				}}},
+			},
+		}}},
+		"document:roadmap#editor": leafUsers("document:roadmap#editor", "user:carol"),
+		"folder:stored#viewer":    leafUsers("folder:stored#viewer", "group:sre#members"),
+		"folder:context#viewer":   leafUsers("folder:context#viewer"),
+		"group:sre#members":       leafUsers("group:sre#members", "user:dave"),
+	}
+
+	tests := []struct {
+		name             string
+		tuple            Tuple
+		contextualTuples []Tuple
+		// allowed are the checks allowed by OpenFGA, which takes the contextual tuples into account
+		allowed      []string
+		want         string
+		wantExpanded []string
+	}{
+		{
+			name:  "direct",
+			tuple: zanzibar.MustParseTuple("user:bob viewer document:roadmap"),
+			contextualTuples: []Tuple{
+				zanzibar.MustParseTuple("user:bob members group:oncall"),
+			},
+			allowed: []string{"user:bob viewer document:roadmap"},
+			want: "document:roadmap#viewer Union\n" +
+				"  document:roadmap#viewer Direct [user:bob]\n",
+			// the editor and parent branches are pruned by checks, without expanding them
+			wantExpanded: []string{"document:roadmap#viewer"},
+		},
+		{
+			name:  "userset member through contextual tuple",
+			tuple: zanzibar.MustParseTuple("user:eve viewer document:roadmap"),
+			contextualTuples: []Tuple{
+				zanzibar.MustParseTuple("user:eve members group:sre"),
+			},
+			allowed: []string{
+				"user:eve viewer document:roadmap",
+				"user:eve viewer folder:stored",
+				"user:eve members group:sre",
+			},
+			want: "document:roadmap#viewer Union\n" +
+				"  document:roadmap#viewer TupleToUserset from parent\n" +
+				"    folder:stored#viewer Direct [group:sre#members]\n" +
+				"      group:sre#members Direct [user:eve]\n",
+			wantExpanded: []string{"document:roadmap#viewer", "folder:stored#viewer", "group:sre#members"},
+		},
+		{
+			name:  "tupleset and user through contextual tuples",
+			tuple: zanzibar.MustParseTuple("user:alice viewer document:roadmap"),
+			contextualTuples: []Tuple{
+				zanzibar.MustParseTuple("folder:context parent document:roadmap"),
+				zanzibar.MustParseTuple("user:alice viewer folder:context"),
+			},
+			allowed: []string{
+				"user:alice viewer document:roadmap",
+				"user:alice viewer folder:context",
+			},
+			want: "document:roadmap#viewer Union\n" +
+				"  document:roadmap#viewer TupleToUserset from parent\n" +
+				"    folder:context#viewer Direct [user:alice]\n",
+			wantExpanded: []string{"document:roadmap#viewer", "folder:context#viewer"},
+		},
+		{
+			name:  "not related",
+			tuple: zanzibar.MustParseTuple("user:mallory viewer document:roadmap"),
+			want:  "<not related>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeExpandClient{trees: trees, allowed: map[string]bool{}}
+			for _, allowed := range tt.allowed {
+				fake.allowed[allowed] = true
+			}
+			o := &TupleStoreAndChecker{storeID: "store", as: as, fgaClient: fake}
+
+			got, err := o.ExpandOne(context.Background(), tt.tuple, tt.contextualTuples)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got.String())
+			assert.ElementsMatch(t, tt.wantExpanded, fake.expanded)
+			// the contextual tuples are given to every check
+			if len(tt.contextualTuples) != 0 {
+				assert.Empty(t, fake.checkedWithoutContext)
+			}
+		})
+	}
+}
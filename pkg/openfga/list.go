@@ -0,0 +1,207 @@
+package openfga
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/openfga/openfga/pkg/tuple"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ListObjects uses the ListObjects API. Note that OpenFGA limits the amount of objects
+// returned, and the time spent computing them (by default 1000 objects and 3 seconds).
+func (o *TupleStoreAndChecker) ListObjects(ctx context.Context, user zanzibar.Node, relation, objectType string, contextualTuples []Tuple) ([]zanzibar.Node, error) {
+	resp, err := o.fgaClient.ListObjects(ctx, &openfgav1.ListObjectsRequest{
+		StoreId:              o.storeID,
+		AuthorizationModelId: o.authzModel.Id,
+		Type:                 objectType,
+		Relation:             relation,
+		User:                 userNodeString(user),
+		ContextualTuples: &openfgav1.ContextualTupleKeys{
+			TupleKeys: util.Map(contextualTuples, tupleToOpenFGA),
+		},
+	})
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	result := util.MapNonNil(resp.Objects, func(object string) *zanzibar.Node {
+		objectType, objectName := tuple.SplitObject(object)
+		if len(objectType) == 0 || len(objectName) == 0 {
+			return nil
+		}
+		n := zanzibar.NewNode(objectType, objectName)
+		return &n
+	})
+	zanzibar.SortNodes(result)
+	return result, nil
+}
+
+// ListUsers returns all users of userType related to the object. This version of OpenFGA does
+// not have a ListUsers API, so the candidates are gathered by expanding the object's relation
+// through the Expand API, which only reads the part of the graph leading to the object, and then
+// checked in one batch, as intersections and exclusions can still exclude them.
+// TODO: Switch to the ListUsers API when we upgrade OpenFGA.
+func (o *TupleStoreAndChecker) ListUsers(ctx context.Context, object zanzibar.Node, relation, userType string, contextualTuples []Tuple) ([]zanzibar.Node, error) {
+	g := &candidateGatherer{
+		o:                o,
+		userType:         userType,
+		contextualTuples: contextualTuples,
+		visited:          sets.New[string](),
+		candidates:       sets.New[zanzibar.Node](),
+	}
+	if err := g.gather(ctx, object, relation, 0); err != nil {
+		return nil, err
+	}
+
+	candidates := g.candidates.UnsortedList()
+	requests := util.Map(candidates, func(candidate zanzibar.Node) zanzibar.CheckRequest {
+		return zanzibar.CheckRequest{
+			Tuple:            Tuple{User: candidate, Relation: relation, Object: object},
+			ContextualTuples: contextualTuples,
+		}
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	result := []zanzibar.Node{}
+	for checked := range o.BatchCheck(ctx, requests) {
+		if checked.Err != nil {
+			return nil, checked.Err
+		}
+		if checked.Allowed {
+			result = append(result, candidates[checked.Index])
+		}
+	}
+	zanzibar.SortNodes(result)
+	return result, nil
+}
+
+// candidateGatherer collects the users of userType in the expanded usersets, including those only
+// in contextual tuples, which the Expand API does not know about
+type candidateGatherer struct {
+	o                *TupleStoreAndChecker
+	userType         string
+	contextualTuples []Tuple
+
+	// visited contains the "type:name#relation" usersets expanded already
+	visited    sets.Set[string]
+	candidates sets.Set[zanzibar.Node]
+}
+
+func (g *candidateGatherer) gather(ctx context.Context, object zanzibar.Node, relation string, depth int) error {
+	userset := withUserSetRelation(nodeString(object.NodeType(), object.NodeName()), relation)
+	if g.visited.Has(userset) {
+		return nil
+	}
+	g.visited.Insert(userset)
+	if depth >= maxExpandDepth {
+		return errExpandDepthExceeded
+	}
+
+	resp, err := g.o.fgaClient.Expand(ctx, &openfgav1.ExpandRequest{
+		StoreId:              g.o.storeID,
+		AuthorizationModelId: g.o.authzModel.Id,
+		TupleKey: &openfgav1.TupleKey{
+			Relation: relation,
+			Object:   nodeString(object.NodeType(), object.NodeName()),
+		},
+	})
+	if err != nil {
+		return convertError(err)
+	}
+	return g.gatherNode(ctx, resp.GetTree().GetRoot(), depth)
+}
+
+func (g *candidateGatherer) gatherNode(ctx context.Context, node *openfgav1.UsersetTree_Node, depth int) error {
+	object, relation, err := parseUserset(node.GetName())
+	if err != nil {
+		return err
+	}
+
+	switch n := node.GetValue().(type) {
+	case *openfgav1.UsersetTree_Node_Leaf:
+		switch leaf := n.Leaf.GetValue().(type) {
+		case *openfgav1.UsersetTree_Leaf_Users:
+			users := sets.New(leaf.Users.GetUsers()...)
+			for _, ct := range g.contextualTuples {
+				if ct.Relation == relation && ct.Object.NodeType() == object.NodeType() && ct.Object.NodeName() == object.NodeName() {
+					users.Insert(tupleToOpenFGA(ct).User)
+				}
+			}
+			for _, user := range sets.List(users) {
+				userObject, userSetRelation := tuple.SplitObjectRelation(user)
+				userType, userName := tuple.SplitObject(userObject)
+				if userType == g.userType {
+					// the user itself, a wildcard, or the user node of a userset of the same type
+					g.candidates.Insert(zanzibar.NewNode(userType, userName))
+				}
+				if len(userSetRelation) != 0 {
+					if err := g.gather(ctx, zanzibar.NewNode(userType, userName), userSetRelation, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+
+		case *openfgav1.UsersetTree_Leaf_Computed:
+			computedObject, computedRelation, err := parseUserset(leaf.Computed.GetUserset())
+			if err != nil {
+				return err
+			}
+			return g.gather(ctx, computedObject, computedRelation, depth+1)
+
+		case *openfgav1.UsersetTree_Leaf_TupleToUserset:
+			_, tupleset, err := parseUserset(leaf.TupleToUserset.GetTupleset())
+			if err != nil {
+				return err
+			}
+			usersets := sets.New[string]()
+			for _, c := range leaf.TupleToUserset.GetComputed() {
+				usersets.Insert(c.GetUserset())
+			}
+			referencedRelations := referencedRelationsFor(&g.o.as, object.NodeType(), relation, tupleset)
+			for _, ct := range g.contextualTuples {
+				if ct.Relation != tupleset || zanzibar.IsUserSet(ct.User) ||
+					ct.Object.NodeType() != object.NodeType() || ct.Object.NodeName() != object.NodeName() {
+					continue
+				}
+				for referencedRelation := range referencedRelations {
+					usersets.Insert(withUserSetRelation(nodeString(ct.User.NodeType(), ct.User.NodeName()), referencedRelation))
+				}
+			}
+			for _, userset := range sets.List(usersets) {
+				usersetObject, usersetRelation, err := parseUserset(userset)
+				if err != nil {
+					return err
+				}
+				if err := g.gather(ctx, usersetObject, usersetRelation, depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	case *openfgav1.UsersetTree_Node_Union:
+		return g.gatherNodes(ctx, n.Union.GetNodes(), depth)
+	case *openfgav1.UsersetTree_Node_Intersection:
+		return g.gatherNodes(ctx, n.Intersection.GetNodes(), depth)
+	case *openfgav1.UsersetTree_Node_Difference:
+		// the subtracted users can only exclude candidates
+		return g.gatherNode(ctx, n.Difference.GetBase(), depth)
+	}
+
+	return fmt.Errorf("unknown expand node %q", node.GetName())
+}
+
+func (g *candidateGatherer) gatherNodes(ctx context.Context, nodes []*openfgav1.UsersetTree_Node, depth int) error {
+	for _, node := range nodes {
+		if err := g.gatherNode(ctx, node, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
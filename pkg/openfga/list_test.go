@@ -0,0 +1,97 @@
+package openfga
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// fakeExpandClient serves Expand from fixed trees, or else from fixed usersets of direct users, and Check from
+// a fixed set of allowed "user relation object" strings. Reading the store panics, as the client embeds a nil
+// interface.
+type fakeExpandClient struct {
+	openfgav1.OpenFGAServiceClient
+
+	trees    map[string]*openfgav1.UsersetTree_Node
+	usersets map[string][]string
+	allowed  map[string]bool
+
+	mu       sync.Mutex
+	expanded []string
+	checked  []string
+	// checkedWithoutContext are the checks that got no contextual tuples
+	checkedWithoutContext []string
+}
+
+func (c *fakeExpandClient) Expand(_ context.Context, in *openfgav1.ExpandRequest, _ ...grpc.CallOption) (*openfgav1.ExpandResponse, error) {
+	userset := in.GetTupleKey().GetObject() + "#" + in.GetTupleKey().GetRelation()
+	c.mu.Lock()
+	c.expanded = append(c.expanded, userset)
+	c.mu.Unlock()
+
+	if tree, ok := c.trees[userset]; ok {
+		return &openfgav1.ExpandResponse{Tree: &openfgav1.UsersetTree{Root: tree}}, nil
+	}
+	users, ok := c.usersets[userset]
+	if !ok {
+		return nil, fmt.Errorf("unexpected expand of %s", userset)
+	}
+	return &openfgav1.ExpandResponse{Tree: &openfgav1.UsersetTree{Root: &openfgav1.UsersetTree_Node{
+		Name: userset,
+		Value: &openfgav1.UsersetTree_Node_Leaf{Leaf: &openfgav1.UsersetTree_Leaf{
+			Value: &openfgav1.UsersetTree_Leaf_Users{Users: &openfgav1.UsersetTree_Users{Users: users}},
+		}},
+	}}}, nil
+}
+
+func (c *fakeExpandClient) Check(_ context.Context, in *openfgav1.CheckRequest, _ ...grpc.CallOption) (*openfgav1.CheckResponse, error) {
+	key := in.GetTupleKey()
+	checked := fmt.Sprintf("%s %s %s", key.GetUser(), key.GetRelation(), key.GetObject())
+	c.mu.Lock()
+	c.checked = append(c.checked, checked)
+	if len(in.GetContextualTuples().GetTupleKeys()) == 0 {
+		c.checkedWithoutContext = append(c.checkedWithoutContext, checked)
+	}
+	c.mu.Unlock()
+	return &openfgav1.CheckResponse{Allowed: c.allowed[checked]}, nil
+}
+
+func TestListUsers(t *testing.T) {
+	fake := &fakeExpandClient{
+		usersets: map[string][]string{
+			"document:roadmap#viewer": {"user:alice", "group:sre#members"},
+			"group:sre#members":       {"user:carol", "group:admins#members"},
+			"group:admins#members":    {"user:alice", "user:dave", "group:sre#members"},
+		},
+		allowed: map[string]bool{
+			"user:alice viewer document:roadmap": true,
+			"user:carol viewer document:roadmap": true,
+			"user:eve viewer document:roadmap":   true,
+		},
+	}
+	o := &TupleStoreAndChecker{storeID: "store", fgaClient: fake}
+
+	got, err := o.ListUsers(context.Background(), zanzibar.NewNode("document", "roadmap"), "viewer", "user", []Tuple{
+		zanzibar.MustParseTuple("user:eve members group:sre"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:alice", "user:carol", "user:eve"}, util.Map(got, func(n zanzibar.Node) string {
+		return nodeString(n.NodeType(), n.NodeName())
+	}))
+	// every userset is expanded once, even though they refer to each other
+	assert.ElementsMatch(t, []string{"document:roadmap#viewer", "group:sre#members", "group:admins#members"}, fake.expanded)
+	// dave is a candidate, but is not allowed by the check, e.g. through an exclusion
+	assert.ElementsMatch(t, []string{
+		"user:alice viewer document:roadmap",
+		"user:carol viewer document:roadmap",
+		"user:dave viewer document:roadmap",
+		"user:eve viewer document:roadmap",
+	}, fake.checked)
+}
@@ -3,6 +3,8 @@ package openfga
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/luxas/kube-rebac-authorizer/pkg/util"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
@@ -10,6 +12,8 @@ import (
 	"github.com/openfga/openfga/pkg/tuple"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 // TODO: Add migration steps to the database
@@ -34,7 +38,7 @@ type StoreAgnosticClient struct {
 func (c *StoreAgnosticClient) WithStore(ctx context.Context, storeName string) (*AuthorizationModeller, error) {
 	stores, err := c.fgaClient.ListStores(ctx, &openfgav1.ListStoresRequest{})
 	if err != nil {
-		return nil, err
+		return nil, convertError(err)
 	}
 
 	matchingStores := util.Filter(stores.Stores, func(store *openfgav1.Store) bool {
@@ -57,7 +61,7 @@ func (c *StoreAgnosticClient) WithStore(ctx context.Context, storeName string) (
 		Name: storeName,
 	})
 	if err != nil {
-		return nil, err
+		return nil, convertError(err)
 	}
 	return &AuthorizationModeller{
 		storeID:   resp.Id,
@@ -75,31 +79,66 @@ func (am *AuthorizationModeller) WithAuthorizationSchema(ctx context.Context, as
 
 	authzmodel := BuildAuthorizationModel(as)
 
-	// TODO: Make it possible to do a semantic diff of authorization schemas instead of writing every time.
-
-	modelresp, err := am.fgaClient.WriteAuthorizationModel(ctx, &openfgav1.WriteAuthorizationModelRequest{
-		StoreId:         am.storeID,
-		TypeDefinitions: authzmodel.TypeDefinitions,
-		SchemaVersion:   authzmodel.SchemaVersion,
-	})
+	// Only write the model if it semantically differs from the latest one in the store
+	modelID, err := am.latestEqualModelID(ctx, authzmodel)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(modelID) == 0 {
+		modelresp, err := am.fgaClient.WriteAuthorizationModel(ctx, &openfgav1.WriteAuthorizationModelRequest{
+			StoreId:         am.storeID,
+			TypeDefinitions: authzmodel.TypeDefinitions,
+			SchemaVersion:   authzmodel.SchemaVersion,
+		})
+		if err != nil {
+			return nil, convertError(err)
+		}
+		modelID = modelresp.AuthorizationModelId
+	}
+
 	return &TupleStoreAndChecker{
 		storeID:   am.storeID,
 		as:        as,
 		fgaClient: am.fgaClient,
 		authzModel: openfgav1.AuthorizationModel{
-			Id:              modelresp.AuthorizationModelId,
+			Id:              modelID,
 			SchemaVersion:   authzmodel.SchemaVersion,
 			TypeDefinitions: authzmodel.TypeDefinitions,
 		},
 	}, nil
 }
 
+// latestEqualModelID returns the ID of the latest authorization model in the store, if it is
+// semantically equal to the given model. Otherwise, the diff is logged and an empty string returned.
+func (am *AuthorizationModeller) latestEqualModelID(ctx context.Context, authzmodel *openfgav1.AuthorizationModel) (string, error) {
+	// the models are returned newest first
+	resp, err := am.fgaClient.ReadAuthorizationModels(ctx, &openfgav1.ReadAuthorizationModelsRequest{
+		StoreId:  am.storeID,
+		PageSize: wrapperspb.Int32(1),
+	})
+	if err != nil {
+		return "", convertError(err)
+	}
+	if len(resp.AuthorizationModels) == 0 {
+		return "", nil
+	}
+
+	latest := resp.AuthorizationModels[0]
+	diff := DiffAuthorizationModels(latest, authzmodel)
+	if diff.Empty() && latest.SchemaVersion == authzmodel.SchemaVersion {
+		return latest.Id, nil
+	}
+	log.FromContext(ctx).Info("writing new authorization model", "previousModelID", latest.Id, "diff", diff.String())
+	return "", nil
+}
+
 var _ zanzibar.Checker = &TupleStoreAndChecker{}
 var _ zanzibar.TupleStore = &TupleStoreAndChecker{}
+var _ zanzibar.Expander = &TupleStoreAndChecker{}
+var _ zanzibar.Lister = &TupleStoreAndChecker{}
+var _ zanzibar.BatchChecker = &TupleStoreAndChecker{}
+var _ zanzibar.ConsistencyWaiter = &TupleStoreAndChecker{}
 
 type TupleStoreAndChecker struct {
 	// TODO: Do we need this?
@@ -122,6 +161,20 @@ func (o *TupleStoreAndChecker) GetAuthorizationSchema(_ context.Context) (*zanzi
 func (o *TupleStoreAndChecker) StoreID() string { return o.storeID }
 
 func (o *TupleStoreAndChecker) CheckOne(ctx context.Context, tuple Tuple, contextualTuples []Tuple) (bool, error) {
+	resp, err := o.check(ctx, tuple, contextualTuples)
+	if err != nil {
+		return false, err
+	}
+	return resp.Allowed, nil
+}
+
+// BatchCheck sends the check requests concurrently, as this version of OpenFGA does not
+// have a batch check API.
+func (o *TupleStoreAndChecker) BatchCheck(ctx context.Context, requests []zanzibar.CheckRequest) <-chan zanzibar.CheckResult {
+	return zanzibar.ConcurrentBatchCheck(ctx, o, requests)
+}
+
+func (o *TupleStoreAndChecker) check(ctx context.Context, tuple Tuple, contextualTuples []Tuple) (*openfgav1.CheckResponse, error) {
 
 	clientContextualTuples := util.Map(contextualTuples, tupleToOpenFGA)
 
@@ -135,119 +188,111 @@ func (o *TupleStoreAndChecker) CheckOne(ctx context.Context, tuple Tuple, contex
 		// TODO: set up a docs for the tracing stack
 		Trace: true,
 	})
-	if err != nil {
-		return false, err
-	}
-	return resp.Allowed, nil
+	return resp, convertError(err)
 }
 
-/*func (o *OpenFGAImpl) Read(ctx context.Context, writes, deletes []Tuple) error {
-	// TODO: Pagination
-	o.fgaClient.Read(ctx, &openfgav1.ReadRequest{})
-	// o.fgaClient.ReadChanges(ctx, &openfgav1.ReadChangesRequest{
-}*/
-
 // TODO: Get this constant from openfga directly?
 var maxPageSize = wrapperspb.Int32(100)
 
+// ReadTuples supports all filters described by zanzibar.TupleFilter. The OpenFGA Read API
+// (https://openfga.dev/api/service#/Relationship%20Tuples/Read) only supports reading:
+// - all tuples in the store, if no tuple key is given,
+// - all tuples of a full object (e.g., type:object_id), optionally of a given relation and user, or
+// - all tuples of a type only (e.g., type:), if a full user (or userset) is given.
+//
+// Filters with a user, but no full object, are served by reading all object types the user type is
+// related to in the authorization model. Filters with neither a user nor a full object are served by
+// paginating through all tuples in the store, which can be slow for big stores. The read tuples are
+// always filtered client-side too, e.g. for multiple relations.
 func (o *TupleStoreAndChecker) ReadTuples(ctx context.Context, filter zanzibar.TupleFilter) ([]Tuple, error) {
-
-	// From the OpenFGA docs (https://openfga.dev/api/service#/Relationship%20Tuples/Read):
-	// - tuple_key is optional. If not specified, it will return all tuples in the store.
-	// - tuple_key.object is mandatory if tuple_key is specified.
-	// 		It can be a full object (e.g., type:object_id) or type only (e.g., type:).
-	// - tuple_key.user is mandatory if tuple_key is specified in the case the tuple_key.object is a type only.
-
-	// In this code, we add support for filtering by a user or user set, without specifying any object.
-	// However, we cannot support in this code the case when objectType is set but not objectName AND
-	// there is no fully-qualified user node.
-
 	if err := filter.Validate(); err != nil {
 		return nil, err
 	}
 
-	hasUserType := len(filter.UserType) != 0
-	hasUserName := len(filter.UserName) != 0
-	hasObjectType := len(filter.ObjectType) != 0
-	//hasObjectName := len(filter.ObjectName) != 0
-
-	// We know from validation that if if userName is set, then userType is too.
-	// But we don't support the case when userType is set, but not userName, either
-	// both are set or empty
-	if hasUserType && !hasUserName {
-		// TODO: typed errors
-		return nil, fmt.Errorf("must specify either both UserType and UserName or neither")
-	}
-
-	// Need special handling if a user node was specified, but no object at all
-	requestFilters := []zanzibar.TupleFilter{}
-	if hasUserType && hasUserName && !hasObjectType {
-		// TODO: This could use the generic authorization schema too, but I don't know if it's any better.
-		tp := GetOutgoingRelationTypesFor(&o.authzModel, filter.UserType)
-		if filter.UserName == zanzibar.TupleFilterWildcardUserName {
-			// query tuples for all types this type has a wildcard connection to
-			for objectType := range tp.Wildcards {
-				requestFilters = append(requestFilters, zanzibar.TupleFilter{
-					UserType:   filter.UserType,
-					UserName:   filter.UserName,
-					Relation:   filter.Relation,
-					ObjectType: objectType,
-				})
-			}
-		} else if len(filter.UserSetRelation) == 0 {
-			// find all direct relations from this user type.
-			// username wildcard and usersetrelation non-empty are mutually exclusive
-			for objectType := range tp.Directs {
-				requestFilters = append(requestFilters, zanzibar.TupleFilter{
-					UserType:   filter.UserType,
-					UserName:   filter.UserName,
-					Relation:   filter.Relation,
-					ObjectType: objectType,
-				})
-			}
-		} else { // UserSetRelation is non-empty, UserName not wildcard
-			for objectType, relationSet := range tp.TypesThroughUsersets {
-				for userSetRelation := range relationSet {
-					// the relation matches the query if the query is a wildcard or equal
-					if filter.UserSetRelation == userSetRelation || filter.UserSetRelation == zanzibar.TupleFilterWildcardUserSetRelation {
-						requestFilters = append(requestFilters, zanzibar.TupleFilter{
-							UserType:        filter.UserType,
-							UserName:        filter.UserName,
-							UserSetRelation: userSetRelation,
-							Relation:        filter.Relation,
-							ObjectType:      objectType,
-						})
-					}
-				}
-			}
-		}
-	} else {
-		// either user node is not set at all, or user node is set and at least the object type,
-		// so openfga supports this query "natively", just pass the parameter along
-		requestFilters = append(requestFilters, filter)
-	}
-
 	result := []Tuple{}
-	for _, currentFilter := range requestFilters {
+	for _, key := range readTupleKeys(&o.authzModel, filter) {
 		resp, err := o.readPaginated(ctx, &openfgav1.ReadRequest{
 			StoreId:  o.storeID,
-			TupleKey: tupleFilterToOpenFGA(&currentFilter),
+			TupleKey: key,
 			PageSize: maxPageSize,
 		})
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, util.MapNonNil(resp, openFGAToTuple)...)
+		result = append(result, util.Filter(util.MapNonNil(resp, openFGAToTuple), filter.Matches)...)
 	}
-
 	return result, nil
 }
 
+// readTupleKeys returns the tuple keys to read for serving the filter. A nil key reads all tuples in the store.
+func readTupleKeys(model *openfgav1.AuthorizationModel, filter zanzibar.TupleFilter) []*openfgav1.TupleKey {
+	// only one relation can be sent; multiple relations are filtered client-side
+	relation := ""
+	if relations := filter.RelationSet(); len(relations) == 1 {
+		relation = relations[0]
+	}
+	hasUser := len(filter.UserName) != 0
+
+	if len(filter.ObjectName) != 0 {
+		// a full object can be read with or without a user
+		key := &openfgav1.TupleKey{
+			Relation: relation,
+			Object:   nodeString(filter.ObjectType, filter.ObjectName),
+		}
+		if hasUser && filter.UserSetRelation != zanzibar.TupleFilterWildcardUserSetRelation {
+			key.User = userFilterString(filter.UserType, filter.UserName, filter.UserSetRelation)
+		}
+		return []*openfgav1.TupleKey{key}
+	}
+	if !hasUser {
+		// reading all tuples of a type requires a full user, so scan all tuples instead
+		return []*openfgav1.TupleKey{nil}
+	}
+
+	// Read the user for every object type (and userset relation) it is related to in the model
+	// TODO: This could use the generic authorization schema too, but I don't know if it's any better.
+	keys := []*openfgav1.TupleKey{}
+	read := func(objectType, userSetRelation string) {
+		if len(filter.ObjectType) != 0 && filter.ObjectType != objectType {
+			return
+		}
+		keys = append(keys, &openfgav1.TupleKey{
+			User:     userFilterString(filter.UserType, filter.UserName, userSetRelation),
+			Relation: relation,
+			Object:   nodeString(objectType, ""),
+		})
+	}
+	tp := GetOutgoingRelationTypesFor(model, filter.UserType)
+	switch {
+	case filter.UserName == zanzibar.TupleFilterWildcardUserName:
+		// query tuples for all types this type has a wildcard connection to
+		for _, objectType := range sets.List(tp.Wildcards) {
+			read(objectType, "")
+		}
+	case len(filter.UserSetRelation) == 0:
+		// find all direct relations from this user type.
+		// username wildcard and usersetrelation non-empty are mutually exclusive
+		for _, objectType := range sets.List(tp.Directs) {
+			read(objectType, "")
+		}
+	default:
+		for _, objectType := range sets.List(sets.KeySet(tp.TypesThroughUsersets)) {
+			for _, userSetRelation := range sets.List(tp.TypesThroughUsersets[objectType]) {
+				// the relation matches the query if the query is a wildcard or equal
+				if filter.UserSetRelation == userSetRelation || filter.UserSetRelation == zanzibar.TupleFilterWildcardUserSetRelation {
+					read(objectType, userSetRelation)
+				}
+			}
+		}
+	}
+	return keys
+}
+
 func (o *TupleStoreAndChecker) readPaginated(ctx context.Context, rr *openfgav1.ReadRequest) ([]*openfgav1.Tuple, error) {
 	result := []*openfgav1.Tuple{}
 	resp, err := o.fgaClient.Read(ctx, rr)
 	if err != nil {
-		return nil, err
+		return nil, convertError(err)
 	}
 	result = append(result, resp.Tuples...)
 
@@ -255,16 +300,21 @@ func (o *TupleStoreAndChecker) readPaginated(ctx context.Context, rr *openfgav1.
 		rr.ContinuationToken = resp.ContinuationToken
 		resp, err = o.fgaClient.Read(ctx, rr)
 		if err != nil {
-			return nil, err
+			return nil, convertError(err)
 		}
 		result = append(result, resp.Tuples...)
 	}
 	return result, nil
 }
 
-func (o *TupleStoreAndChecker) WriteTuples(ctx context.Context, writes, deletes []Tuple) error {
+func (o *TupleStoreAndChecker) WriteTuples(ctx context.Context, writes, deletes []Tuple) (zanzibar.ConsistencyToken, error) {
 	// TODO: Max 10 tuples in total for deletes+writes; need pagination
 	// TODO: The API is not idempotent; need to read first, then write
+	// validate all tuples before writing any batch, such that an invalid tuple does not leave the
+	// write half-applied
+	if err := validateTuples(&o.authzModel, writes, deletes); err != nil {
+		return "", err
+	}
 	writesLen := len(writes)
 	deletesLen := len(deletes)
 	totalLen := writesLen + deletesLen
@@ -297,15 +347,33 @@ func (o *TupleStoreAndChecker) WriteTuples(ctx context.Context, writes, deletes
 
 		_, err := o.fgaClient.Write(ctx, req)
 		if err != nil {
-			return err
+			return "", convertError(err)
 		}
 
 		i += writesEnd - writesStart
 		i += deletesEnd - deletesStart
 	}
+	return o.consistencyToken(time.Now()), nil
+}
+
+// WaitForConsistency returns directly for tokens issued for this store, as OpenFGA evaluates checks
+// against its datastore, and the write has been committed to the datastore once WriteTuples returns.
+// TODO: If the check query cache of OpenFGA is enabled, checks can be stale for up to the cache TTL.
+// Once OpenFGA supports requesting higher consistency for checks, use it for checks issued after
+// the time of the token.
+func (o *TupleStoreAndChecker) WaitForConsistency(_ context.Context, token zanzibar.ConsistencyToken) error {
+	storeID, _, ok := strings.Cut(string(token), "/")
+	if !ok || storeID != o.storeID {
+		return fmt.Errorf("%w: %q", zanzibar.ErrUnknownConsistencyToken, token)
+	}
 	return nil
 }
 
+// consistencyToken is of the form "{storeID}/{time of write}", as OpenFGA has no tokens of its own
+func (o *TupleStoreAndChecker) consistencyToken(t time.Time) zanzibar.ConsistencyToken {
+	return zanzibar.ConsistencyToken(o.storeID + "/" + t.UTC().Format(time.RFC3339Nano))
+}
+
 // TODO: Move to util
 func min(i, j int) int {
 	if i < j {
@@ -321,32 +389,29 @@ func max(i, j int) int {
 }
 
 func tupleToOpenFGA(tuple Tuple) *openfgav1.TupleKey {
-	userNodeString := nodeString(tuple.User.NodeType(), tuple.User.NodeName())
-	if us, ok := tuple.GetUserSet(); ok {
-		userNodeString = withUserSetRelation(userNodeString, us.UserSetRelation())
-	}
-
 	return &openfgav1.TupleKey{
-		User:     userNodeString,
+		User:     userNodeString(tuple.User),
 		Relation: tuple.Relation,
 		Object:   nodeString(tuple.Object.NodeType(), tuple.Object.NodeName()),
 	}
 }
 
-// tupleFilterToOpenFGA converts a tuple filter into a format OpenFGA understands
-// IMPORTANT! UserSetRelation cannot be a wildcard here, the wildcard must be
-// processed before by the caller, and only relations that exist in the model
-// can be used.
-func tupleFilterToOpenFGA(filter *zanzibar.TupleFilter) *openfgav1.TupleKey {
-	u := nodeString(filter.UserType, filter.UserName)
-	if len(filter.UserSetRelation) != 0 {
-		u = withUserSetRelation(u, filter.UserSetRelation)
+func userNodeString(user zanzibar.Node) string {
+	userNodeString := nodeString(user.NodeType(), user.NodeName())
+	if us, ok := zanzibar.ToUserSet(user); ok && len(us.UserSetRelation()) != 0 {
+		userNodeString = withUserSetRelation(userNodeString, us.UserSetRelation())
 	}
-	return &openfgav1.TupleKey{
-		User:     u,
-		Relation: filter.Relation,
-		Object:   nodeString(filter.ObjectType, filter.ObjectName),
+	return userNodeString
+}
+
+// userFilterString returns the user of a tuple key, with the userset relation if any. The userset
+// relation cannot be a wildcard here, it must be expanded by the caller.
+func userFilterString(userType, userName, userSetRelation string) string {
+	u := nodeString(userType, userName)
+	if len(userSetRelation) != 0 {
+		u = withUserSetRelation(u, userSetRelation)
 	}
+	return u
 }
 
 // TODO: call the openfga tuple package?
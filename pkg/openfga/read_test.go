@@ -0,0 +1,195 @@
+package openfga
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/openfga/language/pkg/go/transformer"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+const readTestAuthzModel = `model
+  schema 1.1
+type user
+
+type group
+  relations
+    define members: [user, group#members]
+
+type document
+  relations
+    define viewer: [user, user:*, group#members]
+    define editor: [user, group#members]
+
+type role
+  relations
+    define assignee: [user]
+`
+
+// fakeReadClient serves Read from a fixed list of tuples, two tuples per page, and rejects
+// the tuple keys the OpenFGA Read API rejects
+type fakeReadClient struct {
+	openfgav1.OpenFGAServiceClient
+
+	tuples []*openfgav1.Tuple
+	reads  int
+}
+
+func (c *fakeReadClient) Read(_ context.Context, in *openfgav1.ReadRequest, _ ...grpc.CallOption) (*openfgav1.ReadResponse, error) {
+	c.reads++
+	key := in.GetTupleKey()
+	if key != nil {
+		objectType, objectName, _ := strings.Cut(key.GetObject(), ":")
+		if len(objectType) == 0 {
+			return nil, errors.New("the object is mandatory")
+		}
+		if len(objectName) == 0 && len(key.GetUser()) == 0 {
+			return nil, errors.New("the user is mandatory when the object is a type only")
+		}
+	}
+
+	matching := util.Filter(c.tuples, func(t *openfgav1.Tuple) bool {
+		if key == nil {
+			return true
+		}
+		objectType, objectName, _ := strings.Cut(key.GetObject(), ":")
+		return strings.HasPrefix(t.Key.Object, objectType+":") &&
+			(len(objectName) == 0 || t.Key.Object == key.GetObject()) &&
+			(len(key.GetRelation()) == 0 || t.Key.Relation == key.GetRelation()) &&
+			(len(key.GetUser()) == 0 || t.Key.User == key.GetUser())
+	})
+
+	start := 0
+	if len(in.ContinuationToken) != 0 {
+		start, _ = strconv.Atoi(in.ContinuationToken)
+	}
+	end := min(start+2, len(matching))
+	resp := &openfgav1.ReadResponse{Tuples: matching[start:end]}
+	if end < len(matching) {
+		resp.ContinuationToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+func TestReadTuples(t *testing.T) {
+	authzmodel, err := transformer.TransformDSLToJSON(readTestAuthzModel)
+	assert.NoError(t, err)
+
+	stored := []Tuple{
+		zanzibar.MustParseTuple("user:alice members group:admins"),
+		zanzibar.MustParseTuple("group:admins#members members group:sre"),
+		zanzibar.MustParseTuple("user:alice viewer document:roadmap"),
+		zanzibar.MustParseTuple("user:bob editor document:roadmap"),
+		zanzibar.MustParseTuple("user:* viewer document:handbook"),
+		zanzibar.MustParseTuple("group:admins#members editor document:handbook"),
+		zanzibar.MustParseTuple("group:sre#members viewer document:roadmap"),
+		zanzibar.MustParseTuple("user:alice assignee role:admin"),
+		zanzibar.MustParseTuple("user:bob assignee role:view"),
+	}
+	fake := &fakeReadClient{tuples: util.Map(stored, func(t Tuple) *openfgav1.Tuple {
+		return &openfgav1.Tuple{Key: tupleToOpenFGA(t)}
+	})}
+	o := &TupleStoreAndChecker{storeID: "store", fgaClient: fake, authzModel: openfgav1.AuthorizationModel{
+		SchemaVersion:   authzmodel.SchemaVersion,
+		TypeDefinitions: authzmodel.TypeDefinitions,
+	}}
+
+	tests := []struct {
+		name      string
+		filter    zanzibar.TupleFilter
+		want      []string
+		wantErr   error
+		wantReads int
+	}{
+		{
+			name:      "all tuples",
+			filter:    zanzibar.TupleFilter{},
+			want:      util.Map(stored, Tuple.String),
+			wantReads: 5,
+		},
+		{
+			name:   "full object",
+			filter: zanzibar.TupleFilter{ObjectType: "document", ObjectName: "roadmap", Relations: []string{"viewer", "editor"}},
+			want: []string{
+				"user:alice viewer document:roadmap",
+				"user:bob editor document:roadmap",
+				"group:sre#members viewer document:roadmap",
+			},
+		},
+		{
+			name:   "user to all object types",
+			filter: zanzibar.TupleFilter{UserType: "user", UserName: "alice"},
+			want: []string{
+				"user:alice viewer document:roadmap",
+				"user:alice members group:admins",
+				"user:alice assignee role:admin",
+			},
+		},
+		{
+			name:   "all usersets of a user",
+			filter: zanzibar.TupleFilter{UserType: "group", UserName: "admins", UserSetRelation: zanzibar.TupleFilterWildcardUserSetRelation},
+			want: []string{
+				"group:admins#members editor document:handbook",
+				"group:admins#members members group:sre",
+			},
+		},
+		{
+			name:   "wildcard user",
+			filter: zanzibar.TupleFilter{UserType: "user", UserName: zanzibar.TupleFilterWildcardUserName},
+			want:   []string{"user:* viewer document:handbook"},
+		},
+		{
+			name:   "all tuples from a user type",
+			filter: zanzibar.TupleFilter{UserType: "group"},
+			want: []string{
+				"group:admins#members members group:sre",
+				"group:admins#members editor document:handbook",
+				"group:sre#members viewer document:roadmap",
+			},
+		},
+		{
+			name:   "all tuples of an object type",
+			filter: zanzibar.TupleFilter{ObjectType: "role"},
+			want: []string{
+				"user:alice assignee role:admin",
+				"user:bob assignee role:view",
+			},
+		},
+		{
+			name:   "user type and object type with multiple relations",
+			filter: zanzibar.TupleFilter{UserType: "user", ObjectType: "document", Relations: []string{"viewer", "editor"}},
+			want: []string{
+				"user:alice viewer document:roadmap",
+				"user:bob editor document:roadmap",
+				"user:* viewer document:handbook",
+			},
+		},
+		{
+			name:    "invalid filter",
+			filter:  zanzibar.TupleFilter{Relation: "viewer", Relations: []string{"editor"}},
+			wantErr: zanzibar.ErrUnsupportedFilter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.reads = 0
+			got, err := o.ReadTuples(context.Background(), tt.filter)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, util.Map(got, Tuple.String))
+			if tt.wantReads != 0 {
+				assert.Equal(t, tt.wantReads, fake.reads)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package openfga
+
+import (
+	"fmt"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/openfga/language/pkg/go/transformer"
+)
+
+// NewStoreFile builds an OpenFGA CLI store file with the model built from the schema, in the DSL format,
+// and the given tuples. The store file can e.g. be loaded using "fga store import".
+func NewStoreFile(name string, as zanzibar.AuthorizationSchema, tuples zanzibar.Tuples) (*zanzibar.StoreFile, error) {
+	model, err := transformer.TransformJSONProtoToDSL(BuildAuthorizationModel(as))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't convert the authorization model to DSL: %w", err)
+	}
+	return &zanzibar.StoreFile{
+		Name:   name,
+		Model:  model,
+		Tuples: tuples,
+	}, nil
+}
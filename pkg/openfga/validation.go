@@ -0,0 +1,128 @@
+package openfga
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/openfga/openfga/pkg/tuple"
+)
+
+// The limits of the OpenFGA API, see the validation rules of openfgav1.TupleKey
+const (
+	maxObjectLength   = 256
+	maxUserLength     = 512
+	maxRelationLength = 50
+)
+
+// validateTuples validates all tuples before they are sent to OpenFGA, such that one invalid tuple
+// does not fail a whole batch with an opaque server error. The errors of all invalid tuples are
+// returned as zanzibar.TupleErrors, wrapping zanzibar.ErrInvalidTuple. Tuples to write must also be
+// allowed by the type restrictions of the model, while tuples to delete only need to be well-formed,
+// such that tuples no longer allowed by the model can be deleted.
+func validateTuples(model *openfgav1.AuthorizationModel, writes, deletes []Tuple) error {
+	errs := []error{}
+	for _, t := range writes {
+		err := validateTupleFormat(t)
+		if err == nil {
+			err = validateTypeRestrictions(model, t)
+		}
+		if err != nil {
+			errs = append(errs, &zanzibar.TupleError{Tuple: t, Err: err})
+		}
+	}
+	for _, t := range deletes {
+		if err := validateTupleFormat(t); err != nil {
+			errs = append(errs, &zanzibar.TupleError{Tuple: t, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateTupleFormat makes sure the tuple is accepted by the OpenFGA API, that is, that the IDs
+// do not contain characters OpenFGA uses as separators, and that the lengths are within the limits
+func validateTupleFormat(t Tuple) error {
+	if !t.Valid() {
+		return fmt.Errorf("%w: user, relation and object must be set", zanzibar.ErrInvalidTuple)
+	}
+	key := tupleToOpenFGA(t)
+	if !tuple.IsValidObject(key.Object) {
+		return fmt.Errorf("%w: object %q must be of the form type:id, where the id does not contain whitespace, ':' or '#'", zanzibar.ErrInvalidTuple, key.Object)
+	}
+	if len(key.Object) > maxObjectLength {
+		return fmt.Errorf("%w: object must be at most %d bytes, is %d", zanzibar.ErrInvalidTuple, maxObjectLength, len(key.Object))
+	}
+	if !tuple.IsValidRelation(key.Relation) {
+		return fmt.Errorf("%w: relation %q must not contain whitespace, ':', '#' or '@'", zanzibar.ErrInvalidTuple, key.Relation)
+	}
+	if len(key.Relation) > maxRelationLength {
+		return fmt.Errorf("%w: relation must be at most %d bytes, is %d", zanzibar.ErrInvalidTuple, maxRelationLength, len(key.Relation))
+	}
+	// the user must be an object, a typed wildcard or a userset; the object regexp covers typed wildcards
+	if userObject, _ := tuple.SplitObjectRelation(key.User); !tuple.IsValidObject(userObject) || !tuple.IsValidUser(key.User) {
+		return fmt.Errorf("%w: user %q must be of the form type:id or type:id#relation, where the id does not contain whitespace, ':' or '#'", zanzibar.ErrInvalidTuple, key.User)
+	}
+	if len(key.User) > maxUserLength {
+		return fmt.Errorf("%w: user must be at most %d bytes, is %d", zanzibar.ErrInvalidTuple, maxUserLength, len(key.User))
+	}
+	return nil
+}
+
+// validateTypeRestrictions makes sure the type of the user (and its userset relation) is directly
+// related to the relation of the object type in the model
+func validateTypeRestrictions(model *openfgav1.AuthorizationModel, t Tuple) error {
+	objectType := typeDefinition(model, t.Object.NodeType())
+	if objectType == nil {
+		return fmt.Errorf("%w: %w %q", zanzibar.ErrInvalidTuple, zanzibar.ErrUnknownType, t.Object.NodeType())
+	}
+	if _, ok := objectType.GetRelations()[t.Relation]; !ok {
+		return fmt.Errorf("%w: %w %q for type %q", zanzibar.ErrInvalidTuple, zanzibar.ErrUnknownRelation, t.Relation, t.Object.NodeType())
+	}
+
+	userSetRelation := t.GetUserSetRelation()
+	if len(userSetRelation) != 0 {
+		userType := typeDefinition(model, t.User.NodeType())
+		if userType == nil {
+			return fmt.Errorf("%w: %w %q", zanzibar.ErrInvalidTuple, zanzibar.ErrUnknownType, t.User.NodeType())
+		}
+		if _, ok := userType.GetRelations()[userSetRelation]; !ok {
+			return fmt.Errorf("%w: %w %q for type %q", zanzibar.ErrInvalidTuple, zanzibar.ErrUnknownRelation, userSetRelation, t.User.NodeType())
+		}
+	}
+
+	wildcard := t.User.NodeName() == zanzibar.TupleFilterWildcardUserName
+	for _, rr := range objectType.GetMetadata().GetRelations()[t.Relation].GetDirectlyRelatedUserTypes() {
+		if rr.GetType() != t.User.NodeType() {
+			continue
+		}
+		switch {
+		case wildcard && rr.GetWildcard() != nil:
+			return nil
+		case !wildcard && rr.GetWildcard() == nil && rr.GetRelation() == userSetRelation:
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not directly related to %s#%s in the authorization model",
+		zanzibar.ErrInvalidTuple, userTypeString(t), t.Object.NodeType(), t.Relation)
+}
+
+func typeDefinition(model *openfgav1.AuthorizationModel, typeName string) *openfgav1.TypeDefinition {
+	for _, td := range model.GetTypeDefinitions() {
+		if td.GetType() == typeName {
+			return td
+		}
+	}
+	return nil
+}
+
+// userTypeString returns the user type of the tuple like in the DSL, e.g. user, user:* or group#members
+func userTypeString(t Tuple) string {
+	if t.User.NodeName() == zanzibar.TupleFilterWildcardUserName {
+		return t.User.NodeType() + ":*"
+	}
+	if userSetRelation := t.GetUserSetRelation(); len(userSetRelation) != 0 {
+		return t.User.NodeType() + "#" + userSetRelation
+	}
+	return t.User.NodeType()
+}
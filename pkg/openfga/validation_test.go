@@ -0,0 +1,108 @@
+package openfga
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"github.com/openfga/language/pkg/go/transformer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTuples(t *testing.T) {
+	authzmodel, err := transformer.TransformDSLToJSON(readTestAuthzModel)
+	assert.NoError(t, err)
+
+	valid := zanzibar.MustParseTuple("user:alice viewer document:roadmap")
+	tests := []struct {
+		name        string
+		writes      []Tuple
+		deletes     []Tuple
+		wantErr     error
+		wantInvalid []Tuple
+	}{
+		{
+			name: "valid tuples",
+			writes: []Tuple{
+				valid,
+				zanzibar.MustParseTuple("user:* viewer document:handbook"),
+				zanzibar.MustParseTuple("group:admins#members editor document:handbook"),
+				zanzibar.MustParseTuple("group:admins#members members group:sre"),
+			},
+			deletes: []Tuple{zanzibar.MustParseTuple("user:alice assignee role:admin")},
+		},
+		{
+			name:        "unknown object type",
+			writes:      []Tuple{valid, zanzibar.MustParseTuple("user:alice viewer folder:clients")},
+			wantErr:     zanzibar.ErrUnknownType,
+			wantInvalid: []Tuple{zanzibar.MustParseTuple("user:alice viewer folder:clients")},
+		},
+		{
+			name:        "unknown relation",
+			writes:      []Tuple{zanzibar.MustParseTuple("user:alice owner document:roadmap")},
+			wantErr:     zanzibar.ErrUnknownRelation,
+			wantInvalid: []Tuple{zanzibar.MustParseTuple("user:alice owner document:roadmap")},
+		},
+		{
+			name:        "unknown userset relation",
+			writes:      []Tuple{zanzibar.MustParseTuple("group:admins#owners viewer document:roadmap")},
+			wantErr:     zanzibar.ErrUnknownRelation,
+			wantInvalid: []Tuple{zanzibar.MustParseTuple("group:admins#owners viewer document:roadmap")},
+		},
+		{
+			name: "user type not directly related",
+			writes: []Tuple{
+				zanzibar.MustParseTuple("group:admins viewer document:roadmap"),
+				zanzibar.MustParseTuple("user:* editor document:roadmap"),
+				zanzibar.MustParseTuple("role:admin#assignee viewer document:roadmap"),
+			},
+			wantErr: zanzibar.ErrInvalidTuple,
+			wantInvalid: []Tuple{
+				zanzibar.MustParseTuple("group:admins viewer document:roadmap"),
+				zanzibar.MustParseTuple("user:* editor document:roadmap"),
+				zanzibar.MustParseTuple("role:admin#assignee viewer document:roadmap"),
+			},
+		},
+		{
+			name:        "id with whitespace",
+			writes:      []Tuple{zanzibar.NewTuple("user", "alice smith", "viewer", "document", "roadmap")},
+			wantErr:     zanzibar.ErrInvalidTuple,
+			wantInvalid: []Tuple{zanzibar.NewTuple("user", "alice smith", "viewer", "document", "roadmap")},
+		},
+		{
+			name:        "object too long",
+			writes:      []Tuple{zanzibar.NewTuple("user", "alice", "viewer", "document", strings.Repeat("a", 250))},
+			wantErr:     zanzibar.ErrInvalidTuple,
+			wantInvalid: []Tuple{zanzibar.NewTuple("user", "alice", "viewer", "document", strings.Repeat("a", 250))},
+		},
+		{
+			name:        "deletes are only validated for their format",
+			writes:      []Tuple{valid},
+			deletes:     []Tuple{zanzibar.MustParseTuple("user:alice owner folder:clients"), zanzibar.NewTuple("user", "alice", "viewer", "document", "road#map")},
+			wantErr:     zanzibar.ErrInvalidTuple,
+			wantInvalid: []Tuple{zanzibar.NewTuple("user", "alice", "viewer", "document", "road#map")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTuples(authzmodel, tt.writes, tt.deletes)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.ErrorIs(t, err, zanzibar.ErrInvalidTuple)
+
+			// each invalid tuple has its own error
+			var invalid []Tuple
+			for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+				var tupleErr *zanzibar.TupleError
+				if assert.True(t, errors.As(err, &tupleErr)) {
+					invalid = append(invalid, tupleErr.Tuple)
+				}
+			}
+			assert.Equal(t, tt.wantInvalid, invalid)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package openfga
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+)
+
+// TupleStoreAndChecker must implement TupleWatcher
+var _ zanzibar.TupleWatcher = &TupleStoreAndChecker{}
+
+// watchPollInterval is how often ReadChanges is polled for new changes, once all existing changes are read
+var watchPollInterval = 2 * time.Second
+
+// WatchTuples polls the ReadChanges API for changes; as long as full pages of changes are returned, the
+// next page is read directly, otherwise the next request is sent after a short interval.
+func (o *TupleStoreAndChecker) WatchTuples(ctx context.Context, objectType, continuationToken string) <-chan zanzibar.TupleChanges {
+	ch := make(chan zanzibar.TupleChanges)
+	send := func(changes zanzibar.TupleChanges) bool {
+		select {
+		case ch <- changes:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(ch)
+		for {
+			resp, err := o.fgaClient.ReadChanges(ctx, &openfgav1.ReadChangesRequest{
+				StoreId:           o.storeID,
+				Type:              objectType,
+				PageSize:          maxPageSize,
+				ContinuationToken: continuationToken,
+			})
+			if err != nil {
+				// a canceled context is not an error, but the expected way to stop watching
+				if ctx.Err() == nil {
+					send(zanzibar.TupleChanges{ContinuationToken: continuationToken, Err: convertError(err)})
+				}
+				return
+			}
+			// OpenFGA returns the token that was sent if there are no new changes, but be defensive
+			if len(resp.ContinuationToken) != 0 {
+				continuationToken = resp.ContinuationToken
+			}
+
+			if len(resp.Changes) != 0 {
+				changes, err := openFGAToTupleChanges(resp.Changes)
+				if err != nil {
+					send(zanzibar.TupleChanges{ContinuationToken: continuationToken, Err: err})
+					return
+				}
+				if !send(zanzibar.TupleChanges{Changes: changes, ContinuationToken: continuationToken}) {
+					return
+				}
+			}
+
+			// only wait if all changes have been read
+			if len(resp.Changes) >= int(maxPageSize.GetValue()) {
+				continue
+			}
+			select {
+			case <-time.After(watchPollInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func openFGAToTupleChanges(changes []*openfgav1.TupleChange) ([]zanzibar.TupleChange, error) {
+	result := make([]zanzibar.TupleChange, 0, len(changes))
+	for _, change := range changes {
+		tuple := openFGAToTuple(&openfgav1.Tuple{Key: change.GetTupleKey()})
+		if tuple == nil {
+			return nil, fmt.Errorf("ReadChanges returned invalid tuple: %v", change.GetTupleKey())
+		}
+		var operation zanzibar.TupleOperation
+		switch change.GetOperation() {
+		case openfgav1.TupleOperation_TUPLE_OPERATION_WRITE:
+			operation = zanzibar.TupleOperationWrite
+		case openfgav1.TupleOperation_TUPLE_OPERATION_DELETE:
+			operation = zanzibar.TupleOperationDelete
+		default:
+			return nil, fmt.Errorf("ReadChanges returned unknown tuple operation: %v", change.GetOperation())
+		}
+		result = append(result, zanzibar.TupleChange{
+			Tuple:     *tuple,
+			Operation: operation,
+			Timestamp: change.GetTimestamp().AsTime(),
+		})
+	}
+	return result, nil
+}
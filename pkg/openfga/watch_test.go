@@ -0,0 +1,93 @@
+package openfga
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeChangesClient serves ReadChanges from a fixed list of changes, where the
+// continuation token is the index of the next change
+type fakeChangesClient struct {
+	openfgav1.OpenFGAServiceClient
+
+	mu      sync.Mutex
+	changes []*openfgav1.TupleChange
+}
+
+func (c *fakeChangesClient) ReadChanges(ctx context.Context, in *openfgav1.ReadChangesRequest, _ ...grpc.CallOption) (*openfgav1.ReadChangesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := 0
+	if len(in.ContinuationToken) != 0 {
+		start = int(in.ContinuationToken[0] - '0')
+	}
+	end := min(start+int(in.GetPageSize().GetValue()), len(c.changes))
+	return &openfgav1.ReadChangesResponse{
+		Changes:           c.changes[start:end],
+		ContinuationToken: string(rune('0' + end)),
+	}, nil
+}
+
+func (c *fakeChangesClient) add(change *openfgav1.TupleChange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes = append(c.changes, change)
+}
+
+func TestWatchTuples(t *testing.T) {
+	oldWatchPollInterval := watchPollInterval
+	t.Cleanup(func() { watchPollInterval = oldWatchPollInterval })
+	watchPollInterval = 10 * time.Millisecond
+	ts := time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+	fake := &fakeChangesClient{changes: []*openfgav1.TupleChange{
+		{
+			TupleKey:  &openfgav1.TupleKey{User: "user:alice", Relation: "viewer", Object: "document:roadmap"},
+			Operation: openfgav1.TupleOperation_TUPLE_OPERATION_WRITE,
+			Timestamp: timestamppb.New(ts),
+		},
+		{
+			TupleKey:  &openfgav1.TupleKey{User: "user:alice", Relation: "viewer", Object: "document:roadmap"},
+			Operation: openfgav1.TupleOperation_TUPLE_OPERATION_DELETE,
+			Timestamp: timestamppb.New(ts.Add(time.Second)),
+		},
+	}}
+	o := &TupleStoreAndChecker{storeID: "store", fgaClient: fake}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := o.WatchTuples(ctx, "document", "")
+
+	got := <-ch
+	assert.NoError(t, got.Err)
+	assert.Equal(t, "2", got.ContinuationToken)
+	assert.Equal(t, []zanzibar.TupleChange{
+		{Tuple: zanzibar.MustParseTuple("user:alice viewer document:roadmap"), Operation: zanzibar.TupleOperationWrite, Timestamp: ts},
+		{Tuple: zanzibar.MustParseTuple("user:alice viewer document:roadmap"), Operation: zanzibar.TupleOperationDelete, Timestamp: ts.Add(time.Second)},
+	}, got.Changes)
+
+	// changes made after the watch started are picked up when polling
+	fake.add(&openfgav1.TupleChange{
+		TupleKey:  &openfgav1.TupleKey{User: "group:admins#members", Relation: "editor", Object: "document:roadmap"},
+		Operation: openfgav1.TupleOperation_TUPLE_OPERATION_WRITE,
+		Timestamp: timestamppb.New(ts),
+	})
+	got = <-ch
+	assert.NoError(t, got.Err)
+	assert.Equal(t, "3", got.ContinuationToken)
+	assert.Equal(t, []zanzibar.TupleChange{
+		{Tuple: zanzibar.MustParseTuple("group:admins#members editor document:roadmap"), Operation: zanzibar.TupleOperationWrite, Timestamp: ts},
+	}, got.Changes)
+
+	// the channel is closed when the context is canceled
+	cancel()
+	for range ch {
+	}
+}
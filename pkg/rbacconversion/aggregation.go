@@ -0,0 +1,225 @@
+package rbacconversion
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// MaxAggregationSelectorRequirements is the maximum number of distinct label keys a selector of an aggregation rule can
+// require. As the authorization model cannot intersect the labels of a clusterrole, each clusterrole instead generates one
+// clusterrole_label node per set of up to this many of its labels it fulfills, and the number of such nodes grows
+// quickly with the number of labels.
+const MaxAggregationSelectorRequirements = 3
+
+// labelBootstrapping is set on all the default roles, and not used for aggregation, so it is not turned into tuples
+const labelBootstrapping = "kubernetes.io/bootstrapping"
+
+// ErrUnsupportedAggregationRule is returned for aggregation rules that cannot be expressed as tuples; the selectors
+// not supported select no clusterroles, that is, they grant less than RBAC would.
+var ErrUnsupportedAggregationRule = errors.New("unsupported aggregation rule")
+
+// A label requirement is either "key=value" for a label with the value, or "key" for a label with any value. Neither
+// label keys nor values can contain "=" or ",", so sets of requirements are sorted and joined by ",".
+const (
+	labelRequirementValueSeparator = "="
+	labelRequirementSetSeparator   = ","
+)
+
+// ValidateAggregationRule returns an error wrapping ErrUnsupportedAggregationRule for every selector of the aggregation
+// rule of the clusterrole that selects no clusterroles when converted to tuples, but would in RBAC.
+func ValidateAggregationRule(cr rbacv1.ClusterRole) error {
+	_, err := aggregationRuleLabelSets(cr.AggregationRule)
+	return err
+}
+
+// AggregationRuleSelects returns true if the aggregation rule selects a clusterrole with the labels, when converted to
+// tuples. The selectors not supported select nothing, see ValidateAggregationRule.
+func AggregationRuleSelects(rule *rbacv1.AggregationRule, labels map[string]string) bool {
+	labelSets, _ := aggregationRuleLabelSets(rule)
+	return util.Has(labelSets, func(labelSet string) bool {
+		return labelSetFulfilled(labelSet, labels)
+	})
+}
+
+// FilterSelectedLabelSets returns the tuples, except the ones of the label requirement sets that no aggregation rule of
+// the clusterroles selects. A clusterrole fulfills every set of up to MaxAggregationSelectorRequirements of its labels,
+// e.g. 378 sets for 7 labels, while only the few sets selected by aggregation rules are needed. Hence, the tuples of a
+// clusterrole depend on the aggregation rules of all clusterroles, and clusterroles being deleted select nothing.
+func FilterSelectedLabelSets(tuples []Tuple, clusterRoles []rbacv1.ClusterRole) []Tuple {
+	selected := sets.New[string]()
+	for _, cr := range clusterRoles {
+		if cr.DeletionTimestamp != nil {
+			continue
+		}
+		labelSets, _ := aggregationRuleLabelSets(cr.AggregationRule)
+		for _, labelSet := range labelSets {
+			selected.Insert(EscapedNode(TypeClusterRoleLabelAggregation, labelSet).NodeName())
+		}
+	}
+	return util.Filter(tuples, func(t Tuple) bool {
+		return t.User.NodeType() != TypeClusterRoleLabelAggregation || selected.Has(t.User.NodeName())
+	})
+}
+
+// FilterSelectedLabelSetsOf is FilterSelectedLabelSets for clusterroles as returned by e.g. meta.ExtractList.
+func FilterSelectedLabelSetsOf(clusterRoles []runtime.Object, tuples []Tuple) []Tuple {
+	casted := make([]rbacv1.ClusterRole, 0, len(clusterRoles))
+	for _, obj := range clusterRoles {
+		if cr, ok := zanzibar.Cast[rbacv1.ClusterRole](obj); ok {
+			casted = append(casted, cr)
+		}
+	}
+	return FilterSelectedLabelSets(tuples, casted)
+}
+
+// aggregationRuleLabelSets returns the label requirement sets of the clusterroles the aggregation rule selects, that
+// is, a clusterrole is selected if it fulfills any of the sets. Selectors that are not supported are skipped, and
+// returned as errors.
+func aggregationRuleLabelSets(rule *rbacv1.AggregationRule) ([]string, error) {
+	if rule == nil {
+		return nil, nil
+	}
+	result := sets.New[string]()
+	var errs []error
+	for i, selector := range rule.ClusterRoleSelectors {
+		labelSets, err := selectorLabelSets(selector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: clusterRoleSelectors[%d]: %w", ErrUnsupportedAggregationRule, i, err))
+			continue
+		}
+		result.Insert(labelSets...)
+	}
+	return sets.List(result), errors.Join(errs...)
+}
+
+// selectorLabelSets returns the label requirement sets of the label selector. All matchLabels and matchExpressions
+// must match, and In expressions match any of their values, so the sets are the cartesian product of the values.
+func selectorLabelSets(selector metav1.LabelSelector) ([]string, error) {
+	// matchLabels is a map of {key,value} pairs. A single {key,value} in the matchLabels map is equivalent to an element
+	// of matchExpressions, whose key field is "key", the operator is "In", and the values array contains only "value".
+	requirements := make([][]string, 0, len(selector.MatchLabels)+len(selector.MatchExpressions))
+	for _, key := range sets.List(sets.KeySet(selector.MatchLabels)) {
+		requirements = append(requirements, []string{key + labelRequirementValueSeparator + selector.MatchLabels[key]})
+	}
+	for _, expr := range selector.MatchExpressions {
+		if expr.Key == labelBootstrapping {
+			return nil, fmt.Errorf("the %s label is not converted into tuples", labelBootstrapping)
+		}
+		switch expr.Operator {
+		case metav1.LabelSelectorOpIn:
+			if len(expr.Values) == 0 {
+				return nil, fmt.Errorf("operator In of key %q requires values", expr.Key)
+			}
+			values := make([]string, 0, len(expr.Values))
+			for _, value := range expr.Values {
+				values = append(values, expr.Key+labelRequirementValueSeparator+value)
+			}
+			requirements = append(requirements, values)
+		case metav1.LabelSelectorOpExists:
+			requirements = append(requirements, []string{expr.Key})
+		default:
+			// NotIn and DoesNotExist would match clusterroles through labels they do not have
+			return nil, fmt.Errorf("operator %s of key %q is not supported", expr.Operator, expr.Key)
+		}
+	}
+	if _, ok := selector.MatchLabels[labelBootstrapping]; ok {
+		return nil, fmt.Errorf("the %s label is not converted into tuples", labelBootstrapping)
+	}
+	if len(requirements) == 0 {
+		return nil, errors.New("empty selectors select all clusterroles, which is not supported")
+	}
+
+	labelSets := [][]string{nil}
+	for _, alternatives := range requirements {
+		product := make([][]string, 0, len(labelSets)*len(alternatives))
+		for _, labelSet := range labelSets {
+			for _, requirement := range alternatives {
+				product = append(product, append(labelSet[:len(labelSet):len(labelSet)], requirement))
+			}
+		}
+		labelSets = product
+	}
+
+	result := make([]string, 0, len(labelSets))
+	for _, labelSet := range labelSets {
+		normalized, satisfiable := normalizeLabelSet(labelSet)
+		if !satisfiable {
+			continue
+		}
+		if len(normalized) > MaxAggregationSelectorRequirements {
+			return nil, fmt.Errorf("at most %d label keys can be required, got %d", MaxAggregationSelectorRequirements, len(normalized))
+		}
+		result = append(result, strings.Join(normalized, labelRequirementSetSeparator))
+	}
+	return result, nil
+}
+
+// normalizeLabelSet returns the sorted requirements of the set, with at most one requirement per key. False is returned
+// if no labels can fulfill the set, i.e. when it requires different values of the same key.
+func normalizeLabelSet(labelSet []string) ([]string, bool) {
+	byKey := map[string]string{}
+	for _, requirement := range labelSet {
+		key, _, hasValue := strings.Cut(requirement, labelRequirementValueSeparator)
+		existing, ok := byKey[key]
+		switch {
+		case !ok || existing == key:
+			// the key does not exist yet, or only required to exist
+			byKey[key] = requirement
+		case hasValue && existing != requirement:
+			return nil, false
+		}
+	}
+	normalized := make([]string, 0, len(byKey))
+	for _, requirement := range byKey {
+		normalized = append(normalized, requirement)
+	}
+	sort.Strings(normalized)
+	return normalized, true
+}
+
+// labelSetFulfilled returns true if the labels fulfill all requirements of the label requirement set
+func labelSetFulfilled(labelSet string, labels map[string]string) bool {
+	for _, requirement := range strings.Split(labelSet, labelRequirementSetSeparator) {
+		key, value, hasValue := strings.Cut(requirement, labelRequirementValueSeparator)
+		got, ok := labels[key]
+		if !ok || key == labelBootstrapping || (hasValue && got != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterRoleLabelSets returns all label requirement sets of up to MaxAggregationSelectorRequirements keys the labels
+// fulfill, such that the clusterrole is selected by all aggregation rules with any of those sets. Only the sets some
+// aggregation rule selects are written, see FilterSelectedLabelSets.
+func clusterRoleLabelSets(labels map[string]string) []string {
+	keys := sets.List(sets.KeySet(labels).Delete(labelBootstrapping))
+	result := []string{}
+	var add func(start int, labelSet []string)
+	add = func(start int, labelSet []string) {
+		if len(labelSet) == MaxAggregationSelectorRequirements {
+			return
+		}
+		for i := start; i < len(keys); i++ {
+			// the label fulfills both the requirement of its value, and of its key existing
+			for _, requirement := range []string{keys[i] + labelRequirementValueSeparator + labels[keys[i]], keys[i]} {
+				next := append(labelSet[:len(labelSet):len(labelSet)], requirement)
+				normalized, _ := normalizeLabelSet(next)
+				result = append(result, strings.Join(normalized, labelRequirementSetSeparator))
+				add(i+1, next)
+			}
+		}
+	}
+	add(0, nil)
+	sort.Strings(result)
+	return result
+}
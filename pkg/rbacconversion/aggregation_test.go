@@ -0,0 +1,249 @@
+package rbacconversion_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func aggregatingClusterRole(name string, selectors ...metav1.LabelSelector) rbacv1.ClusterRole {
+	return rbacv1.ClusterRole{
+		ObjectMeta:      metav1.ObjectMeta{Name: name},
+		AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: selectors},
+	}
+}
+
+func labeledClusterRole(name string, labels map[string]string) rbacv1.ClusterRole {
+	return rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func clusterRoleBinding(user, clusterRole string) rbacv1.ClusterRoleBinding {
+	return rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: user + "-" + clusterRole},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: rbacconversion.KindClusterRole, Name: clusterRole},
+		Subjects:   []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: user}},
+	}
+}
+
+func Test_converter_AggregationRules(t *testing.T) {
+	clusterRoles := []rbacv1.ClusterRole{
+		aggregatingClusterRole("match-labels", metav1.LabelSelector{
+			MatchLabels: map[string]string{"a": "1", "b": "2"},
+		}),
+		aggregatingClusterRole("match-expressions", metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "c", Operator: metav1.LabelSelectorOpIn, Values: []string{"x", "y"}},
+				{Key: "d", Operator: metav1.LabelSelectorOpExists},
+			},
+		}),
+		aggregatingClusterRole("any-selector",
+			metav1.LabelSelector{MatchLabels: map[string]string{"e": "1"}},
+			metav1.LabelSelector{MatchLabels: map[string]string{"f": "1"}},
+		),
+		aggregatingClusterRole("not-in", metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "a", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"2"}},
+			},
+		}),
+		labeledClusterRole("a1-b2", map[string]string{"a": "1", "b": "2"}),
+		labeledClusterRole("a1-b2-c3", map[string]string{"a": "1", "b": "2", "c": "3"}),
+		labeledClusterRole("a1", map[string]string{"a": "1"}),
+		labeledClusterRole("a1-b3", map[string]string{"a": "1", "b": "3"}),
+		labeledClusterRole("cy-d", map[string]string{"c": "y", "d": "anything"}),
+		labeledClusterRole("cx", map[string]string{"c": "x"}),
+		labeledClusterRole("cz-d", map[string]string{"c": "z", "d": "1"}),
+		labeledClusterRole("a1-d1", map[string]string{"a": "1", "d": "1"}),
+		labeledClusterRole("f1", map[string]string{"f": "1"}),
+	}
+	clusterRoleBindings := []rbacv1.ClusterRoleBinding{
+		clusterRoleBinding("labels", "match-labels"),
+		clusterRoleBinding("expressions", "match-expressions"),
+		clusterRoleBinding("any", "any-selector"),
+		clusterRoleBinding("not-in", "not-in"),
+		// selecting a and d through different clusterroles does not select a1-d1
+		clusterRoleBinding("both", "match-labels"),
+		clusterRoleBinding("both", "match-expressions"),
+	}
+
+	ctx := context.Background()
+	gc := &rbacconversion.GenericConverter{}
+	backend := inmemory.NewTupleStoreAndChecker(rbacconversion.GetSchema())
+	tuples := []Tuple{}
+	for _, cr := range clusterRoles {
+		t.Logf("ClusterRole %s: ValidateAggregationRule() = %v", cr.Name, rbacconversion.ValidateAggregationRule(cr))
+		crTuples, err := gc.ConvertClusterRoleToTuples(ctx, cr)
+		if err != nil {
+			t.Fatalf("GenericConverter.ConvertClusterRoleToTuples() error = %v", err)
+		}
+		// only the label sets selected by the aggregation rules are needed
+		tuples = append(tuples, rbacconversion.FilterSelectedLabelSets(crTuples, clusterRoles)...)
+	}
+	for _, crb := range clusterRoleBindings {
+		crbTuples, err := gc.ConvertClusterRoleBindingToTuples(ctx, crb)
+		if err != nil {
+			t.Fatalf("GenericConverter.ConvertClusterRoleBindingToTuples() error = %v", err)
+		}
+		tuples = append(tuples, crbTuples...)
+	}
+	if _, err := backend.WriteTuples(ctx, tuples, nil); err != nil {
+		t.Fatalf("backend.WriteTuples() error = %v", err)
+	}
+
+	tests := []struct {
+		user       string
+		wantRoles  []string
+		otherRoles []string
+	}{
+		{
+			user:       "labels",
+			wantRoles:  []string{"a1-b2", "a1-b2-c3"},
+			otherRoles: []string{"a1", "a1-b3", "cy-d", "a1-d1"},
+		},
+		{
+			user:       "expressions",
+			wantRoles:  []string{"cy-d"},
+			otherRoles: []string{"cx", "cz-d", "a1-d1"},
+		},
+		{
+			user:       "any",
+			wantRoles:  []string{"f1"},
+			otherRoles: []string{"a1"},
+		},
+		{
+			user:       "not-in",
+			otherRoles: []string{"a1", "a1-b2", "a1-b3", "cy-d"},
+		},
+		{
+			user:       "both",
+			wantRoles:  []string{"a1-b2", "cy-d"},
+			otherRoles: []string{"a1-d1", "a1", "cx"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			for _, roles := range []struct {
+				names []string
+				want  bool
+			}{{tt.wantRoles, true}, {tt.otherRoles, false}} {
+				for _, role := range roles.names {
+					tuple := rbacconversion.UserNode(tt.user).WithRelation(rbacconversion.RelationClusterRoleAssignee).ToOne(rbacconversion.ClusterRoleNode(role))
+					got, err := backend.CheckOne(ctx, tuple, nil)
+					if err != nil {
+						t.Fatalf("backend.CheckOne(%s) error = %v", tuple, err)
+					}
+					if got != roles.want {
+						t.Errorf("backend.CheckOne(%s) = %v, want %v", tuple, got, roles.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestValidateAggregationRule(t *testing.T) {
+	tooManyKeys := metav1.LabelSelector{MatchLabels: map[string]string{}}
+	for i := 0; i <= rbacconversion.MaxAggregationSelectorRequirements; i++ {
+		tooManyKeys.MatchLabels[strings.Repeat("k", i+1)] = "true"
+	}
+
+	tests := []struct {
+		name      string
+		selectors []metav1.LabelSelector
+		wantErr   bool
+	}{
+		{
+			name: "matchLabels and matchExpressions",
+			selectors: []metav1.LabelSelector{{
+				MatchLabels: map[string]string{"a": "1"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "b", Operator: metav1.LabelSelectorOpIn, Values: []string{"1", "2"}},
+					{Key: "c", Operator: metav1.LabelSelectorOpExists},
+				},
+			}},
+		},
+		{
+			name: "requirements of the same key count once",
+			selectors: []metav1.LabelSelector{{
+				MatchLabels: map[string]string{"a": "1", "b": "1", "c": "1"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "a", Operator: metav1.LabelSelectorOpExists},
+				},
+			}},
+		},
+		{
+			name:      "empty selector",
+			selectors: []metav1.LabelSelector{{}},
+			wantErr:   true,
+		},
+		{
+			name:      "too many keys",
+			selectors: []metav1.LabelSelector{tooManyKeys},
+			wantErr:   true,
+		},
+		{
+			name: "NotIn",
+			selectors: []metav1.LabelSelector{{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "a", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"1"}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "DoesNotExist",
+			selectors: []metav1.LabelSelector{{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "a", Operator: metav1.LabelSelectorOpDoesNotExist},
+			}}},
+			wantErr: true,
+		},
+		{
+			name:      "bootstrapping label",
+			selectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"kubernetes.io/bootstrapping": "rbac-defaults"}}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rbacconversion.ValidateAggregationRule(aggregatingClusterRole("foo", tt.selectors...))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAggregationRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, rbacconversion.ErrUnsupportedAggregationRule) {
+				t.Errorf("ValidateAggregationRule() error = %v, want ErrUnsupportedAggregationRule", err)
+			}
+		})
+	}
+}
+
+func TestAggregationRuleSelects(t *testing.T) {
+	rule := aggregatingClusterRole("foo",
+		metav1.LabelSelector{MatchLabels: map[string]string{"a": "1", "b": "2"}},
+		metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "c", Operator: metav1.LabelSelectorOpExists},
+		}},
+		metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "d", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"1"}},
+		}},
+	).AggregationRule
+
+	tests := []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{labels: map[string]string{"a": "1", "b": "2", "e": "1"}, want: true},
+		{labels: map[string]string{"a": "1", "b": "3"}},
+		{labels: map[string]string{"c": ""}, want: true},
+		// the unsupported selector selects nothing
+		{labels: map[string]string{"d": "2"}},
+		{},
+	}
+	for _, tt := range tests {
+		if got := rbacconversion.AggregationRuleSelects(rule, tt.labels); got != tt.want {
+			t.Errorf("AggregationRuleSelects(%v) = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+}
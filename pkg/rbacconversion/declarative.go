@@ -2,14 +2,11 @@ package rbacconversion
 
 import (
 	"context"
-	"fmt"
-	"net/url"
 	"strings"
 
 	"github.com/luxas/kube-rebac-authorizer/pkg/util"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
 	rbacv1 "k8s.io/api/rbac/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/authentication/serviceaccount"
 )
@@ -18,19 +15,25 @@ type GenericConverter struct {
 }
 
 func (GenericConverter) ConvertClusterRoleBindingToTuples(ctx context.Context, clusterrolebinding rbacv1.ClusterRoleBinding) ([]Tuple, error) {
-	return zanzibar.GenerateTuplesFor(GetSchema().Types[0], clusterrolebinding)
+	return generateTuplesFor(0, clusterrolebinding)
 }
 
 func (GenericConverter) ConvertRoleBindingToTuples(ctx context.Context, rolebinding rbacv1.RoleBinding) ([]Tuple, error) {
-	return zanzibar.GenerateTuplesFor(GetSchema().Types[1], rolebinding)
+	return generateTuplesFor(1, rolebinding)
 }
 
 func (GenericConverter) ConvertRoleToTuples(ctx context.Context, role rbacv1.Role) ([]Tuple, error) {
-	return zanzibar.GenerateTuplesFor(GetSchema().Types[2], role)
+	return generateTuplesFor(2, role)
 }
 
 func (GenericConverter) ConvertClusterRoleToTuples(ctx context.Context, clusterrole rbacv1.ClusterRole) ([]zanzibar.Tuple, error) {
-	return zanzibar.GenerateTuplesFor(GetSchema().Types[3], clusterrole)
+	return generateTuplesFor(3, clusterrole)
+}
+
+// generateTuplesFor generates the tuples of obj, of the i-th type of the schema
+func generateTuplesFor(i int, obj any) ([]Tuple, error) {
+	as := GetSchema()
+	return zanzibar.GenerateTuplesFor(&as, as.Types[i], obj)
 }
 
 func GetSchema() zanzibar.AuthorizationSchema {
@@ -42,7 +45,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 				IDExpr: zanzibar.CastIDExpr(func(crb rbacv1.ClusterRoleBinding) (string, error) {
 					return crb.Name, nil
 				}),
-				EscapeID: true,
+				IDCodec: clusterRoleBindingIDs,
 				Incoming: []zanzibar.IncomingRelation{
 					{
 						UserType: TypeUser,
@@ -96,9 +99,9 @@ func GetSchema() zanzibar.AuthorizationSchema {
 				TypeName:  TypeNamespacedRoleBinding,
 				Condition: castCondition(roleBindingCondition),
 				IDExpr: zanzibar.CastIDExpr(func(nrb rbacv1.RoleBinding) (string, error) {
-					return namespacedEscapedID(nrb.Namespace, nrb.Name), nil
+					return nrb.Namespace + "/" + nrb.Name, nil
 				}),
-				EscapeID: false,
+				IDCodec: roleBindingIDs,
 				Incoming: []zanzibar.IncomingRelation{
 					{
 						UserType: TypeUser,
@@ -144,26 +147,53 @@ func GetSchema() zanzibar.AuthorizationSchema {
 						}),
 						EscapeID: true,
 					},
+					{
+						UserType:  TypeNamespace,
+						Relation:  RelationNamespaceContainsRoleBinding,
+						Condition: castCondition(referencesClusterRole),
+
+						UserIDExpr: zanzibar.CastIncoming(func(nrb rbacv1.RoleBinding) ([]string, error) {
+							return []string{nrb.Namespace}, nil
+						}),
+						EscapeID: true,
+					},
 				},
 				Outgoing: []zanzibar.OutgoingRelation{
 					{
 						UserSetRelation: RelationNamespacedRoleNamespacedAssignee,
 						Relations:       []string{RelationNamespacedRoleNamespacedAssignee},
+						Condition: castCondition(func(nrb rbacv1.RoleBinding) bool {
+							return !referencesClusterRole(nrb)
+						}),
 
 						ObjectType: TypeNamespacedRole,
 						ObjectIDExpr: zanzibar.CastOutgoing(func(nrb rbacv1.RoleBinding, _ string) ([]string, error) {
-							return []string{namespacedEscapedID(nrb.Namespace, nrb.RoleRef.Name)}, nil
+							return []string{roleIDs.Encode(nrb.Namespace, nrb.RoleRef.Name)}, nil
 						}),
-						EscapeID: false,
 					},
+					{
+						// the clusterrole only applies in the namespace of the rolebinding
+						UserSetRelation: RelationNamespacedRoleBindingAssignee,
+						Relations:       []string{RelationClusterRoleAssignee},
+						Condition:       castCondition(referencesClusterRole),
+
+						ObjectType: TypeClusterRole,
+						ObjectIDExpr: zanzibar.CastOutgoing(func(nrb rbacv1.RoleBinding, _ string) ([]string, error) {
+							return []string{nrb.RoleRef.Name}, nil
+						}),
+						EscapeID: true,
+					},
+				},
+				EvaluatedUsersets: map[string]zanzibar.EvaluatedUserset{
+					RelationNamespacedRoleBindingAssignee: inContainingNamespace(RelationNamespacedRoleNamespacedAssignee, RelationNamespaceContainsRoleBinding),
 				},
 			},
 			{
 				TypeName: TypeNamespacedRole,
 				IDExpr: zanzibar.CastIDExpr(func(nr rbacv1.Role) (string, error) {
-					return namespacedEscapedID(nr.Namespace, nr.Name), nil
+					return nr.Namespace + "/" + nr.Name, nil
 				}),
-				EscapeID: false,
+				IDCodec: roleIDs,
 				Incoming: []zanzibar.IncomingRelation{
 					{
 						UserType: TypeNamespace,
@@ -221,7 +251,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 									}
 									return util.FlatMap(pr.Resources, func(resource string) []string {
 										return util.Map(pr.ResourceNames, func(resourceName string) string {
-											return apiGroup + "." + resource + "/" + url.QueryEscape(resourceName)
+											return resourceInstanceIDs.Encode(apiGroup+"."+resource, resourceName)
 										})
 									})
 								})
@@ -231,19 +261,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 					},
 				},
 				EvaluatedUsersets: map[string]zanzibar.EvaluatedUserset{
-					RelationNamespacedRoleAssignee: {
-						Intersection: []zanzibar.EvaluatedUserset{
-							{
-								Relation: RelationNamespacedRoleNamespacedAssignee,
-							},
-							{
-								TupleToUserset: &zanzibar.TupleToUserset{
-									ReferencedRelation: ContextualRelationOperatesInNamespace,
-									FromRelation:       RelationNamespaceContainsRole,
-								},
-							},
-						},
-					},
+					RelationNamespacedRoleAssignee: inContainingNamespace(RelationNamespacedRoleNamespacedAssignee, RelationNamespaceContainsRole),
 				},
 			},
 			{
@@ -251,7 +269,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 				IDExpr: zanzibar.CastIDExpr(func(cr rbacv1.ClusterRole) (string, error) {
 					return cr.Name, nil
 				}),
-				EscapeID: true,
+				IDCodec: clusterRoleIDs,
 				Incoming: []zanzibar.IncomingRelation{
 					{
 						UserType:        TypeClusterRoleLabelAggregation,
@@ -259,19 +277,11 @@ func GetSchema() zanzibar.AuthorizationSchema {
 						Relation:        RelationClusterRoleAssignee,
 
 						UserIDExpr: zanzibar.CastIncoming(func(cr rbacv1.ClusterRole) ([]string, error) {
-							returned := []string{}
-							for k, v := range cr.Labels {
-								if k == "kubernetes.io/bootstrapping" {
-									continue
-								}
-								// specifies the relation when e.g. "clusterrole_label:aggregate-to-edit=true#selected assignee clusterrole:my-aggregated-edit-role" and
-								// the "exists" variant for labelexpressions "clusterrole_label:aggregate-to-edit#selected assignee clusterrole:my-aggregated-edit-role"
-								returned = append(returned, k)
-								returned = append(returned, k+"="+v)
-							}
-							return returned, nil
+							// specifies the relation when e.g. "clusterrole_label:aggregate-to-edit=true#selects assignee clusterrole:my-aggregated-edit-role",
+							// for every set of labels aggregation rules can select the clusterrole by
+							return clusterRoleLabelSets(cr.Labels), nil
 						}),
-						EscapeID: false,
+						EscapeID: true,
 					},
 				},
 				Outgoing: []zanzibar.OutgoingRelation{
@@ -283,41 +293,12 @@ func GetSchema() zanzibar.AuthorizationSchema {
 							return cr.AggregationRule != nil
 						}),
 						ObjectIDExpr: zanzibar.CastOutgoing(func(cr rbacv1.ClusterRole, _ string) ([]string, error) {
-							returned := []string{}
-							for _, selector := range cr.AggregationRule.ClusterRoleSelectors {
-
-								// Convert any MatchLabels into the more generic MatchExpressions, according to the MatchLabels godoc:
-								// "matchLabels is a map of {key,value} pairs. A single {key,value} in the matchLabels map is equivalent to an element of matchExpressions,
-								// whose key field is "key", the operator is "In", and the values array contains only "value". The requirements are ANDed. +optional"
-								for k, v := range selector.MatchLabels {
-									selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
-										Key:      k,
-										Operator: metav1.LabelSelectorOpIn,
-										Values:   []string{v},
-									})
-								}
-
-								if len(selector.MatchExpressions) == 1 {
-									expr := selector.MatchExpressions[0]
-									// TODO: Should the key and key-value be separate relations?
-									if expr.Operator == metav1.LabelSelectorOpIn {
-										for _, val := range expr.Values {
-											// all assignees of this cluster role now select the clusterrole aggregation label
-											returned = append(returned, expr.Key+"="+val)
-										}
-									} else if expr.Operator == metav1.LabelSelectorOpExists {
-										// all assignees of this cluster role now select the clusterrole aggregation label
-										returned = append(returned, expr.Key)
-									} else {
-										fmt.Println("Unsupported label expression operator!") // TODO
-									}
-								} else if len(selector.MatchExpressions) > 0 {
-									// We cannot perform AND expressions in OpenFGA, yet at least.
-									fmt.Println("Cannot handle MatchExpressions!") // TODO
-								}
-							}
-							return returned, nil
+							// all assignees of this cluster role now select the sets of labels of the aggregation rule. The selectors
+							// not supported select nothing, and are reported by ValidateAggregationRule
+							labelSets, _ := aggregationRuleLabelSets(cr.AggregationRule)
+							return labelSets, nil
 						}),
+						EscapeID: true,
 					},
 					{
 						UserSetRelation: RelationClusterRoleAssignee,
@@ -363,7 +344,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 									}
 									return util.FlatMap(pr.Resources, func(resource string) []string {
 										return util.Map(pr.ResourceNames, func(resourceName string) string {
-											return apiGroup + "." + resource + "/" + url.QueryEscape(resourceName)
+											return resourceInstanceIDs.Encode(apiGroup+"."+resource, resourceName)
 										})
 									})
 								})
@@ -372,7 +353,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 					},
 					{
 						UserSetRelation: RelationClusterRoleAssignee,
-						Relations:       []string{"get", "anyverb"},
+						Relations:       append(NonResourceRelations.UnsortedList(), RelationResourceAnyVerb),
 
 						ObjectType: TypeNonResource,
 						ObjectIDExpr: zanzibar.CastOutgoing(func(cr rbacv1.ClusterRole, relation string) ([]string, error) {
@@ -383,7 +364,8 @@ func GetSchema() zanzibar.AuthorizationSchema {
 								}
 								return sets.New(pr.Verbs...).Has(verb) // TODO: Filter for nonResourceURLs exclusitivity?
 							}), func(pr rbacv1.PolicyRule) []string {
-								return util.Map(pr.NonResourceURLs, func(nonResourceURL string) string {
+								// the non-resource URLs not supported are not converted, and are reported by ValidateNonResourceURLs
+								return util.Map(supportedNonResourceURLs(pr), func(nonResourceURL string) string {
 									if !strings.HasPrefix(nonResourceURL, "/") {
 										nonResourceURL = "/" + nonResourceURL
 									}
@@ -396,6 +378,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 			},
 			{
 				TypeName: TypeGroup,
+				IDCodec:  groupIDs,
 				Incoming: []zanzibar.IncomingRelation{
 					{
 						UserType: TypeUser,
@@ -438,6 +421,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 			},
 			{
 				TypeName: TypeResourceInstance,
+				IDCodec:  resourceInstanceIDs,
 				Incoming: []zanzibar.IncomingRelation{
 					{
 						UserType: TypeResource,
@@ -461,10 +445,7 @@ func GetSchema() zanzibar.AuthorizationSchema {
 						Relation: ContextualRelationWildcardMatch,
 					},
 				},
-				EvaluatedUsersets: map[string]zanzibar.EvaluatedUserset{
-					RelationResourceAnyVerb: orParent(RelationResourceAnyVerb, ContextualRelationWildcardMatch),
-					"get":                   orWildcardRelationOrParent("get", RelationResourceAnyVerb, ContextualRelationWildcardMatch),
-				},
+				EvaluatedUsersets: nonResourceUsersets(),
 			},
 		},
 	}
@@ -490,9 +471,38 @@ func orWildcardRelationOrParent(relationName, wildcardRelation, toParentRelation
 	}
 }
 
+// inContainingNamespace makes the users related through relation related only when operating in the namespace
+// containing the object, see ContextualRelationOperatesInNamespace
+func inContainingNamespace(relation, containsRelation string) zanzibar.EvaluatedUserset {
+	return zanzibar.EvaluatedUserset{
+		Intersection: []zanzibar.EvaluatedUserset{
+			{
+				Relation: relation,
+			},
+			{
+				TupleToUserset: &zanzibar.TupleToUserset{
+					ReferencedRelation: ContextualRelationOperatesInNamespace,
+					FromRelation:       containsRelation,
+				},
+			},
+		},
+	}
+}
+
+// nonResourceUsersets makes each non-resource verb allowed through the anyverb relation, or a matching wildcard rule
+func nonResourceUsersets() map[string]zanzibar.EvaluatedUserset {
+	usersets := map[string]zanzibar.EvaluatedUserset{
+		RelationResourceAnyVerb: orParent(RelationResourceAnyVerb, ContextualRelationWildcardMatch),
+	}
+	for verb := range NonResourceRelations {
+		usersets[verb] = orWildcardRelationOrParent(verb, RelationResourceAnyVerb, ContextualRelationWildcardMatch)
+	}
+	return usersets
+}
+
 func castCondition[T any](f func(obj T) bool) zanzibar.ConditionFunc {
 	return func(obj any) bool {
-		casted, ok := obj.(T)
+		casted, ok := zanzibar.Cast[T](obj)
 		if !ok {
 			return false
 		}
@@ -506,10 +516,6 @@ func castCondition[T any](f func(obj T) bool) zanzibar.ConditionFunc {
 	})
 }*/
 
-func namespacedEscapedID(namespace, name string) string {
-	return namespace + "/" + url.QueryEscape(name)
-}
-
 func clusterRoleBindingCondition(crb rbacv1.ClusterRoleBinding) bool {
 	// force the reference to be to an RBAC ClusterRole
 	if crb.RoleRef.APIGroup != rbacv1.GroupName {
@@ -522,17 +528,21 @@ func clusterRoleBindingCondition(crb rbacv1.ClusterRoleBinding) bool {
 }
 
 func roleBindingCondition(nrb rbacv1.RoleBinding) bool {
-	// force the reference to be to an RBAC Role (for now)
+	// force the reference to be to an RBAC Role or ClusterRole
 	if nrb.RoleRef.APIGroup != rbacv1.GroupName {
 		return false
 	}
-	// TODO: Assign a ClusterRole to a RoleBinding
-	if nrb.RoleRef.Kind != KindRole {
+	if nrb.RoleRef.Kind != KindRole && nrb.RoleRef.Kind != KindClusterRole {
 		return false
 	}
 	return len(nrb.RoleRef.Name) != 0 // Does RBAC enforce this?
 }
 
+// referencesClusterRole returns true if the rolebinding grants the rules of a clusterrole in its namespace
+func referencesClusterRole(nrb rbacv1.RoleBinding) bool {
+	return nrb.RoleRef.Kind == KindClusterRole
+}
+
 /*func rbacTypeName(kind string) string {
 	return convertGroupKindToTypeName(rbacv1.SchemeGroupVersion.WithKind(kind).GroupKind())
 }
@@ -0,0 +1,49 @@
+package rbacconversion
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AnnotationExpiresAt can be set on RoleBindings and ClusterRoleBindings to only grant access until
+// the given RFC 3339 timestamp, e.g. "2024-01-01T15:00:00Z", for example for temporary access during
+// incidents. The tuples of a binding do not depend on its expiry, such that they are the same whenever
+// they are generated. Instead, the binding syncers and the garbage collector treat expired bindings like
+// deleted ones, evaluating Expired at the time of the reconcile, and the syncers reconcile the binding
+// again when it expires, which removes its tuples from the store, see ExpiresIn.
+const AnnotationExpiresAt = "rebac.authorization.luxas.dev/expires-at"
+
+// ExpiresAt returns the expiry time of the object, or nil if it does not expire.
+func ExpiresAt(obj metav1.Object) (*time.Time, error) {
+	value, ok := obj.GetAnnotations()[AnnotationExpiresAt]
+	if !ok {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on %s: %w", AnnotationExpiresAt, obj.GetName(), err)
+	}
+	return &expiresAt, nil
+}
+
+// Expired returns true if the object has expired at the given time. Objects with an invalid expiry
+// are considered expired, such that a mistyped expiry cannot grant access forever.
+func Expired(obj metav1.Object, now time.Time) bool {
+	expiresAt, err := ExpiresAt(obj)
+	if err != nil {
+		return true
+	}
+	return expiresAt != nil && !now.Before(*expiresAt)
+}
+
+// ExpiresIn returns the duration from now until the object expires, or zero if the object does
+// not expire or has already expired. It is meant to be used as ctrl.Result.RequeueAfter.
+func ExpiresIn(obj metav1.Object, now time.Time) time.Duration {
+	expiresAt, err := ExpiresAt(obj)
+	if err != nil || expiresAt == nil || !now.Before(*expiresAt) {
+		return 0
+	}
+	return expiresAt.Sub(now)
+}
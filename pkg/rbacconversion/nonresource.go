@@ -0,0 +1,81 @@
+package rbacconversion
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// MaxNonResourceURLWildcardSegments is the maximum number of path segments before the trailing "*" of a non-resource
+// URL, e.g. 2 for "/debug/pprof/*". A check for a path has one wildcardmatch contextual tuple per supported prefix
+// of it, and together with the ones of the groups of the user, these must stay below the 20 contextual tuples
+// OpenFGA allows per check.
+const MaxNonResourceURLWildcardSegments = 3
+
+// ErrUnsupportedNonResourceURL is returned for non-resource URLs that cannot be expressed as tuples; they are not
+// converted, that is, they grant less than RBAC would.
+var ErrUnsupportedNonResourceURL = errors.New("unsupported non-resource URL")
+
+// ValidateNonResourceURLs returns an error wrapping ErrUnsupportedNonResourceURL for every non-resource URL of the
+// clusterrole that is not converted to tuples, but matches paths in RBAC.
+func ValidateNonResourceURLs(cr rbacv1.ClusterRole) error {
+	var errs []error
+	for i, rule := range cr.Rules {
+		for j, nonResourceURL := range rule.NonResourceURLs {
+			if err := validateNonResourceURL(nonResourceURL); err != nil {
+				errs = append(errs, fmt.Errorf("%w: rules[%d].nonResourceURLs[%d]: %w", ErrUnsupportedNonResourceURL, i, j, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// supportedNonResourceURLs returns the non-resource URLs of the policy rule that ValidateNonResourceURLs accepts.
+// Paths always start with "/", so URLs not starting with "/", except "*", match nothing in RBAC and are skipped too.
+func supportedNonResourceURLs(pr rbacv1.PolicyRule) []string {
+	return util.Filter(pr.NonResourceURLs, func(nonResourceURL string) bool {
+		return (nonResourceURL == rbacv1.NonResourceAll || strings.HasPrefix(nonResourceURL, "/")) &&
+			validateNonResourceURL(nonResourceURL) == nil
+	})
+}
+
+// validateNonResourceURL returns an error if the non-resource URL has a trailing "*" that NonResourceWildcardNodes
+// does not match. RBAC matches any path with the prefix before the "*", but only prefixes ending with "/" and with
+// at most MaxNonResourceURLWildcardSegments path segments are supported, e.g. "/healthz/*", but not "/healthz*".
+func validateNonResourceURL(nonResourceURL string) error {
+	prefix, ok := strings.CutSuffix(nonResourceURL, rbacv1.NonResourceAll)
+	if !ok {
+		// matches only the path itself
+		return nil
+	}
+	prefix = NonResourceNode(prefix).NodeName()
+	if !strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("%q: a trailing %q is only supported directly after a %q", nonResourceURL, rbacv1.NonResourceAll, "/")
+	}
+	if segments := strings.Count(prefix, "/") - 1; segments > MaxNonResourceURLWildcardSegments {
+		return fmt.Errorf("%q: a trailing %q is only supported after at most %d path segments, got %d", nonResourceURL, rbacv1.NonResourceAll, MaxNonResourceURLWildcardSegments, segments)
+	}
+	return nil
+}
+
+// NonResourceWildcardNodes returns the nodes of the non-resource URLs with a trailing "*" matching the path, see
+// rbacv1helpers.NonResourceURLMatches, out of the ones ValidateNonResourceURLs accepts. For "/apis/apps/v1", these
+// are "/*", "/apis/*", "/apis/apps/*" and "/apis/apps/v1/*". "*" matches all paths, just like "/*".
+func NonResourceWildcardNodes(path string) zanzibar.Nodes {
+	path = NonResourceNode(path).NodeName()
+	nodes := make([]zanzibar.Node, 0, MaxNonResourceURLWildcardSegments+1)
+	for i, c := range path {
+		if c != '/' {
+			continue
+		}
+		nodes = append(nodes, NonResourceNode(path[:i+1]+rbacv1.NonResourceAll))
+		if len(nodes) == MaxNonResourceURLWildcardSegments+1 {
+			break
+		}
+	}
+	return zanzibar.NewNodes(nodes...)
+}
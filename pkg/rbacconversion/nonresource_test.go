@@ -0,0 +1,114 @@
+package rbacconversion_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rbacv1helpers "k8s.io/kubernetes/pkg/apis/rbac/v1"
+)
+
+func nonResourceClusterRole(name string, nonResourceURLs ...string) rbacv1.ClusterRole {
+	return rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Rules:      []rbacv1.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: nonResourceURLs}},
+	}
+}
+
+func Test_converter_NonResourceURLs(t *testing.T) {
+	supported := []string{
+		"*", "/healthz", "/version/", "/apis/*", "/debug/pprof/*", "/a/b/c/*",
+		// matches nothing, as paths start with "/"
+		"livez/*",
+	}
+	unsupported := []string{"/ap*", "/metric*", "/healthz*", "/a/b/c/d/*"}
+	paths := []string{
+		"/", "/healthz", "/healthz/etcd", "/healthzfoo", "/version", "/version/", "/version/foo", "/apis", "/apis/",
+		"/apis/apps/v1", "/debug/pprof", "/debug/pprof/profile", "/a/b/c", "/a/b/c/d/e/f", "/livez/ping", "/metrics",
+	}
+
+	ctx := context.Background()
+	gc := &rbacconversion.GenericConverter{}
+	backend := inmemory.NewTupleStoreAndChecker(rbacconversion.GetSchema())
+	tuples := []Tuple{}
+	// the user user-i is bound to the clusterrole role-i, which allows the i:th non-resource URL
+	nonResourceURLs := append(supported, unsupported...)
+	for i, nonResourceURL := range nonResourceURLs {
+		cr := nonResourceClusterRole(fmt.Sprintf("role-%d", i), nonResourceURL)
+		crTuples, err := gc.ConvertClusterRoleToTuples(ctx, cr)
+		if err != nil {
+			t.Fatalf("GenericConverter.ConvertClusterRoleToTuples() error = %v", err)
+		}
+		crbTuples, err := gc.ConvertClusterRoleBindingToTuples(ctx, clusterRoleBinding(fmt.Sprintf("user-%d", i), cr.Name))
+		if err != nil {
+			t.Fatalf("GenericConverter.ConvertClusterRoleBindingToTuples() error = %v", err)
+		}
+		tuples = append(tuples, append(crTuples, crbTuples...)...)
+	}
+	if _, err := backend.WriteTuples(ctx, tuples, nil); err != nil {
+		t.Fatalf("backend.WriteTuples() error = %v", err)
+	}
+
+	for i, nonResourceURL := range nonResourceURLs {
+		t.Run(nonResourceURL, func(t *testing.T) {
+			rule := nonResourceClusterRole("", nonResourceURL).Rules[0]
+			for _, path := range paths {
+				checkNode := rbacconversion.NonResourceNode(path)
+				contextualTuples := rbacconversion.NonResourceWildcardNodes(path).
+					WithRelation(rbacconversion.ContextualRelationWildcardMatch).To(checkNode)
+				if len(contextualTuples) > rbacconversion.MaxNonResourceURLWildcardSegments+1 {
+					t.Errorf("NonResourceWildcardNodes(%s) = %d nodes, want at most %d", path, len(contextualTuples), rbacconversion.MaxNonResourceURLWildcardSegments+1)
+				}
+				tuple := rbacconversion.UserNode(fmt.Sprintf("user-%d", i)).WithRelation("get").ToOne(checkNode)
+				got, err := backend.CheckOne(ctx, tuple, contextualTuples)
+				if err != nil {
+					t.Fatalf("backend.CheckOne(%s) error = %v", tuple, err)
+				}
+				// the supported URLs match just like in RBAC, the unsupported ones match nothing
+				want := i < len(supported) && rbacv1helpers.NonResourceURLMatches(&rule, path)
+				if got != want {
+					t.Errorf("backend.CheckOne(%s) = %v, want %v", tuple, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateNonResourceURLs(t *testing.T) {
+	tests := []struct {
+		name            string
+		nonResourceURLs []string
+		wantErr         bool
+	}{
+		{
+			name:            "paths and wildcards after a path segment",
+			nonResourceURLs: []string{"*", "/*", "/healthz", "/healthz/*", "/debug/pprof/*", "/a/b/c/*", "/a*b"},
+		},
+		{
+			name:            "wildcard within a path segment",
+			nonResourceURLs: []string{"/healthz", "/heal*"},
+			wantErr:         true,
+		},
+		{
+			name:            "wildcard after too many path segments",
+			nonResourceURLs: []string{"/a/b/c/d/*"},
+			wantErr:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rbacconversion.ValidateNonResourceURLs(nonResourceClusterRole("foo", tt.nonResourceURLs...))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNonResourceURLs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, rbacconversion.ErrUnsupportedNonResourceURL) {
+				t.Errorf("ValidateNonResourceURLs() error = %v, want ErrUnsupportedNonResourceURL", err)
+			}
+		})
+	}
+}
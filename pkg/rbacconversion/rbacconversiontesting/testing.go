@@ -6,9 +6,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"os"
+	"sort"
 	"sync"
 	"testing"
 
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
 	"github.com/luxas/kube-rebac-authorizer/pkg/openfga"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
 	"github.com/luxas/kube-rebac-authorizer/pkg/util"
@@ -141,22 +143,31 @@ func GetRoleBinding(name string) rbacv1.RoleBinding {
 	return rbacv1.RoleBinding{}
 }
 
-// SetupIntegrationTest reads the common testdata, converts RBAC into tuples, connects to
-// either localhost:8081, or sets up an in-memory server (depending on callClient), then
-// initializes the store, and writes all the tuples. The function returned should be ran deferred
-// by the caller, for outputting debug information for failed tests.
-// TODO: These now require OpenFGA to be serving on localhost:8081
-func SetupIntegrationTest(ctx context.Context, t *testing.T) (debug func(), openfgaimpl *openfga.TupleStoreAndChecker) {
-	// always set debug to avoid panics
-	debug = func() {}
+// Backend is a tuple store that also can answer check requests, e.g. the OpenFGA or in-memory implementations.
+type Backend interface {
+	zanzibar.TupleStore
+	zanzibar.Checker
+}
+
+// OpenFGAAddressEnvVar can be set to the gRPC address of an OpenFGA server, e.g. "localhost:8081", to run the
+// integration tests against OpenFGA instead of the in-memory engine.
+const OpenFGAAddressEnvVar = "REBAC_TEST_OPENFGA_ADDRESS"
 
-	clusterRoleNames := []string{
+// TestdataTuplesFile is the OpenFGA CLI store file with the RBAC authorization model, and the tuples
+// ConvertTestdata returns. It is relative to the pkg/* directories, and regenerated by running
+// "go test ./pkg/rbacconversion -update".
+const TestdataTuplesFile = "../testdata/rbac-tuples.fga.yaml"
+
+var (
+	testdataClusterRoleNames = []string{
 		"cluster-admin",
 		"system:kube-controller-manager",
 		"admin",
 		"edit",
 		"view",
 		"system:discovery",
+		"system:public-info-viewer",
+		"system:monitoring",
 		"system:basic-user",
 		"system:kube-dns",
 		"system:aggregate-to-view",
@@ -164,118 +175,150 @@ func SetupIntegrationTest(ctx context.Context, t *testing.T) (debug func(), open
 		"system:aggregate-to-admin",
 	}
 
-	namespacedRoleNames := []string{
+	testdataRoleNames = []string{
 		"system:controller:bootstrap-signer",
 		"extension-apiserver-authentication-reader",
 		"system::leader-locking-kube-controller-manager",
 	}
 
-	clusterRoleBindingNames := []string{
+	testdataClusterRoleBindingNames = []string{
 		"cluster-admin",
 		"system:basic-user",
 		"system:kube-controller-manager",
 		"system:kube-dns",
+		"system:discovery",
 		"system:public-info-viewer",
+		"system:monitoring",
 		"test:user-view",
 		"test:user-admin",
 	}
 
-	namespacedRoleBindingNames := []string{
+	testdataRoleBindingNames = []string{
 		"system:controller:bootstrap-signer",
 		"system::extension-apiserver-authentication-reader",
 		"system::leader-locking-kube-controller-manager",
+		"test:user-edit",
+		"test:group-view",
 	}
+)
 
+// ConvertTestdata converts the RBAC objects used by the integration tests into sorted tuples.
+func ConvertTestdata(ctx context.Context) (zanzibar.Tuples, error) {
 	c := &rbacconversion.GenericConverter{}
+	tuples := zanzibar.Tuples{}
+
+	clusterRoles := util.Map(testdataClusterRoleNames, GetClusterRole)
+	for _, cr := range clusterRoles {
+		t, err := c.ConvertClusterRoleToTuples(ctx, cr)
+		if err != nil {
+			return nil, err
+		}
+		// like the clusterrole syncer, only write the label sets the aggregation rules select
+		tuples = append(tuples, rbacconversion.FilterSelectedLabelSets(t, clusterRoles)...)
+	}
+	for _, crb := range util.Map(testdataClusterRoleBindingNames, GetClusterRoleBinding) {
+		t, err := c.ConvertClusterRoleBindingToTuples(ctx, crb)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, t...)
+	}
+	for _, r := range util.Map(testdataRoleNames, GetRole) {
+		t, err := c.ConvertRoleToTuples(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, t...)
+	}
+	for _, rb := range util.Map(testdataRoleBindingNames, GetRoleBinding) {
+		t, err := c.ConvertRoleBindingToTuples(ctx, rb)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, t...)
+	}
+	sort.Sort(tuples)
+	return tuples, nil
+}
 
-	crTuples := util.FlatMap(util.Map(clusterRoleNames, GetClusterRole), func(item rbacv1.ClusterRole) []zanzibar.Tuple {
-		return util.Must(c.ConvertClusterRoleToTuples(ctx, item))
-	})
+// SetupIntegrationTest reads the tuples from TestdataTuplesFile, and either uses the in-memory engine, or
+// connects to OpenFGA if OpenFGAAddressEnvVar is set, then initializes the store, and writes all the
+// tuples. The function returned should be ran deferred by the caller, for outputting debug information for
+// failed tests.
+func SetupIntegrationTest(ctx context.Context, t *testing.T) (debug func(), backend Backend) {
+	// always set debug to avoid panics
+	debug = func() {}
+
+	f, err := os.Open(TestdataTuplesFile)
+	if err != nil {
+		t.Errorf("os.Open() error = %v", err)
+		return debug, nil
+	}
+	defer f.Close()
+	tuples, err := zanzibar.DecodeTuples(f, zanzibar.TupleFormatStoreFile)
+	if err != nil {
+		t.Errorf("zanzibar.DecodeTuples() error = %v", err)
+		return debug, nil
+	}
 
-	crbTuples := util.FlatMap(util.Map(clusterRoleBindingNames, GetClusterRoleBinding), func(item rbacv1.ClusterRoleBinding) []zanzibar.Tuple {
-		return util.Must(c.ConvertClusterRoleBindingToTuples(ctx, item))
-	})
+	storeID := "in-memory"
+	debug = func() {
+		if t.Failed() {
+			t.Logf("StoreID:%s\nTuples (from %s):\n%s", storeID, TestdataTuplesFile, tuples)
+		}
+	}
 
-	nrTuples := util.FlatMap(util.Map(namespacedRoleNames, GetRole), func(item rbacv1.Role) []zanzibar.Tuple {
-		return util.Must(c.ConvertRoleToTuples(ctx, item))
-	})
+	if openfgaAddress := os.Getenv(OpenFGAAddressEnvVar); len(openfgaAddress) != 0 {
+		openfgaimpl := setupOpenFGA(ctx, t, openfgaAddress)
+		if openfgaimpl == nil {
+			return debug, nil
+		}
+		storeID = openfgaimpl.StoreID()
+		backend = openfgaimpl
+	} else {
+		backend = inmemory.NewTupleStoreAndChecker(rbacconversion.GetSchema())
+	}
 
-	nrbTuples := util.FlatMap(util.Map(namespacedRoleBindingNames, GetRoleBinding), func(item rbacv1.RoleBinding) []zanzibar.Tuple {
-		return util.Must(c.ConvertRoleBindingToTuples(ctx, item))
-	})
+	if _, err := backend.WriteTuples(ctx, tuples, nil); err != nil {
+		t.Errorf("backend.WriteTuples() error = %v", err)
+		return debug, nil
+	}
 
-	openfgaAddress := "localhost:8081" // default docker: docker run -p 8080:8080 -p 8081:8081 -p 3000:3000 openfga/openfga run
+	return debug, backend
+}
 
+// setupOpenFGA connects to the given OpenFGA server, and creates a new store with a random name and the
+// RBAC authorization schema.
+func setupOpenFGA(ctx context.Context, t *testing.T, openfgaAddress string) *openfga.TupleStoreAndChecker {
 	// TODO: support secure connection
 	cc, err := grpc.DialContext(ctx, openfgaAddress, grpc.WithTransportCredentials(insecure.NewCredentials())) // TODO: options?
 	if err != nil {
 		t.Errorf("grpc.DialContext() error = %v", err)
-
-		return
-	} // TODO: Else: run an in-memory server as an option as well
+		return nil
+	}
 
 	storeagnosticclient := openfga.NewStoreAgnosticClient(cc)
 
-	debug = func() {
-		if t.Failed() {
-			t.Logf(
-				"StoreID:%s\nClusterRoles:\n%s\n---\nClusterRoleBindings:\n%s\n---\nRoles:\n%s\n---\nRoleBindings:\n%s\n---\n",
-				openfgaimpl.StoreID(),
-				zanzibar.PrintTuples(crTuples),
-				zanzibar.PrintTuples(crbTuples),
-				zanzibar.PrintTuples(nrTuples),
-				zanzibar.PrintTuples(nrbTuples),
-			)
-		}
+	// Generate a random store name as we want a completely new store
+	// TODO: Make an util func for this
+	input := make([]byte, 16)
+	if _, err := rand.Read(input); err != nil {
+		t.Error("rand.Read", err)
+		return nil
 	}
+	shasum := sha256.Sum256(input)
+	storeName := hex.EncodeToString(shasum[:])
 
-	storeName := "default"
-	createNew := true
-	if createNew {
-		// Generate a random store name if we want a completely new store
-		// TODO: Make an util func for this
-		input := make([]byte, 16)
-		_, err := rand.Read(input)
-		if err != nil {
-			t.Error("rand.Read", err)
-			return
-		}
-		shasum := sha256.Sum256(input)
-		storeName = hex.EncodeToString(shasum[:])
-	}
 	am, err := storeagnosticclient.WithStore(ctx, storeName)
 	if err != nil {
 		t.Errorf("storeagnosticclient.WithStore() error = %v", err)
-		return
+		return nil
 	}
 
-	openfgaimpl, err = am.WithAuthorizationSchema(ctx, rbacconversion.GetSchema())
+	openfgaimpl, err := am.WithAuthorizationSchema(ctx, rbacconversion.GetSchema())
 	if err != nil {
 		t.Errorf("am.WithAuthorizationSchema() error = %v", err)
-		return
-	}
-
-	if createNew {
-		if err := openfgaimpl.WriteTuples(ctx, crTuples, nil); err != nil {
-			t.Errorf("openfgaimpl.WriteTuples(crTuples) error = %v", err)
-			return
-		}
-
-		if err := openfgaimpl.WriteTuples(ctx, crbTuples, nil); err != nil {
-			t.Errorf("openfgaimpl.WriteTuples(crbTuples) error = %v", err)
-			return
-		}
-
-		if err := openfgaimpl.WriteTuples(ctx, nrTuples, nil); err != nil {
-			t.Errorf("openfgaimpl.WriteTuples(nrTuples) error = %v", err)
-			return
-		}
-
-		if err := openfgaimpl.WriteTuples(ctx, nrbTuples, nil); err != nil {
-			t.Errorf("openfgaimpl.WriteTuples(nrbTuples) error = %v", err)
-			return
-		}
+		return nil
 	}
-
-	return debug, openfgaimpl
+	return openfgaimpl
 }
@@ -2,7 +2,6 @@ package rbacconversion
 
 import (
 	"context"
-	"net/url"
 	"strings"
 
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
@@ -14,7 +13,7 @@ import (
 	Design tradeoffs:
 	- We cannot support all verbs, only the common "de-facto ones"
 	- We don't (by design) allow negative label selectors (such as label not exists, or label does not have value in set), as that is a very wide-open policy
-	- We cannot (at least for now) support more than one label selector
+	- Label selectors of aggregation rules can require at most MaxAggregationSelectorRequirements label keys
 */
 
 /*
@@ -59,8 +58,10 @@ type Tuple = zanzibar.Tuple
 type RBACTupleConverter interface {
 	// ConvertClusterRoleToTuples produces Tuples related to the given ClusterRole. The tuples are produced
 	// as following:
-	// - One incoming Tuple from clusterrole_label#selected through the assignee relation per label
-	// - One outgoing Tuple from clusterrole#assignee to the clusterrole_label type through the selected relation
+	// - One incoming Tuple from clusterrole_label#selects through the assignee relation per set of up to
+	//   MaxAggregationSelectorRequirements labels
+	// - One outgoing Tuple from clusterrole#assignee to the clusterrole_label type through the selects relation
+	//   per set of labels the aggregation rule selects
 	// - Per Resource Rule OR
 	//    - len(apiGroups)*len(verbs)*len(resources) outgoing tuples from clusterrole#assignee to resource type
 	//      through the relation related to the verb, OR
@@ -114,28 +115,39 @@ func TypedNode(typeName, instanceName string) zanzibar.Node {
 	return zanzibar.NewNode(typeName, instanceName)
 }
 
+// The codecs of the node IDs of the types with names that need escaping, like RBAC names. The same
+// codecs are set as IDCodec in the schema, such that the IDs can be decoded given the schema.
+var (
+	userIDs               = zanzibar.NewNodeIDCodec(TypeUser)
+	groupIDs              = zanzibar.NewNodeIDCodec(TypeGroup)
+	clusterRoleIDs        = zanzibar.NewNodeIDCodec(TypeClusterRole)
+	clusterRoleBindingIDs = zanzibar.NewNodeIDCodec(TypeClusterRoleBinding)
+	roleIDs               = zanzibar.NewNodeIDCodec(TypeNamespacedRole)
+	roleBindingIDs        = zanzibar.NewNodeIDCodec(TypeNamespacedRoleBinding)
+	resourceInstanceIDs   = zanzibar.NewNodeIDCodec(TypeResourceInstance)
+)
+
 // EscapedNode escapes the instance name for resources with sloppy specifications; like RBAC names
 func EscapedNode(typeName, instanceName string) zanzibar.Node {
-	return TypedNode(typeName, url.QueryEscape(instanceName))
+	return TypedNode(typeName, zanzibar.NewNodeIDCodec(typeName).Encode("", instanceName))
 }
 
 func ClusterRoleNode(clusterRoleName string) zanzibar.Node {
-	return EscapedNode(TypeClusterRole, clusterRoleName)
+	return TypedNode(TypeClusterRole, clusterRoleIDs.Encode("", clusterRoleName))
 }
 
 func ClusterRoleBindingNode(clusterRoleBindingName string) zanzibar.Node {
-	return EscapedNode(TypeClusterRoleBinding, clusterRoleBindingName)
+	return TypedNode(TypeClusterRoleBinding, clusterRoleBindingIDs.Encode("", clusterRoleBindingName))
 }
 
 // NamespacedRoleNode returns the zanzibar node for a namespaced role.
 // As it is namespaced, it is fully qualified only with a given namespace.
-// Only the role name is escaped in openfga, the namespace is known to be ok.
 func NamespacedRoleNode(namespaceName, roleName string) zanzibar.Node {
-	return TypedNode(TypeNamespacedRole, namespaceName+"/"+url.QueryEscape(roleName))
+	return TypedNode(TypeNamespacedRole, roleIDs.Encode(namespaceName, roleName))
 }
 
 func NamespacedRoleBindingNode(namespaceName, roleBindingName string) zanzibar.Node {
-	return TypedNode(TypeNamespacedRoleBinding, namespaceName+"/"+url.QueryEscape(roleBindingName))
+	return TypedNode(TypeNamespacedRoleBinding, roleBindingIDs.Encode(namespaceName, roleBindingName))
 }
 
 // TODO: I'm pretty sure namespaces only can have sensible DNS1123 label conformant names and thus don't need escaping
@@ -163,15 +175,15 @@ func ResourceNode(apiGroup, resource string) zanzibar.Node {
 	return TypedNode(TypeResource, apiGroup+"."+resource)
 }
 
-// TODO: verify that query escaping here is ok
+// ResourceInstanceNode returns the node for a named object of a resource, such as "resourceinstance:core.pods/foo".
+// The "{apiGroup}.{resource}" part is encoded like the namespace of a namespaced object.
 // TODO: need namespace here too
 func ResourceInstanceNode(apiGroup, resource, instanceName string) zanzibar.Node {
 	if apiGroup == "" {
 		apiGroup = APIGroupKubernetesCore
 	}
 
-	// TODO: don't use this poor formatting
-	return TypedNode(TypeResourceInstance, apiGroup+"."+resource+"/"+url.QueryEscape(instanceName))
+	return TypedNode(TypeResourceInstance, resourceInstanceIDs.Encode(apiGroup+"."+resource, instanceName))
 }
 
 // NonResourceNode escapes the tuple name, as the path is not validated in Kubernetes and can be anything, including have whitespace and ":"
@@ -192,30 +204,20 @@ func NonResourceNode(nonResourceURL string) zanzibar.Node {
 // UserNode returns the node name for a user node
 // TODO: Do we really have to escape this? Are there any guarantees for user names? Probably not
 func UserNode(username string) zanzibar.Node {
-	return TypedNode(TypeUser, url.QueryEscape(username))
+	return TypedNode(TypeUser, userIDs.Encode("", username))
 }
 
 // GroupNode returns the node name for a group node
 // TODO: Do we really have to escape this? Are there any guarantees for group names? Probably not
 func GroupNode(groupname string) zanzibar.Node {
-	return TypedNode(TypeGroup, url.QueryEscape(groupname))
+	return TypedNode(TypeGroup, groupIDs.Encode("", groupname))
 }
 
-func ClusterRoleLabelAggregationKeyNode(key string) zanzibar.Node {
-	return TypedNode(TypeClusterRoleLabelAggregation, key)
-}
-
-func ClusterRoleLabelAggregationKeyValueNode(key, value string) zanzibar.Node {
-	// We can "safely" do this because the key and value are validated to be a string with characters, numbers or "_", "-", or "." (the key can have an optional "/" separator, too)
-	// See k8s.io/apimachinery/pkg/util/validation.IsQualifiedName and IsValidLabelValue. OpenFGA should allow all of these values just fine, as there are no ":", "#" or whitespace.
-	return TypedNode(TypeClusterRoleLabelAggregation, key+"="+value)
-}
-
-func ClusterRoleLabelAggregationNodes(key, value string) zanzibar.Nodes {
-	return zanzibar.NewNodes(
-		ClusterRoleLabelAggregationKeyValueNode(key, value),
-		ClusterRoleLabelAggregationKeyNode(key),
-	)
+// ClusterRoleLabelAggregationNode returns the node for a set of label requirements, such as "aggregate-to-edit=true", or
+// "aggregate-to-edit" for the key existing, that a clusterrole fulfills, or an aggregation rule selects, see aggregation.go
+func ClusterRoleLabelAggregationNode(requirements ...string) zanzibar.Node {
+	normalized, _ := normalizeLabelSet(requirements)
+	return EscapedNode(TypeClusterRoleLabelAggregation, strings.Join(normalized, labelRequirementSetSeparator))
 }
 
 const (
@@ -239,13 +241,14 @@ const (
 	// ZanzibarMatchAllNonResources = "/*"
 	RelationResourceAnyVerb = "anyverb"
 
-	// RelationClusterRoleLabelSelector specifies the relation when "clusterrole:edit#assignee selects clusterrole_label:aggregate-to-edit=true"
+	// RelationClusterRoleLabelSelector specifies the relation when "clusterrole:edit#assignee selects clusterrole_label:aggregate-to-edit=true",
+	// or for several labels, "clusterrole:foo#assignee selects clusterrole_label:app=foo,aggregate-to-edit=true"
 	// TODO: Unify these with the other ZanzibarRelation... names
 	RelationClusterRoleLabelSelector = "selects"
 	// RelationClusterRoleAssignee specifies the relation between some kind of user, group or userset of user or group type, e.g.
 	// - "user:lucas assignee clusterrole:foo"
 	// - "group:admin assignee clusterrole:admin"
-	// - "clusterrole_label:aggregate-to-edit=true#selected assignee clusterrole:my-aggregated-edit-role"
+	// - "clusterrole_label:aggregate-to-edit=true#selects assignee clusterrole:my-aggregated-edit-role"
 	// - "clusterrole:admin#assignee assignee clusterrole:view"
 	RelationClusterRoleAssignee = "assignee"
 	// RelationNamespacedRoleAssignee defines what relation a user and group can have to the role, in order to be matched for getting privileges
@@ -257,6 +260,13 @@ const (
 	// RelationNamespaceContainsRole defines the relation between a role and its namespace
 	RelationNamespaceContainsRole = "contains"
 
+	// RelationNamespacedRoleBindingAssignee defines the relation the subjects of a rolebinding referencing a clusterrole have
+	// to the clusterrole, when operating in the namespace of the rolebinding, e.g.
+	// - "rolebinding:default/foo#assignee assignee clusterrole:edit"
+	RelationNamespacedRoleBindingAssignee = "assignee"
+	// RelationNamespaceContainsRoleBinding defines the relation between a rolebinding referencing a clusterrole and its namespace
+	RelationNamespaceContainsRoleBinding = "contains"
+
 	ContextualRelationWildcardMatch       = "wildcardmatch"
 	ContextualRelationOperatesInNamespace = "operates_in"
 	ContextualRelationResourceMatch       = "resourcematch"
@@ -293,6 +303,7 @@ var (
 	InstanceRelations   = InstanceRelationsOnly.Union(CommonRelations)
 	CollectionRelations = CollectionRelationsOnly.Union(CommonRelations)
 
-	ResourceRelations    = CommonRelations.Union(InstanceRelationsOnly).Union(CollectionRelationsOnly)
-	NonResourceRelations = sets.New("get")
+	ResourceRelations = CommonRelations.Union(InstanceRelationsOnly).Union(CollectionRelationsOnly)
+	// The verbs of non-resource requests are the lowercased HTTP methods, see k8s.io/apiserver/pkg/endpoints/request.RequestInfoFactory
+	NonResourceRelations = sets.New("get", "head", "post", "put", "patch", "delete", "options")
 )
@@ -1,13 +1,18 @@
 package rbacconversion_test
 
 import (
+	"bytes"
 	"context"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/luxas/kube-rebac-authorizer/pkg/openfga"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion/rbacconversiontesting"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"gotest.tools/v3/golden"
 )
 
 var printTuples = zanzibar.PrintTuples
@@ -62,25 +67,25 @@ func Test_converter_ConvertClusterRolesToTuples(t *testing.T) {
 			name:            "aggregated admin",
 			clusterRoleName: "admin",
 			want: []Tuple{
-				zanzibar.NewUserSetTuple("clusterrole", "admin", "assignee", "selects", "clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-admin=true"),
+				zanzibar.NewUserSetTuple("clusterrole", "admin", "assignee", "selects", "clusterrole_label", "rbac.authorization.k8s.io%2Faggregate-to-admin%3Dtrue"),
 			},
 		},
 		{
 			name:            "aggregated edit",
 			clusterRoleName: "edit",
 			want: []Tuple{
-				zanzibar.NewUserSetTuple("clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-admin=true", "selects", "assignee", "clusterrole", "edit"),
-				zanzibar.NewUserSetTuple("clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-admin", "selects", "assignee", "clusterrole", "edit"),
-				zanzibar.NewUserSetTuple("clusterrole", "edit", "assignee", "selects", "clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-edit=true"),
+				zanzibar.NewUserSetTuple("clusterrole_label", "rbac.authorization.k8s.io%2Faggregate-to-admin%3Dtrue", "selects", "assignee", "clusterrole", "edit"),
+				zanzibar.NewUserSetTuple("clusterrole_label", "rbac.authorization.k8s.io%2Faggregate-to-admin", "selects", "assignee", "clusterrole", "edit"),
+				zanzibar.NewUserSetTuple("clusterrole", "edit", "assignee", "selects", "clusterrole_label", "rbac.authorization.k8s.io%2Faggregate-to-edit%3Dtrue"),
 			},
 		},
 		{
 			name:            "aggregated view",
 			clusterRoleName: "view",
 			want: []Tuple{
-				zanzibar.NewUserSetTuple("clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-edit=true", "selects", "assignee", "clusterrole", "view"),
-				zanzibar.NewUserSetTuple("clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-edit", "selects", "assignee", "clusterrole", "view"),
-				zanzibar.NewUserSetTuple("clusterrole", "view", "assignee", "selects", "clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-view=true"),
+				zanzibar.NewUserSetTuple("clusterrole_label", "rbac.authorization.k8s.io%2Faggregate-to-edit%3Dtrue", "selects", "assignee", "clusterrole", "view"),
+				zanzibar.NewUserSetTuple("clusterrole_label", "rbac.authorization.k8s.io%2Faggregate-to-edit", "selects", "assignee", "clusterrole", "view"),
+				zanzibar.NewUserSetTuple("clusterrole", "view", "assignee", "selects", "clusterrole_label", "rbac.authorization.k8s.io%2Faggregate-to-view%3Dtrue"),
 			},
 		},
 		{
@@ -279,6 +284,24 @@ func Test_converter_ConvertRoleBindingsToTuples(t *testing.T) {
 				zanzibar.NewTuple("user", "system%3Aserviceaccount%3Akube-system%3Akube-controller-manager", "namespaced_assignee", "rolebinding", "kube-system/system%3A%3Aleader-locking-kube-controller-manager"),
 			},
 		},
+		{
+			name:            "clusterrole referenced by a rolebinding is assigned in its namespace only",
+			roleBindingName: "test:user-edit",
+			want: []Tuple{
+				zanzibar.NewUserSetTuple("rolebinding", "default/test%3Auser-edit", "assignee", "assignee", "clusterrole", "edit"),
+				zanzibar.NewTuple("user", "test%3Auser-ns-edit", "namespaced_assignee", "rolebinding", "default/test%3Auser-edit"),
+				zanzibar.NewTuple("namespace", "default", "contains", "rolebinding", "default/test%3Auser-edit"),
+			},
+		},
+		{
+			name:            "test:group-view",
+			roleBindingName: "test:group-view",
+			want: []Tuple{
+				zanzibar.NewUserSetTuple("rolebinding", "kube-public/test%3Agroup-view", "assignee", "assignee", "clusterrole", "view"),
+				zanzibar.NewUserSetTuple("group", "test%3Ans-viewers", "members", "namespaced_assignee", "rolebinding", "kube-public/test%3Agroup-view"),
+				zanzibar.NewTuple("namespace", "kube-public", "contains", "rolebinding", "kube-public/test%3Agroup-view"),
+			},
+		},
 	}
 	for _, tt := range tests {
 		ctx := context.Background()
@@ -298,3 +321,98 @@ func Test_converter_ConvertRoleBindingsToTuples(t *testing.T) {
 		})
 	}
 }
+
+func Test_converter_ExpiringBindings(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		expiresAt     string
+		wantExpired   bool
+		wantExpiresIn bool
+	}{
+		{
+			name: "no expiry",
+		},
+		{
+			name:          "not yet expired",
+			expiresAt:     now.Add(time.Hour).UTC().Format(time.RFC3339),
+			wantExpiresIn: true,
+		},
+		{
+			name:        "expired",
+			expiresAt:   now.Add(-time.Hour).UTC().Format(time.RFC3339),
+			wantExpired: true,
+		},
+		{
+			name:        "invalid expiry",
+			expiresAt:   "tomorrow",
+			wantExpired: true,
+		},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		t.Run(tt.name, func(t *testing.T) {
+			gc := &rbacconversion.GenericConverter{}
+
+			crb := rbacconversiontesting.GetClusterRoleBinding("cluster-admin")
+			rb := rbacconversiontesting.GetRoleBinding("system:controller:bootstrap-signer")
+			if len(tt.expiresAt) != 0 {
+				crb.Annotations = map[string]string{rbacconversion.AnnotationExpiresAt: tt.expiresAt}
+				rb.Annotations = map[string]string{rbacconversion.AnnotationExpiresAt: tt.expiresAt}
+			}
+
+			crbTuples, err := gc.ConvertClusterRoleBindingToTuples(ctx, crb)
+			if err != nil {
+				t.Fatalf("GenericConverter.ConvertClusterRoleBindingToTuples() error = %v", err)
+			}
+			rbTuples, err := gc.ConvertRoleBindingToTuples(ctx, rb)
+			if err != nil {
+				t.Fatalf("GenericConverter.ConvertRoleBindingToTuples() error = %v", err)
+			}
+			// the tuples do not depend on the expiry, the syncers delete them when the binding is expired
+			if len(crbTuples) == 0 || len(rbTuples) == 0 {
+				t.Errorf("got clusterrolebinding tuples %v and rolebinding tuples %v, want tuples", printTuples(crbTuples), printTuples(rbTuples))
+			}
+			if expired := rbacconversion.Expired(&crb, now); expired != tt.wantExpired {
+				t.Errorf("Expired() = %v, want %v", expired, tt.wantExpired)
+			}
+
+			expiresIn := rbacconversion.ExpiresIn(&crb, now)
+			if (expiresIn > 0) != tt.wantExpiresIn || expiresIn > time.Hour {
+				t.Errorf("ExpiresIn() = %v, want expiry: %v", expiresIn, tt.wantExpiresIn)
+			}
+		})
+	}
+}
+
+func Test_converter_TestdataGolden(t *testing.T) {
+	ctx := context.Background()
+	tuples, err := rbacconversiontesting.ConvertTestdata(ctx)
+	if err != nil {
+		t.Fatalf("rbacconversiontesting.ConvertTestdata() error = %v", err)
+	}
+
+	sf, err := openfga.NewStoreFile("kube-rebac-authorizer-rbac", rbacconversion.GetSchema(), tuples)
+	if err != nil {
+		t.Fatalf("openfga.NewStoreFile() error = %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := sf.Encode(buf); err != nil {
+		t.Fatalf("StoreFile.Encode() error = %v", err)
+	}
+	// the golden file is shared with the integration tests, see rbacconversiontesting.SetupIntegrationTest
+	goldenFile, err := filepath.Abs(rbacconversiontesting.TestdataTuplesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, buf.String(), goldenFile)
+
+	// make sure the tuples survive the round-trip
+	decoded, err := zanzibar.DecodeTuples(buf, zanzibar.TupleFormatStoreFile)
+	if err != nil {
+		t.Fatalf("zanzibar.DecodeTuples() error = %v", err)
+	}
+	if !reflect.DeepEqual(decoded, tuples) {
+		t.Errorf("zanzibar.DecodeTuples() = %v, want %v", decoded, tuples)
+	}
+}
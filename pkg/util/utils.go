@@ -2,6 +2,11 @@ package util
 
 import "errors"
 
+var (
+	ErrNoMatch         = errors.New("need to match at least one")
+	ErrMultipleMatches = errors.New("need to match exactly one item")
+)
+
 func Map[I, O any](in []I, f func(I) O) []O {
 	list := make([]O, 0, len(in))
 	for _, item := range in {
@@ -35,14 +40,14 @@ func MatchOne[I any](in []I, f func(I) bool) (*I, error) {
 	for i, item := range in {
 		if f(item) {
 			if matchedOneAlready {
-				return nil, errors.New("need to match exactly one item")
+				return nil, ErrMultipleMatches
 			}
 			matched = &in[i]
 			matchedOneAlready = true
 		}
 	}
 	if !matchedOneAlready {
-		return nil, errors.New("need to match at least one")
+		return nil, ErrNoMatch
 	}
 	return matched, nil
 }
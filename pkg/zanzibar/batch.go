@@ -0,0 +1,78 @@
+package zanzibar
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CheckRequest is one check request of a batch, see Checker.CheckOne.
+type CheckRequest struct {
+	Tuple            Tuple
+	ContextualTuples []Tuple
+}
+
+// CheckResult is the result of one CheckRequest of a batch.
+type CheckResult struct {
+	// Index is the index of the request in the batch.
+	Index   int
+	Allowed bool
+	Err     error
+}
+
+// BatchChecker performs many check requests concurrently.
+type BatchChecker interface {
+	// BatchCheck performs all check requests concurrently, sharing the deadline of ctx.
+	// The results are sent on the returned channel in the order they complete, and the channel
+	// is closed when all requests are done. The channel is buffered, so the caller can stop
+	// reading early, e.g. when the first request is allowed, and cancel ctx to abort the rest.
+	BatchCheck(ctx context.Context, requests []CheckRequest) <-chan CheckResult
+}
+
+// ConcurrentBatchCheck implements BatchCheck for any Checker, by running one CheckOne
+// request per goroutine.
+func ConcurrentBatchCheck(ctx context.Context, c Checker, requests []CheckRequest) <-chan CheckResult {
+	results := make(chan CheckResult, len(requests))
+	wg := &sync.WaitGroup{}
+	wg.Add(len(requests))
+	for i := range requests {
+		go func(i int) {
+			defer wg.Done()
+			allowed, err := c.CheckOne(ctx, requests[i].Tuple, requests[i].ContextualTuples)
+			results <- CheckResult{Index: i, Allowed: allowed, Err: err}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// BatchCheckWithTimeout performs the check requests through the BatchCheck of c if it is a BatchChecker,
+// and ConcurrentBatchCheck otherwise, with all requests sharing one deadline timeout from now. A zero
+// timeout only uses the deadline of ctx. Just like for BatchCheck, the caller can stop reading early
+// and cancel ctx to abort the rest.
+func BatchCheckWithTimeout(ctx context.Context, c Checker, requests []CheckRequest, timeout time.Duration) <-chan CheckResult {
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	var results <-chan CheckResult
+	if bc, ok := c.(BatchChecker); ok {
+		results = bc.BatchCheck(ctx, requests)
+	} else {
+		results = ConcurrentBatchCheck(ctx, c, requests)
+	}
+
+	// the deadline must outlive this function, so release it only when the batch is done
+	forwarded := make(chan CheckResult, len(requests))
+	go func() {
+		defer cancel()
+		defer close(forwarded)
+		for result := range results {
+			forwarded <- result
+		}
+	}()
+	return forwarded
+}
@@ -0,0 +1,76 @@
+package zanzibar_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"gotest.tools/v3/assert"
+)
+
+func TestConcurrentBatchCheck(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewTupleStoreAndChecker(zanzibar.AuthorizationSchema{
+		Types: []zanzibar.TypeRelation{{
+			TypeName: "document",
+			Incoming: []zanzibar.IncomingRelation{{UserType: "user", Relation: "viewer"}},
+		}},
+	})
+	_, err := s.WriteTuples(ctx, []Tuple{zanzibar.NewTuple("user", "alice", "viewer", "document", "roadmap")}, nil)
+	assert.NilError(t, err)
+
+	requests := []zanzibar.CheckRequest{
+		{Tuple: zanzibar.NewTuple("user", "alice", "viewer", "document", "roadmap")},
+		{Tuple: zanzibar.NewTuple("user", "bob", "viewer", "document", "roadmap")},
+		{
+			Tuple:            zanzibar.NewTuple("user", "bob", "viewer", "document", "roadmap"),
+			ContextualTuples: []Tuple{zanzibar.NewTuple("user", "bob", "viewer", "document", "roadmap")},
+		},
+		{Tuple: zanzibar.NewTuple("user", "bob", "notexist", "document", "roadmap")},
+	}
+
+	results := make([]zanzibar.CheckResult, len(requests))
+	for result := range zanzibar.ConcurrentBatchCheck(ctx, s, requests) {
+		results[result.Index] = result
+	}
+	assert.Equal(t, results[0].Allowed, true)
+	assert.Equal(t, results[1].Allowed, false)
+	assert.Equal(t, results[2].Allowed, true)
+	assert.Assert(t, results[3].Err != nil)
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, results[i].Err)
+	}
+}
+
+// blockingChecker allows the first request instantly, and blocks the others until ctx is done
+type blockingChecker struct{}
+
+func (blockingChecker) CheckOne(ctx context.Context, tuple Tuple, _ []Tuple) (bool, error) {
+	if tuple.User.NodeName() == "alice" {
+		return true, nil
+	}
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func TestBatchCheckWithTimeout(t *testing.T) {
+	requests := []zanzibar.CheckRequest{
+		{Tuple: zanzibar.NewTuple("user", "alice", "viewer", "document", "roadmap")},
+		{Tuple: zanzibar.NewTuple("user", "bob", "viewer", "document", "roadmap")},
+		{Tuple: zanzibar.NewTuple("user", "carol", "viewer", "document", "roadmap")},
+	}
+
+	start := time.Now()
+	results := make([]zanzibar.CheckResult, len(requests))
+	for result := range zanzibar.BatchCheckWithTimeout(context.Background(), blockingChecker{}, requests, 50*time.Millisecond) {
+		results[result.Index] = result
+	}
+	assert.Assert(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, results[0].Allowed, true)
+	assert.NilError(t, results[0].Err)
+	for _, result := range results[1:] {
+		assert.ErrorIs(t, result.Err, context.DeadlineExceeded)
+	}
+}
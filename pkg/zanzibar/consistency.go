@@ -0,0 +1,139 @@
+package zanzibar
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ConsistencyToken (also known as a "zookie") identifies the state of a TupleStore right after a
+// write. Tokens are opaque, and only meaningful to the store that returned them.
+type ConsistencyToken string
+
+var ErrUnknownConsistencyToken = errors.New("consistency token was not issued by this store")
+
+// ConsistencyWaiter is implemented by checkers which can guarantee that checks are evaluated
+// against data at least as fresh as a given consistency token.
+type ConsistencyWaiter interface {
+	// WaitForConsistency blocks until all checks are evaluated against data at least as fresh as
+	// the token, or ctx is done. An empty token is always consistent. If the token was not issued
+	// by this store, an error wrapping ErrUnknownConsistencyToken is returned.
+	WaitForConsistency(ctx context.Context, token ConsistencyToken) error
+}
+
+// WaitForConsistency waits until the checker is at least as fresh as the token, if the checker
+// implements ConsistencyWaiter. Otherwise, nil is returned directly.
+func WaitForConsistency(ctx context.Context, c Checker, token ConsistencyToken) error {
+	if cw, ok := c.(ConsistencyWaiter); ok && len(token) != 0 {
+		return cw.WaitForConsistency(ctx, token)
+	}
+	return nil
+}
+
+// HighWaterMark must implement TupleStore
+var _ TupleStore = &HighWaterMark{}
+
+// NewHighWaterMark wraps the tuple store, keeping track of all writes in flight and the
+// consistency token of the latest write.
+func NewHighWaterMark(s TupleStore) *HighWaterMark {
+	return &HighWaterMark{TupleStore: s, inflight: map[uint64]chan struct{}{}, observed: map[string]chan struct{}{}}
+}
+
+// HighWaterMark is a TupleStore that can wait for all writes made through it to complete, and
+// for all changes its controllers observed to be reconciled, see Observe. It is used to make sure
+// authorization decisions take into account all tuples that are being written by the controllers
+// of the same process, e.g. right after a RoleBinding was created. Only writes that should delay
+// authorization decisions, such as the ones of RBAC objects, should go through it.
+type HighWaterMark struct {
+	TupleStore
+
+	mu       sync.Mutex
+	next     uint64
+	inflight map[uint64]chan struct{}
+	latest   ConsistencyToken
+	// observed contains the keys of the objects that changed, but are not reconciled yet
+	observed map[string]chan struct{}
+}
+
+// Observe records that a controller was notified about a change of the object with the given key,
+// such that Wait also waits for the object to be reconciled, see Reconciled. This covers the time
+// between the notification and the write of the tuples, but not the time until the notification
+// arrives from the API server's watch. Observe is a no-op for a nil HighWaterMark.
+func (h *HighWaterMark) Observe(key string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.observed[key]; !ok {
+		h.observed[key] = make(chan struct{})
+	}
+}
+
+// Reconciled records that the object with the given key was reconciled, successfully or not, such
+// that Wait does not wait for objects that fail to reconcile over and over. Reconciled is a no-op
+// for a nil HighWaterMark.
+func (h *HighWaterMark) Reconciled(key string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if done, ok := h.observed[key]; ok {
+		delete(h.observed, key)
+		close(done)
+	}
+}
+
+// WriteTuples writes the tuples to the underlying store, and records the returned token if
+// the write is successful.
+func (h *HighWaterMark) WriteTuples(ctx context.Context, writes, deletes []Tuple) (ConsistencyToken, error) {
+	h.mu.Lock()
+	id := h.next
+	h.next++
+	done := make(chan struct{})
+	h.inflight[id] = done
+	h.mu.Unlock()
+
+	token, err := h.TupleStore.WriteTuples(ctx, writes, deletes)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.inflight, id)
+	close(done)
+	if err == nil && len(token) != 0 {
+		h.latest = token
+	}
+	return token, err
+}
+
+// Wait blocks until all writes in flight and all observed changes when Wait was called are done, or ctx is done.
+// The token of the latest successful write is returned, which is empty if no writes
+// have been made. In case ctx is done, the latest token so far is returned together
+// with the context error.
+func (h *HighWaterMark) Wait(ctx context.Context) (ConsistencyToken, error) {
+	h.mu.Lock()
+	pending := make([]chan struct{}, 0, len(h.inflight)+len(h.observed))
+	for _, done := range h.inflight {
+		pending = append(pending, done)
+	}
+	for _, done := range h.observed {
+		pending = append(pending, done)
+	}
+	h.mu.Unlock()
+
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return h.latestToken(), ctx.Err()
+		}
+	}
+	return h.latestToken(), nil
+}
+
+func (h *HighWaterMark) latestToken() ConsistencyToken {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.latest
+}
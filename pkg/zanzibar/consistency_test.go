@@ -0,0 +1,96 @@
+package zanzibar_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"gotest.tools/v3/assert"
+)
+
+// blockingStore blocks all writes until unblock is closed
+type blockingStore struct {
+	zanzibar.TupleStore
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (s *blockingStore) WriteTuples(ctx context.Context, writes, deletes []Tuple) (zanzibar.ConsistencyToken, error) {
+	close(s.started)
+	<-s.unblock
+	return s.TupleStore.WriteTuples(ctx, writes, deletes)
+}
+
+func TestHighWaterMark(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.NewTupleStoreAndChecker(zanzibar.AuthorizationSchema{
+		Types: []zanzibar.TypeRelation{{
+			TypeName: "document",
+			Incoming: []zanzibar.IncomingRelation{{UserType: "user", Relation: "viewer"}},
+		}},
+	})
+	store := &blockingStore{TupleStore: backend, started: make(chan struct{}), unblock: make(chan struct{})}
+	hwm := zanzibar.NewHighWaterMark(store)
+
+	// no writes have been made yet
+	token, err := hwm.Wait(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, token, zanzibar.ConsistencyToken(""))
+
+	tuple := zanzibar.NewTuple("user", "alice", "viewer", "document", "roadmap")
+	writeDone := make(chan zanzibar.ConsistencyToken)
+	go func() {
+		token, err := hwm.WriteTuples(ctx, []Tuple{tuple}, nil)
+		assert.Check(t, err)
+		writeDone <- token
+	}()
+	<-store.started
+
+	// waiting is bounded by the context
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = hwm.Wait(timeoutCtx)
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded))
+
+	// once the write in flight completes, its token is returned, and checks are consistent with it
+	close(store.unblock)
+	written := <-writeDone
+	token, err = hwm.Wait(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, token, written)
+	assert.NilError(t, zanzibar.WaitForConsistency(ctx, backend, token))
+	allowed, err := backend.CheckOne(ctx, tuple, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, allowed)
+
+	// tokens of other stores are rejected
+	other := inmemory.NewTupleStoreAndChecker(zanzibar.AuthorizationSchema{})
+	err = zanzibar.WaitForConsistency(ctx, other, token)
+	assert.Assert(t, errors.Is(err, zanzibar.ErrUnknownConsistencyToken))
+}
+
+func TestHighWaterMark_Observe(t *testing.T) {
+	ctx := context.Background()
+	hwm := zanzibar.NewHighWaterMark(inmemory.NewTupleStoreAndChecker(zanzibar.AuthorizationSchema{}))
+
+	// observed changes are waited for until they are reconciled
+	hwm.Observe("rolebinding/default/foo")
+	hwm.Observe("rolebinding/default/foo")
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := hwm.Wait(timeoutCtx)
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded))
+
+	hwm.Reconciled("rolebinding/default/foo")
+	_, err = hwm.Wait(ctx)
+	assert.NilError(t, err)
+
+	// reconciling unobserved objects and using a nil high-water mark is fine
+	hwm.Reconciled("rolebinding/default/bar")
+	var nilHWM *zanzibar.HighWaterMark
+	nilHWM.Observe("rolebinding/default/foo")
+	nilHWM.Reconciled("rolebinding/default/foo")
+}
@@ -3,7 +3,7 @@ package zanzibar
 import (
 	"errors"
 	"fmt"
-	"net/url"
+	"slices"
 
 	"github.com/luxas/kube-rebac-authorizer/pkg/util"
 )
@@ -21,19 +21,71 @@ type (
 
 type TypeRelation struct {
 	TypeName string
-	IDExpr   IDExprFunc
-	EscapeID bool
+	// IDExpr returns the ID of the node of the object. If IDCodec is set, IDExpr returns the key of the object,
+	// "{namespace}/{name}" or "{name}", which is encoded with IDCodec.
+	IDExpr  IDExprFunc
+	IDCodec *NodeIDCodec
 
 	Condition ConditionFunc
 
+	// Outgoing and Incoming declare the directly assignable relations this type produces tuples for.
+	// The declaring type owns those tuples, that is, only it may add or delete them when reconciling,
+	// see ReconcileCompute. Each relation must be declared by exactly one type, see Validate.
 	Outgoing []OutgoingRelation
 	Incoming []IncomingRelation
 
 	EvaluatedUsersets map[string]EvaluatedUserset
+
+	// DenyRelations maps relations to the relations denying them, e.g. "create" to "forbidden". A user that
+	// has the deny relation to an object is explicitly denied the relation, even if the user also has it.
+	// The deny relation can be directly assignable, or e.g. a Difference rewrite.
+	DenyRelations map[string]string
+}
+
+// Owns returns true if the tuple is of a relation declared by this type; either as an Incoming relation with
+// this type as the object type, or as an Outgoing relation with this type as the user type. See Owners for
+// which node of this type owns the tuple.
+func (tr *TypeRelation) Owns(t Tuple) bool {
+	return len(tr.Owners(t)) != 0
+}
+
+// OwnedBy returns true if the tuple is owned by the given node of this type, see Owners.
+func (tr *TypeRelation) OwnedBy(t Tuple, node Node) bool {
+	return slices.ContainsFunc(tr.Owners(t), func(owner Node) bool {
+		return sameNode(owner, node)
+	})
+}
+
+// Owners returns the nodes of this type owning the tuple: the object for Incoming relations, and the user
+// (without userset relation) for Outgoing relations. For types related to themselves, the node on the other
+// side of the tuple does not own it, e.g. an Outgoing "folder:a parent folder:b" tuple is owned by folder:a
+// only. A tuple of both an Incoming and an Outgoing relation of this type is owned by both nodes.
+func (tr *TypeRelation) Owners(t Tuple) []Node {
+	if !t.Valid() {
+		return nil
+	}
+	var owners []Node
+	userSetRelation := t.GetUserSetRelation()
+	if t.Object.NodeType() == tr.TypeName {
+		for _, incoming := range tr.Incoming {
+			if incoming.UserType == t.User.NodeType() && incoming.UserSetRelation == userSetRelation && incoming.Relation == t.Relation {
+				owners = append(owners, NewNode(t.Object.NodeType(), t.Object.NodeName()))
+				break
+			}
+		}
+	}
+	if t.User.NodeType() == tr.TypeName {
+		for _, outgoing := range tr.Outgoing {
+			if outgoing.ObjectType == t.Object.NodeType() && outgoing.UserSetRelation == userSetRelation && slices.Contains(outgoing.Relations, t.Relation) {
+				owners = append(owners, NewNode(t.User.NodeType(), t.User.NodeName()))
+				break
+			}
+		}
+	}
+	return owners
 }
 
-// TODO: Make this for all other places too
-// Maybe call EscapeID QueryEscapeID
+// GetID returns the ID of the node of the object, see IDExpr.
 func (tr *TypeRelation) GetID(obj any) (string, error) {
 	if tr.IDExpr == nil {
 		return "", nil
@@ -44,15 +96,38 @@ func (tr *TypeRelation) GetID(obj any) (string, error) {
 		return "", err
 	}
 
-	if tr.EscapeID {
-		nodeID = url.QueryEscape(nodeID)
+	if tr.IDCodec != nil {
+		nodeID = tr.IDCodec.EncodeKey(nodeID)
 	}
 	return nodeID, nil
 }
 
+// EncodeID returns the ID of the node of the object with the given namespace and name, that is,
+// the ID GetID returns for the object.
+func (tr *TypeRelation) EncodeID(namespace, name string) string {
+	if tr.IDCodec != nil {
+		return tr.IDCodec.Encode(namespace, name)
+	}
+	if len(namespace) != 0 {
+		return namespace + "/" + name
+	}
+	return name
+}
+
+// DenyRelation returns the relation denying the given relation of the type, see TypeRelation.DenyRelations.
+// False is returned if the type is not declared, or the relation cannot be denied.
+func (as *AuthorizationSchema) DenyRelation(typeName, relation string) (string, bool) {
+	for i := range as.Types {
+		if as.Types[i].TypeName == typeName {
+			denyRelation, ok := as.Types[i].DenyRelations[relation]
+			return denyRelation, ok
+		}
+	}
+	return "", false
+}
+
 // EvaluatedUserset specifies a set of mutually
-// exclusive options
-// TODO: Add validation and "empty" functions
+// exclusive options, see AuthorizationSchema.Validate
 type EvaluatedUserset struct {
 	// Set operations
 	Union        []EvaluatedUserset
@@ -84,6 +159,8 @@ type OutgoingRelation struct {
 	ObjectIDExpr ObjectIDExprFunc
 	Condition    ConditionFunc
 
+	// EscapeID encodes the object IDs as names of cluster-scoped objects, with the IDCodec of the object type
+	// in the schema given to GenerateTuplesFor
 	EscapeID bool
 }
 
@@ -95,12 +172,26 @@ type IncomingRelation struct {
 	UserIDExpr UserIDExprFunc
 	Condition  ConditionFunc
 
+	// EscapeID encodes the user IDs as names of cluster-scoped objects, with the IDCodec of the user type
+	// in the schema given to GenerateTuplesFor
 	EscapeID bool
 }
 
+// Cast casts obj to T. If obj is a non-nil *T, it is dereferenced, such that
+// expressions for value types also work for objects as returned by Kubernetes clients.
+func Cast[T any](obj any) (T, bool) {
+	if casted, ok := obj.(T); ok {
+		return casted, true
+	}
+	if ptr, ok := obj.(*T); ok && ptr != nil {
+		return *ptr, true
+	}
+	return *new(T), false
+}
+
 func CastIDExpr[T any](f func(obj T) (string, error)) IDExprFunc {
 	return func(obj any) (string, error) {
-		casted, ok := obj.(T)
+		casted, ok := Cast[T](obj)
 		if !ok {
 			return "", fmt.Errorf("castIDExpr: %w, got type: %T, want to cast to: %T", ErrCouldNotCastType, obj, *new(T))
 		}
@@ -110,7 +201,7 @@ func CastIDExpr[T any](f func(obj T) (string, error)) IDExprFunc {
 
 func CastIncoming[T any](f func(obj T) ([]string, error)) UserIDExprFunc {
 	return func(obj any) ([]string, error) {
-		casted, ok := obj.(T)
+		casted, ok := Cast[T](obj)
 		if !ok {
 			return nil, fmt.Errorf("castIncoming: %w, got type: %T, want to cast to: %T", ErrCouldNotCastType, obj, *new(T))
 		}
@@ -119,7 +210,7 @@ func CastIncoming[T any](f func(obj T) ([]string, error)) UserIDExprFunc {
 }
 func CastOutgoing[T any](f func(obj T, relation string) ([]string, error)) ObjectIDExprFunc {
 	return func(obj any, relation string) ([]string, error) {
-		casted, ok := obj.(T)
+		casted, ok := Cast[T](obj)
 		if !ok {
 			return nil, fmt.Errorf("castOutgoing: %w, got type: %T, want to cast to: %T", ErrCouldNotCastType, obj, *new(T))
 		}
@@ -129,7 +220,9 @@ func CastOutgoing[T any](f func(obj T, relation string) ([]string, error)) Objec
 
 var ErrCouldNotCastType = errors.New("could not cast object to correct type")
 
-func GenerateTuplesFor(tr TypeRelation, obj any) ([]Tuple, error) {
+// GenerateTuplesFor generates the tuples of obj, of the type tr. The IDs of the related nodes are encoded
+// with the IDCodec of their type in as, such that they match the IDs the type's own objects are given.
+func GenerateTuplesFor(as *AuthorizationSchema, tr TypeRelation, obj any) ([]Tuple, error) {
 	// make sure the top-level condition is true
 	if tr.Condition != nil && !tr.Condition(obj) {
 		return nil, nil
@@ -164,7 +257,7 @@ func GenerateTuplesFor(tr TypeRelation, obj any) ([]Tuple, error) {
 			}
 
 			if outgoing.EscapeID {
-				objectIDs = util.Map(objectIDs, url.QueryEscape)
+				objectIDs = encodeNames(as.escapeIDCodec(outgoing.ObjectType), objectIDs)
 			}
 
 			objectIDs = util.FilterEmpty(objectIDs)
@@ -199,7 +292,7 @@ func GenerateTuplesFor(tr TypeRelation, obj any) ([]Tuple, error) {
 		}
 
 		if incoming.EscapeID {
-			userIDs = util.Map(userIDs, url.QueryEscape)
+			userIDs = encodeNames(as.escapeIDCodec(incoming.UserType), userIDs)
 		}
 
 		userIDs = util.FilterEmpty(userIDs)
@@ -217,3 +310,13 @@ func GenerateTuplesFor(tr TypeRelation, obj any) ([]Tuple, error) {
 
 	return result, nil
 }
+
+// encodeNames encodes the names with the codec, keeping empty names empty, such that they are filtered out
+func encodeNames(codec *NodeIDCodec, names []string) []string {
+	return util.Map(names, func(name string) string {
+		if len(name) == 0 {
+			return ""
+		}
+		return codec.Encode("", name)
+	})
+}
@@ -0,0 +1,288 @@
+package zanzibar
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// TupleFormat is a file format tuples can be encoded to and decoded from.
+type TupleFormat string
+
+const (
+	// TupleFormatJSON is a JSON list of {"user": "type:id#rel", "relation": "rel", "object": "type:id"} objects,
+	// the same format as the tuple keys of the OpenFGA API.
+	TupleFormatJSON TupleFormat = "json"
+	// TupleFormatYAML is the same as TupleFormatJSON, but in YAML.
+	TupleFormatYAML TupleFormat = "yaml"
+	// TupleFormatCSV is the CSV format of the OpenFGA CLI, with the header
+	// user_type,user_id,user_relation,relation,object_type,object_id
+	TupleFormatCSV TupleFormat = "csv"
+	// TupleFormatStoreFile is the .fga.yaml store file format of the OpenFGA CLI. Only the
+	// tuples are encoded and decoded, see StoreFile for also including the model.
+	TupleFormatStoreFile TupleFormat = "fga.yaml"
+)
+
+var ErrUnknownTupleFormat = errors.New("unknown tuple format")
+
+// TupleFormatForFile returns the tuple format matching the extension of the given file name.
+func TupleFormatForFile(fileName string) (TupleFormat, error) {
+	base := filepath.Base(fileName)
+	switch {
+	case strings.HasSuffix(base, ".fga.yaml"), strings.HasSuffix(base, ".fga.yml"):
+		return TupleFormatStoreFile, nil
+	case strings.HasSuffix(base, ".yaml"), strings.HasSuffix(base, ".yml"):
+		return TupleFormatYAML, nil
+	case strings.HasSuffix(base, ".json"):
+		return TupleFormatJSON, nil
+	case strings.HasSuffix(base, ".csv"):
+		return TupleFormatCSV, nil
+	}
+	return "", fmt.Errorf("%w for file %q", ErrUnknownTupleFormat, fileName)
+}
+
+// StoreFile is the store file format of the OpenFGA CLI, commonly named *.fga.yaml.
+// Tests in the store file are not supported.
+type StoreFile struct {
+	Name string `json:"name,omitempty"`
+	// Model is the authorization model in the OpenFGA DSL, see openfga.NewStoreFile
+	Model     string `json:"model,omitempty"`
+	ModelFile string `json:"model_file,omitempty"`
+	Tuples    Tuples `json:"tuples,omitempty"`
+	TupleFile string `json:"tuple_file,omitempty"`
+}
+
+// Encode writes the store file to w in YAML.
+func (sf *StoreFile) Encode(w io.Writer) error {
+	return writeYAML(w, sf)
+}
+
+// DecodeStoreFile reads a YAML store file from r.
+func DecodeStoreFile(r io.Reader) (*StoreFile, error) {
+	sf := &StoreFile{}
+	if err := readYAML(r, sf); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// tupleKey is the JSON representation of a tuple, the same as for OpenFGA tuple keys
+type tupleKey struct {
+	User     string `json:"user"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+}
+
+// MarshalJSON encodes the tuple like an OpenFGA tuple key.
+func (t Tuple) MarshalJSON() ([]byte, error) {
+	if !t.Valid() {
+		return nil, fmt.Errorf("cannot encode invalid tuple %s", t)
+	}
+	return json.Marshal(tupleKey{
+		User:     rawNodeString(t.User),
+		Relation: t.Relation,
+		Object:   rawNodeString(t.Object),
+	})
+}
+
+// UnmarshalJSON decodes a tuple encoded like an OpenFGA tuple key.
+func (t *Tuple) UnmarshalJSON(b []byte) error {
+	var key tupleKey
+	if err := json.Unmarshal(b, &key); err != nil {
+		return err
+	}
+	user, err := parseRawNode(key.User, true)
+	if err != nil {
+		return err
+	}
+	object, err := parseRawNode(key.Object, false)
+	if err != nil {
+		return err
+	}
+	if len(key.Relation) == 0 {
+		return fmt.Errorf("%w: relation must not be empty", ErrInvalidTupleFormat)
+	}
+	*t = Tuple{User: user, Relation: key.Relation, Object: object}
+	return nil
+}
+
+var csvHeader = []string{"user_type", "user_id", "user_relation", "relation", "object_type", "object_id"}
+
+// Encode writes the tuples to w in the given format.
+func (t Tuples) Encode(w io.Writer, format TupleFormat) error {
+	if t == nil {
+		t = Tuples{}
+	}
+	switch format {
+	case TupleFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	case TupleFormatYAML:
+		return writeYAML(w, t)
+	case TupleFormatStoreFile:
+		return (&StoreFile{Tuples: t}).Encode(w)
+	case TupleFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, tuple := range t {
+			if !tuple.Valid() {
+				return fmt.Errorf("cannot encode invalid tuple %s", tuple)
+			}
+			if err := cw.Write([]string{
+				tuple.User.NodeType(),
+				tuple.User.NodeName(),
+				tuple.GetUserSetRelation(),
+				tuple.Relation,
+				tuple.Object.NodeType(),
+				tuple.Object.NodeName(),
+			}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownTupleFormat, format)
+}
+
+// DecodeTuples reads tuples in the given format from r.
+func DecodeTuples(r io.Reader, format TupleFormat) (Tuples, error) {
+	switch format {
+	case TupleFormatJSON, TupleFormatYAML:
+		// YAML is a superset of JSON, so the YAML decoder handles both
+		tuples := Tuples{}
+		if err := readYAML(r, &tuples); err != nil {
+			return nil, err
+		}
+		return tuples, nil
+	case TupleFormatStoreFile:
+		sf, err := DecodeStoreFile(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(sf.TupleFile) != 0 {
+			return nil, fmt.Errorf("store files referring to a tuple_file are not supported")
+		}
+		if sf.Tuples == nil {
+			sf.Tuples = Tuples{}
+		}
+		return sf.Tuples, nil
+	case TupleFormatCSV:
+		return decodeCSV(r)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownTupleFormat, format)
+}
+
+func decodeCSV(r io.Reader) (Tuples, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // the columns are validated below, as extra columns (e.g. conditions) are allowed
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: missing CSV header", ErrInvalidTupleFormat)
+	}
+
+	columns := map[string]int{}
+	for i, column := range records[0] {
+		columns[strings.TrimSpace(column)] = i
+	}
+	for _, column := range csvHeader {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("%w: missing CSV column %q", ErrInvalidTupleFormat, column)
+		}
+	}
+
+	tuples := make(Tuples, 0, len(records)-1)
+	for i, record := range records[1:] {
+		field := func(column string) string {
+			if idx := columns[column]; idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+		user := NewNode(field("user_type"), field("user_id"))
+		if userSetRelation := field("user_relation"); len(userSetRelation) != 0 {
+			user = user.WithUserSet(userSetRelation)
+		}
+		tuple := Tuple{
+			User:     user,
+			Relation: field("relation"),
+			Object:   NewNode(field("object_type"), field("object_id")),
+		}
+		if !NodeValid(tuple.User) || !NodeValid(tuple.Object) || len(tuple.Relation) == 0 {
+			return nil, fmt.Errorf("%w: CSV line %d must have a user, relation and object", ErrInvalidTupleFormat, i+2)
+		}
+		tuples = append(tuples, tuple)
+	}
+	return tuples, nil
+}
+
+func writeYAML(w io.Writer, obj any) error {
+	b, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// readYAML decodes YAML or JSON from r into obj, disallowing unknown fields. The YAML is first
+// converted to JSON, such that errors returned from UnmarshalJSON are kept intact.
+func readYAML(r io.Reader, obj any) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	j, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.DisallowUnknownFields()
+	return dec.Decode(obj)
+}
+
+// rawNodeString formats the node as OpenFGA does, without any quoting of the ID
+func rawNodeString(n Node) string {
+	str := n.NodeType() + ":" + n.NodeName()
+	if us, ok := ToUserSet(n); ok && len(us.UserSetRelation()) != 0 {
+		str += "#" + us.UserSetRelation()
+	}
+	return str
+}
+
+// parseRawNode parses a node formatted by rawNodeString. Just like in OpenFGA, the
+// type ends at the first ':', and the userset relation starts at the last '#'.
+func parseRawNode(s string, allowUserSet bool) (Node, error) {
+	nodeType, id, _ := strings.Cut(s, ":")
+	userSetRelation := ""
+	if i := strings.LastIndex(id, "#"); i != -1 && allowUserSet {
+		id, userSetRelation = id[:i], id[i+1:]
+		if len(userSetRelation) == 0 {
+			return nil, fmt.Errorf("%w: node %q has an empty userset relation", ErrInvalidTupleFormat, s)
+		}
+	}
+	if len(nodeType) == 0 || len(id) == 0 {
+		return nil, fmt.Errorf("%w: node %q must be of the form type:id", ErrInvalidTupleFormat, s)
+	}
+	n := NewNode(nodeType, id)
+	if len(userSetRelation) != 0 {
+		return n.WithUserSet(userSetRelation), nil
+	}
+	return n, nil
+}
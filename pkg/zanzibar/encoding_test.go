@@ -0,0 +1,153 @@
+package zanzibar_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"gotest.tools/v3/assert"
+)
+
+func TestTuplesEncodeDecode(t *testing.T) {
+	tuples := zanzibar.Tuples{
+		zanzibar.NewTuple("user", "alice", "viewer", "document", "roadmap"),
+		zanzibar.NewUserSetTuple("group", "admins", "members", "editor", "document", "roadmap"),
+		zanzibar.NewTuple("user", "Jane Doe", "viewer", "document", "a#b:c"),
+	}
+	tests := []struct {
+		format zanzibar.TupleFormat
+		want   string
+	}{
+		{
+			format: zanzibar.TupleFormatJSON,
+			want: `[
+  {
+    "user": "user:alice",
+    "relation": "viewer",
+    "object": "document:roadmap"
+  },
+  {
+    "user": "group:admins#members",
+    "relation": "editor",
+    "object": "document:roadmap"
+  },
+  {
+    "user": "user:Jane Doe",
+    "relation": "viewer",
+    "object": "document:a#b:c"
+  }
+]
+`,
+		},
+		{
+			format: zanzibar.TupleFormatYAML,
+			want: `- object: document:roadmap
+  relation: viewer
+  user: user:alice
+- object: document:roadmap
+  relation: editor
+  user: group:admins#members
+- object: document:a#b:c
+  relation: viewer
+  user: user:Jane Doe
+`,
+		},
+		{
+			format: zanzibar.TupleFormatCSV,
+			want: `user_type,user_id,user_relation,relation,object_type,object_id
+user,alice,,viewer,document,roadmap
+group,admins,members,editor,document,roadmap
+user,Jane Doe,,viewer,document,a#b:c
+`,
+		},
+		{
+			format: zanzibar.TupleFormatStoreFile,
+			want: `tuples:
+- object: document:roadmap
+  relation: viewer
+  user: user:alice
+- object: document:roadmap
+  relation: editor
+  user: group:admins#members
+- object: document:a#b:c
+  relation: viewer
+  user: user:Jane Doe
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			assert.NilError(t, tuples.Encode(buf, tt.format))
+			assert.Equal(t, buf.String(), tt.want)
+
+			got, err := zanzibar.DecodeTuples(buf, tt.format)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got, tuples)
+		})
+	}
+}
+
+func TestDecodeTuplesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  zanzibar.TupleFormat
+		str     string
+		wantErr error
+	}{
+		{
+			name:    "unknown format",
+			format:  "xml",
+			wantErr: zanzibar.ErrUnknownTupleFormat,
+		},
+		{
+			name:    "missing id",
+			format:  zanzibar.TupleFormatJSON,
+			str:     `[{"user": "user:", "relation": "viewer", "object": "document:roadmap"}]`,
+			wantErr: zanzibar.ErrInvalidTupleFormat,
+		},
+		{
+			name:    "empty relation",
+			format:  zanzibar.TupleFormatYAML,
+			str:     `[{"user": "user:alice", "relation": "", "object": "document:roadmap"}]`,
+			wantErr: zanzibar.ErrInvalidTupleFormat,
+		},
+		{
+			name:    "missing CSV column",
+			format:  zanzibar.TupleFormatCSV,
+			str:     "user_type,user_id,relation,object_type,object_id\nuser,alice,viewer,document,roadmap\n",
+			wantErr: zanzibar.ErrInvalidTupleFormat,
+		},
+		{
+			name:    "empty CSV field",
+			format:  zanzibar.TupleFormatCSV,
+			str:     "user_type,user_id,user_relation,relation,object_type,object_id\nuser,alice,,,document,roadmap\n",
+			wantErr: zanzibar.ErrInvalidTupleFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := zanzibar.DecodeTuples(strings.NewReader(tt.str), tt.format)
+			assert.Assert(t, errors.Is(err, tt.wantErr), "got error %v", err)
+		})
+	}
+}
+
+func TestTupleFormatForFile(t *testing.T) {
+	for fileName, want := range map[string]zanzibar.TupleFormat{
+		"tuples.json":          zanzibar.TupleFormatJSON,
+		"testdata/tuples.yaml": zanzibar.TupleFormatYAML,
+		"tuples.yml":           zanzibar.TupleFormatYAML,
+		"tuples.csv":           zanzibar.TupleFormatCSV,
+		"rbac-tuples.fga.yaml": zanzibar.TupleFormatStoreFile,
+		"/tmp/store.fga.yml":   zanzibar.TupleFormatStoreFile,
+	} {
+		got, err := zanzibar.TupleFormatForFile(fileName)
+		assert.NilError(t, err)
+		assert.Equal(t, got, want, fileName)
+	}
+	_, err := zanzibar.TupleFormatForFile("tuples.txt")
+	assert.Assert(t, errors.Is(err, zanzibar.ErrUnknownTupleFormat))
+}
@@ -0,0 +1,69 @@
+package zanzibar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors returned by TupleStore, Checker and the other interfaces of this package wrap these
+// sentinel errors, such that callers can tell apart errors worth retrying (ErrBackendUnavailable)
+// from errors in the request or the authorization schema, which retrying does not fix. Test with
+// errors.Is; backends map their native errors to these, and keep the native error wrapped too.
+var (
+	// ErrInvalidTuple means the tuple is malformed, or not allowed by the authorization schema,
+	// e.g. as the user type is not directly assignable to the relation.
+	ErrInvalidTuple = errors.New("invalid tuple")
+	// ErrUnknownType means the type is not declared in the authorization schema.
+	ErrUnknownType = errors.New("unknown type")
+	// ErrUnknownRelation means the relation is not declared for the type in the authorization schema.
+	ErrUnknownRelation = errors.New("unknown relation")
+	// ErrUnsupportedFilter means the TupleFilter is invalid, or not supported by the backend.
+	ErrUnsupportedFilter = errors.New("unsupported tuple filter")
+	// ErrTupleConflict means the tuple to write already exists, or the tuple to delete does not exist,
+	// e.g. as the store was changed concurrently. Reading the store again and retrying resolves it.
+	ErrTupleConflict = errors.New("tuple conflict")
+	// ErrBackendUnavailable means the backend could not be reached, or could not answer in time.
+	// The request might succeed when retried.
+	ErrBackendUnavailable = errors.New("backend unavailable")
+)
+
+// IsPermanent returns true if the error is due to the request or the authorization schema, such
+// that retrying the same request fails the same way.
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrInvalidTuple) ||
+		errors.Is(err, ErrUnknownType) ||
+		errors.Is(err, ErrUnknownRelation) ||
+		errors.Is(err, ErrUnsupportedFilter)
+}
+
+// TupleError is the error of one tuple of a request, e.g. of a write. Requests with many tuples return
+// the errors of all tuples joined with errors.Join, such that each can be found with errors.As.
+type TupleError struct {
+	Tuple Tuple
+	Err   error
+}
+
+func (e *TupleError) Error() string {
+	return fmt.Sprintf("tuple %s: %v", e.Tuple, e.Err)
+}
+
+func (e *TupleError) Unwrap() error {
+	return e.Err
+}
+
+// TupleErrors returns all TupleErrors wrapped in err, e.g. joined with errors.Join.
+func TupleErrors(err error) []*TupleError {
+	switch e := err.(type) {
+	case *TupleError:
+		return []*TupleError{e}
+	case interface{ Unwrap() []error }:
+		result := []*TupleError{}
+		for _, err := range e.Unwrap() {
+			result = append(result, TupleErrors(err)...)
+		}
+		return result
+	case interface{ Unwrap() error }:
+		return TupleErrors(e.Unwrap())
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package zanzibar
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The canonical string format of a tuple is "type:id#rel relation type:id", where the
+// "#rel" userset relation of the user is optional, e.g.
+//
+//	group:admins#members viewer document:roadmap
+//
+// Type names and relations cannot contain whitespace, ':', '#' or '"'. IDs are written as-is,
+// unless they are empty or contain whitespace, '#', '"' or non-printable characters; then they
+// are written as a double-quoted Go string, e.g. user:"Jane Doe" viewer document:roadmap
+
+var ErrInvalidTupleFormat = errors.New("invalid tuple format")
+
+// String formats the tuple in the canonical format, see ParseTuple.
+func (t Tuple) String() string {
+	return fmt.Sprintf("%s %s %s", NodeString(t.User), t.Relation, NodeString(t.Object))
+}
+
+// String formats the tuples in the canonical format, one tuple per line.
+func (t Tuples) String() string {
+	sb := &strings.Builder{}
+	for _, tuple := range t {
+		sb.WriteString(tuple.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// NodeString formats the node as "type:id", or "type:id#rel" if the node is a UserSet.
+func NodeString(n Node) string {
+	if n == nil {
+		return "<nil>"
+	}
+	str := n.NodeType() + ":" + formatID(n.NodeName())
+	if us, ok := ToUserSet(n); ok && len(us.UserSetRelation()) != 0 {
+		str += "#" + us.UserSetRelation()
+	}
+	return str
+}
+
+func formatID(id string) string {
+	if len(id) == 0 || strings.IndexFunc(id, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '#' || r == '"' || !unicode.IsPrint(r)
+	}) != -1 {
+		return strconv.Quote(id)
+	}
+	return id
+}
+
+// ParseTuple parses a tuple in the canonical "type:id#rel relation type:id" format,
+// as produced by Tuple.String.
+func ParseTuple(s string) (Tuple, error) {
+	user, rest, err := parseNodePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return Tuple{}, fmt.Errorf("%w %q: user: %v", ErrInvalidTupleFormat, s, err)
+	}
+	relation, rest := cutIdentifier(strings.TrimLeftFunc(rest, unicode.IsSpace))
+	if err := validateIdentifier(relation); err != nil {
+		return Tuple{}, fmt.Errorf("%w %q: relation: %v", ErrInvalidTupleFormat, s, err)
+	}
+	object, rest, err := parseNodePrefix(strings.TrimLeftFunc(rest, unicode.IsSpace))
+	if err != nil {
+		return Tuple{}, fmt.Errorf("%w %q: object: %v", ErrInvalidTupleFormat, s, err)
+	}
+	if IsUserSet(object) {
+		return Tuple{}, fmt.Errorf("%w %q: object cannot have a userset relation", ErrInvalidTupleFormat, s)
+	}
+	if len(rest) != 0 {
+		return Tuple{}, fmt.Errorf("%w %q: unexpected trailing %q", ErrInvalidTupleFormat, s, rest)
+	}
+	return Tuple{User: user, Relation: relation, Object: object}, nil
+}
+
+// MustParseTuple is like ParseTuple, but panics on error. It is meant for test fixtures.
+func MustParseTuple(s string) Tuple {
+	t, err := ParseTuple(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseNode parses a node in the "type:id" or "type:id#rel" format, as produced by NodeString.
+func ParseNode(s string) (Node, error) {
+	n, rest, err := parseNodePrefix(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node %q: %w", s, err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("invalid node %q: unexpected trailing %q", s, rest)
+	}
+	return n, nil
+}
+
+// parseNodePrefix parses the node in the beginning of s, and returns the rest of s
+func parseNodePrefix(s string) (Node, string, error) {
+	nodeType, rest, found := strings.Cut(s, ":")
+	if !found {
+		return nil, "", fmt.Errorf("missing ':' between type and id")
+	}
+	if err := validateIdentifier(nodeType); err != nil {
+		return nil, "", fmt.Errorf("type: %w", err)
+	}
+
+	var id string
+	if strings.HasPrefix(rest, `"`) {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, "", fmt.Errorf("id: %w", err)
+		}
+		// QuotedPrefix already validated the string
+		id, _ = strconv.Unquote(quoted)
+		rest = rest[len(quoted):]
+	} else {
+		end := strings.IndexFunc(rest, func(r rune) bool { return unicode.IsSpace(r) || r == '#' })
+		if end == -1 {
+			end = len(rest)
+		}
+		id, rest = rest[:end], rest[end:]
+		if len(id) == 0 {
+			return nil, "", fmt.Errorf("id must not be empty")
+		}
+	}
+
+	n := NewNode(nodeType, id)
+	if !strings.HasPrefix(rest, "#") {
+		return n, rest, nil
+	}
+	userSetRelation, rest := cutIdentifier(rest[1:])
+	if err := validateIdentifier(userSetRelation); err != nil {
+		return nil, "", fmt.Errorf("userset relation: %w", err)
+	}
+	return n.WithUserSet(userSetRelation), rest, nil
+}
+
+// cutIdentifier returns the characters before the first whitespace of s, and the rest
+func cutIdentifier(s string) (string, string) {
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end == -1 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+func validateIdentifier(s string) error {
+	if len(s) == 0 {
+		return fmt.Errorf("must not be empty")
+	}
+	if i := strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ':' || r == '#' || r == '"'
+	}); i != -1 {
+		return fmt.Errorf("%q must not contain %q", s, s[i])
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package zanzibar_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseTuple(t *testing.T) {
+	tests := []struct {
+		name    string
+		str     string
+		want    Tuple
+		wantStr string
+		wantErr bool
+	}{
+		{
+			name: "user",
+			str:  "user:alice viewer document:roadmap",
+			want: zanzibar.NewTuple("user", "alice", "viewer", "document", "roadmap"),
+		},
+		{
+			name: "userset",
+			str:  "group:admins#members viewer document:roadmap",
+			want: zanzibar.NewUserSetTuple("group", "admins", "members", "viewer", "document", "roadmap"),
+		},
+		{
+			name: "ids with special characters",
+			str:  "clusterrole_label:rbac.authorization.k8s.io/aggregate-to-admin=true get nonresourceurls:/healthz:8080",
+			want: zanzibar.NewTuple("clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-admin=true", "get", "nonresourceurls", "/healthz:8080"),
+		},
+		{
+			name: "quoted ids",
+			str:  `user:"Jane Doe" viewer document:"a#b \"c\""`,
+			want: zanzibar.NewTuple("user", "Jane Doe", "viewer", "document", `a#b "c"`),
+		},
+		{
+			name:    "unnecessarily quoted id and extra whitespace",
+			str:     ` group:"admins"#members   viewer document:roadmap `,
+			want:    zanzibar.NewUserSetTuple("group", "admins", "members", "viewer", "document", "roadmap"),
+			wantStr: "group:admins#members viewer document:roadmap",
+		},
+		{
+			name:    "missing object",
+			str:     "user:alice viewer",
+			wantErr: true,
+		},
+		{
+			name:    "empty id",
+			str:     "user: viewer document:roadmap",
+			wantErr: true,
+		},
+		{
+			name:    "object userset",
+			str:     "user:alice viewer document:roadmap#parent",
+			wantErr: true,
+		},
+		{
+			name:    "trailing data",
+			str:     "user:alice viewer document:roadmap foo",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated quote",
+			str:     `user:"alice viewer document:roadmap`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := zanzibar.ParseTuple(tt.str)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTuple() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				assert.Assert(t, errors.Is(err, zanzibar.ErrInvalidTupleFormat))
+				return
+			}
+			assert.DeepEqual(t, got, tt.want)
+
+			wantStr := tt.wantStr
+			if len(wantStr) == 0 {
+				wantStr = tt.str
+			}
+			assert.Equal(t, got.String(), wantStr)
+		})
+	}
+}
@@ -77,17 +77,27 @@ func (_c *MockTupleStore_ReadTuples_Call) RunAndReturn(run func(context.Context,
 }
 
 // WriteTuples provides a mock function with given fields: ctx, writes, deletes
-func (_m *MockTupleStore) WriteTuples(ctx context.Context, writes []Tuple, deletes []Tuple) error {
+func (_m *MockTupleStore) WriteTuples(ctx context.Context, writes []Tuple, deletes []Tuple) (ConsistencyToken, error) {
 	ret := _m.Called(ctx, writes, deletes)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, []Tuple, []Tuple) error); ok {
+	var r0 ConsistencyToken
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []Tuple, []Tuple) (ConsistencyToken, error)); ok {
+		return rf(ctx, writes, deletes)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []Tuple, []Tuple) ConsistencyToken); ok {
 		r0 = rf(ctx, writes, deletes)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(ConsistencyToken)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []Tuple, []Tuple) error); ok {
+		r1 = rf(ctx, writes, deletes)
+	} else {
+		r1 = ret.Error(1)
 	}
 
-	return r0
+	return r0, r1
 }
 
 // MockTupleStore_WriteTuples_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'WriteTuples'
@@ -110,12 +120,12 @@ func (_c *MockTupleStore_WriteTuples_Call) Run(run func(ctx context.Context, wri
 	return _c
 }
 
-func (_c *MockTupleStore_WriteTuples_Call) Return(_a0 error) *MockTupleStore_WriteTuples_Call {
-	_c.Call.Return(_a0)
+func (_c *MockTupleStore_WriteTuples_Call) Return(_a0 ConsistencyToken, _a1 error) *MockTupleStore_WriteTuples_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MockTupleStore_WriteTuples_Call) RunAndReturn(run func(context.Context, []Tuple, []Tuple) error) *MockTupleStore_WriteTuples_Call {
+func (_c *MockTupleStore_WriteTuples_Call) RunAndReturn(run func(context.Context, []Tuple, []Tuple) (ConsistencyToken, error)) *MockTupleStore_WriteTuples_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -0,0 +1,107 @@
+package zanzibar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MaxObjectLength is the maximum length of an object, "type:id", in OpenFGA
+const MaxObjectLength = 256
+
+// hashedIDPrefix marks hashed names. url.QueryEscape escapes "=", so no encoded name starts with it.
+const hashedIDPrefix = "sha256="
+
+// ErrHashedNodeID is returned when decoding a node ID that was too long, and thus was hashed.
+var ErrHashedNodeID = errors.New("the name of the node ID is hashed")
+
+// NodeIDCodec encodes the namespace and name of Kubernetes objects, and other names such as
+// usernames, into node IDs, and decodes them back. The namespace and name are query-escaped, as
+// e.g. RBAC names and usernames can contain characters that OpenFGA does not allow in IDs, such
+// as ":" and whitespace. Namespaced IDs are of the form "{namespace}/{name}"; as url.QueryEscape
+// escapes "/", the first "/" always separates the namespace from the name.
+//
+// IDs longer than MaxLength are not accepted by OpenFGA, and hence have their name replaced by a
+// hash, such that the ID stays unique, but the name cannot be decoded.
+type NodeIDCodec struct {
+	// MaxLength is the maximum length of an encoded ID. Zero means no limit.
+	MaxLength int
+}
+
+// NewNodeIDCodec returns the codec for IDs of the given type, such that "type:id" fits within the
+// length limit of OpenFGA.
+func NewNodeIDCodec(typeName string) *NodeIDCodec {
+	return &NodeIDCodec{MaxLength: MaxObjectLength - len(typeName) - len(":")}
+}
+
+// Encode returns the node ID for the name, namespaced if namespace is non-empty.
+func (c *NodeIDCodec) Encode(namespace, name string) string {
+	id := url.QueryEscape(name)
+	if len(namespace) != 0 {
+		id = url.QueryEscape(namespace) + "/" + id
+	}
+	if c.MaxLength == 0 || len(id) <= c.MaxLength {
+		return id
+	}
+	// keep the namespace, such that the namespace of the object can still be decoded
+	sum := sha256.Sum256([]byte(id))
+	hashed := hashedIDPrefix + hex.EncodeToString(sum[:])
+	if len(namespace) != 0 {
+		hashed = url.QueryEscape(namespace) + "/" + hashed
+	}
+	return hashed
+}
+
+// EncodeKey encodes the key of a Kubernetes object, "{namespace}/{name}" or "{name}", as
+// returned by e.g. cache.MetaNamespaceKeyFunc. Kubernetes object names never contain "/".
+func (c *NodeIDCodec) EncodeKey(key string) string {
+	namespace, name, namespaced := strings.Cut(key, "/")
+	if !namespaced {
+		return c.Encode("", key)
+	}
+	return c.Encode(namespace, name)
+}
+
+// Decode returns the namespace and name the ID was encoded from. If the name was hashed, the namespace
+// is returned together with an error wrapping ErrHashedNodeID.
+func (c *NodeIDCodec) Decode(id string) (namespace, name string, err error) {
+	escapedNamespace, escapedName, namespaced := strings.Cut(id, "/")
+	if !namespaced {
+		escapedNamespace, escapedName = "", id
+	}
+	if namespace, err = url.QueryUnescape(escapedNamespace); err != nil {
+		return "", "", fmt.Errorf("invalid node ID %q: %w", id, err)
+	}
+	if strings.HasPrefix(escapedName, hashedIDPrefix) {
+		return namespace, "", fmt.Errorf("cannot decode node ID %q: %w", id, ErrHashedNodeID)
+	}
+	if name, err = url.QueryUnescape(escapedName); err != nil {
+		return "", "", fmt.Errorf("invalid node ID %q: %w", id, err)
+	}
+	return namespace, name, nil
+}
+
+// NodeIDCodec returns the codec of the IDs of the type, or nil if the type is not declared, or its
+// IDs are not encoded.
+func (as *AuthorizationSchema) NodeIDCodec(typeName string) *NodeIDCodec {
+	for i := range as.Types {
+		if as.Types[i].TypeName == typeName {
+			return as.Types[i].IDCodec
+		}
+	}
+	return nil
+}
+
+// escapeIDCodec returns the codec of the IDs of the type, defaulting to NewNodeIDCodec if the type is
+// not declared, or its IDs are not encoded.
+func (as *AuthorizationSchema) escapeIDCodec(typeName string) *NodeIDCodec {
+	if as != nil {
+		if codec := as.NodeIDCodec(typeName); codec != nil {
+			return codec
+		}
+	}
+	return NewNodeIDCodec(typeName)
+}
@@ -0,0 +1,148 @@
+package zanzibar_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"gotest.tools/v3/assert"
+)
+
+func TestNodeIDCodec(t *testing.T) {
+	codec := zanzibar.NewNodeIDCodec("role")
+	longName := strings.Repeat("a", 300)
+
+	tests := []struct {
+		name          string
+		namespace     string
+		objectName    string
+		want          string
+		wantNamespace string
+		wantHashed    bool
+	}{
+		{
+			name:       "cluster-scoped",
+			objectName: "cluster-admin",
+			want:       "cluster-admin",
+		},
+		{
+			name:       "characters not allowed by OpenFGA are escaped",
+			objectName: "system:controller:foo bar#baz",
+			want:       "system%3Acontroller%3Afoo+bar%23baz",
+		},
+		{
+			name:       "slashes in names are escaped",
+			objectName: "https://issuer.example.com/#alice",
+			want:       "https%3A%2F%2Fissuer.example.com%2F%23alice",
+		},
+		{
+			name:       "names that look hashed are escaped",
+			objectName: "sha256=abc",
+			want:       "sha256%3Dabc",
+		},
+		{
+			name:          "namespaced",
+			namespace:     "kube-system",
+			objectName:    "system:controller:bootstrap-signer",
+			want:          "kube-system/system%3Acontroller%3Abootstrap-signer",
+			wantNamespace: "kube-system",
+		},
+		{
+			name:       "too long names are hashed",
+			objectName: longName,
+			want:       "sha256=9835fa6bf4e20a9b9ea812506302e98982721a6cf8d2cae67af57129bf21ae90",
+			wantHashed: true,
+		},
+		{
+			name:          "too long namespaced names keep the namespace",
+			namespace:     "default",
+			objectName:    longName,
+			want:          "default/sha256=",
+			wantNamespace: "default",
+			wantHashed:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := codec.Encode(tt.namespace, tt.objectName)
+			assert.Assert(t, len("role:"+id) <= zanzibar.MaxObjectLength)
+
+			namespace, name, err := codec.Decode(id)
+			assert.Equal(t, tt.wantNamespace, namespace)
+			if tt.wantHashed {
+				assert.Assert(t, strings.HasPrefix(id, tt.want), id)
+				assert.ErrorIs(t, err, zanzibar.ErrHashedNodeID)
+				// hashing is deterministic
+				assert.Equal(t, id, codec.Encode(tt.namespace, tt.objectName))
+				return
+			}
+			assert.Equal(t, tt.want, id)
+			assert.NilError(t, err)
+			assert.Equal(t, tt.objectName, name)
+		})
+	}
+}
+
+func TestTypeRelationGetID(t *testing.T) {
+	tr := zanzibar.TypeRelation{
+		TypeName: "role",
+		IDExpr: zanzibar.CastIDExpr(func(key string) (string, error) {
+			return key, nil
+		}),
+		IDCodec: zanzibar.NewNodeIDCodec("role"),
+	}
+	id, err := tr.GetID("kube-system/system:controller:bootstrap-signer")
+	assert.NilError(t, err)
+	assert.Equal(t, "kube-system/system%3Acontroller%3Abootstrap-signer", id)
+	assert.Equal(t, id, tr.EncodeID("kube-system", "system:controller:bootstrap-signer"))
+
+	as := zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{tr}}
+	assert.Equal(t, tr.IDCodec, as.NodeIDCodec("role"))
+	assert.Assert(t, as.NodeIDCodec("clusterrole") == nil)
+}
+
+func TestGenerateTuplesFor_escapeID(t *testing.T) {
+	type binding struct {
+		Name  string
+		Role  string
+		Users []string
+	}
+	userIDs := &zanzibar.NodeIDCodec{MaxLength: 16}
+	tr := zanzibar.TypeRelation{
+		TypeName: "binding",
+		IDExpr: zanzibar.CastIDExpr(func(b binding) (string, error) {
+			return b.Name, nil
+		}),
+		Outgoing: []zanzibar.OutgoingRelation{{
+			ObjectType: "role",
+			Relations:  []string{"assignee"},
+			ObjectIDExpr: zanzibar.CastOutgoing(func(b binding, _ string) ([]string, error) {
+				return []string{b.Role}, nil
+			}),
+			EscapeID: true,
+		}},
+		Incoming: []zanzibar.IncomingRelation{{
+			UserType: "user",
+			Relation: "assignee",
+			UserIDExpr: zanzibar.CastIncoming(func(b binding) ([]string, error) {
+				return b.Users, nil
+			}),
+			EscapeID: true,
+		}},
+	}
+	as := &zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{
+		tr,
+		{TypeName: "user", IDCodec: userIDs},
+	}}
+	longName := "system:serviceaccount:default:builder"
+
+	tuples, err := zanzibar.GenerateTuplesFor(as, tr, binding{Name: "view", Role: longName, Users: []string{longName}})
+	assert.NilError(t, err)
+	// the configured codec of the user type hashes the long name, while the role type, without
+	// a configured codec, gets the default one
+	zanzibar.Tuples(tuples).AssertEqualsWanted([]zanzibar.Tuple{
+		zanzibar.NewTuple("binding", "view", "assignee", "role", "system%3Aserviceaccount%3Adefault%3Abuilder"),
+		zanzibar.NewTuple("user", userIDs.Encode("", longName), "assignee", "binding", "view"),
+	}, t, "GenerateTuplesFor")
+	assert.Assert(t, strings.HasPrefix(userIDs.Encode("", longName), "sha256="))
+}
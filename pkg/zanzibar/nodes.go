@@ -1,6 +1,10 @@
 package zanzibar
 
-import "github.com/luxas/kube-rebac-authorizer/pkg/util"
+import (
+	"sort"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/util"
+)
 
 // Node points to only exactly one node. The node has a type
 // and name. The node can be transformed into a user set, meaning it
@@ -28,6 +32,16 @@ func NodeValid(n Node) bool {
 	return len(n.NodeType()) != 0 && len(n.NodeName()) != 0
 }
 
+// SortNodes sorts the nodes by type and name, in place.
+func SortNodes(nodes []Node) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].NodeType() != nodes[j].NodeType() {
+			return nodes[i].NodeType() < nodes[j].NodeType()
+		}
+		return nodes[i].NodeName() < nodes[j].NodeName()
+	})
+}
+
 // UserSet points to all nodes related as users in tuples
 // where AbstractNode is the object, and NodeSetRelation is the
 // relation.
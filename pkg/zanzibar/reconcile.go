@@ -2,24 +2,26 @@ package zanzibar
 
 import (
 	context "context"
+	"errors"
+	"fmt"
 
 	"github.com/luxas/kube-rebac-authorizer/pkg/util"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
-// Reconcile computes a reconcile for all tuples related to the given node. Old tuples are deleted, new tuples added,
-// and existing tuples not touched. The first tuple slice returned are the tuples that should be added, the second
-// for tuples to be deleted. Use ReconcileApply for applying them at the same time.
-// TODO: This would reconcile almost all tuples twice, once from the "user side" and once from the "object side"
-// How do we specify what side is the "authorative" or "producing" side to respect?
-// TODO: Move this to the generic Zanzibar library as we won't depend on specific stuff in OpenFGA.
+// ErrTupleNotOwned is returned when a tuple is desired for a node, which the node's type does not own.
+var ErrTupleNotOwned = errors.New("tuple is not owned by the node")
+
+// ReconcileCompute computes a reconcile for all tuples owned by the given node. Old tuples are deleted, new tuples
+// added, and existing tuples not touched. The first tuple slice returned are the tuples that should be added, the
+// second for tuples to be deleted. Use ReconcileApply for applying them at the same time.
+//
+// A node owns the tuples of the edges declared as Incoming or Outgoing relations of its TypeRelation, where the node
+// itself is the object or user, respectively; see TypeRelation.Owners. Tuples of edges declared by other types are never
+// touched, even though they refer to the node. For example, "clusterrolebinding:foo#assignee assignee clusterrole:bar"
+// is owned by clusterrolebinding:foo, and not reconciled for clusterrole:bar. All desired tuples must be owned by the
+// node, otherwise an error wrapping ErrTupleNotOwned is returned.
 func ReconcileCompute(ctx context.Context, s TupleStore, node Node, desiredTuples []Tuple) ([]Tuple, []Tuple, error) {
-	// There are three ways to be related to node, by the node being
-	// - a subject user
-	// - a subject userset
-	// - an object
-	// But due to limitations in the OpenFGA API, we need to do more work with the authorization model.
-
 	as, err := s.GetAuthorizationSchema(ctx)
 	if err != nil {
 		return nil, nil, err
@@ -32,133 +34,107 @@ func ReconcileCompute(ctx context.Context, s TupleStore, node Node, desiredTuple
 		return nil, nil, err
 	}
 
-	ownedIncomingRelations := sets.New[typeUserset]()
-	ownedOutgoingRelations := sets.New[typeUserset]()
-
-	for _, incoming := range matchedType.Incoming {
-		ownedIncomingRelations.Insert(typeUserset{
-			TypeName:        incoming.UserType,
-			UserSetRelation: incoming.UserSetRelation,
-		})
-	}
-	for _, outgoing := range matchedType.Outgoing {
-		ownedOutgoingRelations.Insert(typeUserset{
-			TypeName:        outgoing.ObjectType,
-			UserSetRelation: outgoing.UserSetRelation,
-		})
-	}
-
-	// For example, a ClusterRole does not know to which all ClusterRoleBinding it is the object in Tuples such as:
-	// clusterrolebinding:foo1#assignee, assignee, clusterrole:foo
-	// clusterrolebinding:foo2#assignee, assignee, clusterrole:foo
-	// This relation is defined as outgoing for clusterrolebinding, but NOT incoming on the clusterrole side
-	// So if we did a full search for all tuples with clusterrole:foo as object when reconciling clusterrole
-	// tuples, we'd get the above tuples to "remove" as they are not generated from the clusterrole object and
-	// thus not likely part of the desiredTuples.
-	// TODO: Add validation when building the authorizationschema that there are no conflicts/dupicates between
-	// incoming and outgoing relations between types, or do we support both?
-	// TODO: Need to change UserSetRelations to become a slice of ORed values?
-
-	incomingTuples, err := s.ReadTuples(ctx, TupleFilter{
-		ObjectType: node.NodeType(),
-		ObjectName: node.NodeName(),
-	})
-	if err != nil {
-		return nil, nil, err
-	}
-
-	incomingTuples = util.Filter(incomingTuples, func(t Tuple) bool {
-		return ownedIncomingRelations.Has(typeUserset{
-			TypeName:        t.User.NodeType(),
-			UserSetRelation: t.GetUserSetRelation(),
-		})
-	})
-
-	// Get all tuples where this subject node is related to any other typed node
-	// through any direct relation.
-	outgoingUserTuples, err := s.ReadTuples(ctx, TupleFilter{
-		UserType: node.NodeType(),
-		UserName: node.NodeName(),
-	})
-	if err != nil {
-		return nil, nil, err
+	owns := func(t Tuple) bool {
+		return matchedType.OwnedBy(t, node)
 	}
-
-	// Get all tuples where this subject node is related to any other typed node
-	// through any userset relation and any relation.
-	outgoingUserSetTuples, err := s.ReadTuples(ctx, TupleFilter{
-		UserType:        node.NodeType(),
-		UserName:        node.NodeName(),
-		UserSetRelation: TupleFilterWildcardUserSetRelation,
-	})
-	if err != nil {
-		return nil, nil, err
+	for _, desiredTuple := range desiredTuples {
+		if !owns(desiredTuple) {
+			return nil, nil, fmt.Errorf("%w: cannot reconcile %s for %s", ErrTupleNotOwned, desiredTuple, NodeString(node))
+		}
 	}
 
-	outgoingTuples := append(outgoingUserTuples, outgoingUserSetTuples...)
-
-	outgoingTuples = util.Filter(outgoingTuples, func(t Tuple) bool {
-		return ownedOutgoingRelations.Has(typeUserset{
-			TypeName:        t.Object.NodeType(),
-			UserSetRelation: t.GetUserSetRelation(),
-		})
-	})
-
-	tupleLookup := map[Tuple][2]int{}
-	var tuplesToAdd []*Tuple
-	var tuplesToRemove []*Tuple
-
-	for i, tuple := range incomingTuples {
-		tupleLookup[tuple] = [2]int{directionIn, i}
-	}
-	for i, tuple := range outgoingTuples {
-		tupleLookup[tuple] = [2]int{directionOut, i}
+	// There are three ways to be related to node, by the node being
+	// - an object
+	// - a subject user
+	// - a subject userset
+	// and due to limitations in the OpenFGA API, these need to be read separately.
+	filters := []TupleFilter{
+		{
+			ObjectType: node.NodeType(),
+			ObjectName: node.NodeName(),
+		},
+		{
+			UserType: node.NodeType(),
+			UserName: node.NodeName(),
+		},
+		{
+			UserType:        node.NodeType(),
+			UserName:        node.NodeName(),
+			UserSetRelation: TupleFilterWildcardUserSetRelation,
+		},
 	}
 
-	alreadyExistsTupleIndices := sets.New[[2]int]()
-
-	for i, desiredTuple := range desiredTuples {
-		whereToFind, exists := tupleLookup[desiredTuple]
-		if !exists {
-			// Register a new desired tuple that we want to add
-			tuplesToAdd = append(tuplesToAdd, &desiredTuples[i])
-			continue
+	// existingTuples contains all owned tuples in the store; a tuple where node is both
+	// the user and object is read twice, so deduplicate them
+	existingTuples := []Tuple{}
+	seen := sets.New[Tuple]()
+	for _, filter := range filters {
+		tuples, err := s.ReadTuples(ctx, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, t := range tuples {
+			if owns(t) && !seen.Has(t) {
+				seen.Insert(t)
+				existingTuples = append(existingTuples, t)
+			}
 		}
-		// register that this desired tuple already exists in OpenFGA
-		alreadyExistsTupleIndices.Insert(whereToFind)
 	}
 
-	// TODO: Could we effectively shrink these instead in the above loop, to yield
-	// just the tuples for deletion in the end?
-	for i := range incomingTuples {
-		if !alreadyExistsTupleIndices.Has([2]int{directionIn, i}) {
-			tuplesToRemove = append(tuplesToRemove, &incomingTuples[i])
+	desired := sets.New(desiredTuples...)
+	var tuplesToAdd, tuplesToRemove []Tuple
+	for _, desiredTuple := range desiredTuples {
+		if !seen.Has(desiredTuple) {
+			tuplesToAdd = append(tuplesToAdd, desiredTuple)
+			// avoid adding duplicate desired tuples twice
+			seen.Insert(desiredTuple)
 		}
 	}
-
-	for i := range outgoingTuples {
-		if !alreadyExistsTupleIndices.Has([2]int{directionOut, i}) {
-			tuplesToRemove = append(tuplesToRemove, &outgoingTuples[i])
+	for _, existingTuple := range existingTuples {
+		if !desired.Has(existingTuple) {
+			tuplesToRemove = append(tuplesToRemove, existingTuple)
 		}
 	}
 
-	return util.DereferenceList(tuplesToAdd), util.DereferenceList(tuplesToRemove), nil
+	return tuplesToAdd, tuplesToRemove, nil
 }
 
-func ReconcileApply(ctx context.Context, s TupleStore, node Node, desiredTuples []Tuple) error {
+func ReconcileApply(ctx context.Context, s TupleStore, node Node, desiredTuples []Tuple) (ConsistencyToken, error) {
 	additions, deletions, err := ReconcileCompute(ctx, s, node, desiredTuples)
 	if err != nil {
-		return err
+		return "", err
 	}
 	return s.WriteTuples(ctx, additions, deletions)
 }
 
-const (
-	directionIn  = 1
-	directionOut = 2
-)
+// WriteValidTuples writes the tuples, except for the invalid ones, such that an invalid tuple, e.g. generated from a
+// malformed object, cannot block the other writes and the deletes, e.g. of revoked access. If the store rejects the write
+// due to invalid tuples, the tuples of the TupleErrors are returned as invalid, and the other tuples are written again.
+// Writing the invalid tuples fails the same way until they change, so retrying does not help.
+func WriteValidTuples(ctx context.Context, s TupleStore, writes, deletes []Tuple) (ConsistencyToken, []*TupleError, error) {
+	token, err := s.WriteTuples(ctx, writes, deletes)
+	if !IsPermanent(err) {
+		return token, nil, err
+	}
+	invalid := util.Filter(TupleErrors(err), func(tupleErr *TupleError) bool {
+		return IsPermanent(tupleErr)
+	})
+	if len(invalid) == 0 {
+		return "", nil, err
+	}
+
+	// compare the string forms, as stores might return the tuples with other Node implementations
+	invalidTuples := sets.New(util.Map(invalid, func(tupleErr *TupleError) string {
+		return tupleErr.Tuple.String()
+	})...)
+	valid := func(t Tuple) bool {
+		return !invalidTuples.Has(t.String())
+	}
+	token, err = s.WriteTuples(ctx, util.Filter(writes, valid), util.Filter(deletes, valid))
+	return token, invalid, err
+}
 
-type typeUserset struct {
-	TypeName        string
-	UserSetRelation string
+// sameNode returns true if the nodes have the same type and name, disregarding any userset relation
+func sameNode(a, b Node) bool {
+	return a.NodeType() == b.NodeType() && a.NodeName() == b.NodeName()
 }
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/luxas/kube-rebac-authorizer/pkg/inmemory"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
 	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion/rbacconversiontesting"
 	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
@@ -97,7 +98,7 @@ func TestReconcile(t *testing.T) {
 				ObjectName: tt.node.NodeName(),
 			}).Return(tt.existingObjectTuples, nil)
 
-			s.EXPECT().WriteTuples(ctx, tt.wantAdded, tt.wantDeleted).Return(nil)
+			s.EXPECT().WriteTuples(ctx, tt.wantAdded, tt.wantDeleted).Return("", nil)
 			err := Reconcile(ctx, s, tt.node, tt.desiredTuples)
 			assert.ErrorIs(t, err, tt.targetErr, "Reconcile")
 		})
@@ -119,7 +120,7 @@ func TestReconcileIntegration(t *testing.T) {
 			name: "no-op",
 			node: rbacconversion.ClusterRoleNode("admin"),
 			desiredTuples: []Tuple{
-				zanzibar.NewUserSetTuple("clusterrole", "admin", "assignee", "selects", "clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-admin=true"),
+				zanzibar.MustParseTuple("clusterrole:admin#assignee selects clusterrole_label:rbac.authorization.k8s.io%2Faggregate-to-admin%3Dtrue"),
 			},
 		},
 		{
@@ -127,62 +128,160 @@ func TestReconcileIntegration(t *testing.T) {
 			node:          rbacconversion.ClusterRoleNode("admin"),
 			desiredTuples: []Tuple{},
 			wantDeleted: []Tuple{
-				zanzibar.NewUserSetTuple("clusterrole", "admin", "assignee", "selects", "clusterrole_label", "rbac.authorization.k8s.io/aggregate-to-admin=true"),
+				zanzibar.MustParseTuple("clusterrole:admin#assignee selects clusterrole_label:rbac.authorization.k8s.io%2Faggregate-to-admin%3Dtrue"),
 			},
 		},
 		{
 			name: "add new clusterrole",
 			node: rbacconversion.ClusterRoleNode("newclusterrole"),
 			desiredTuples: []Tuple{
-				// TODO: If we try adding things that are not compliant with the "rules", should we apply or ignore those?
-				zanzibar.NewUserSetTuple("clusterrole", "newclusterrole", "assignee", "create", "resource", "core.events"),
-				zanzibar.NewUserSetTuple("clusterrolebinding", "newclusterrole", "assignee", "assignee", "clusterrole", "newclusterrole"),
+				zanzibar.MustParseTuple("clusterrole:newclusterrole#assignee create resource:core.events"),
 			},
 			wantAdded: []Tuple{
-				zanzibar.NewUserSetTuple("clusterrole", "newclusterrole", "assignee", "create", "resource", "core.events"),
-				zanzibar.NewUserSetTuple("clusterrolebinding", "newclusterrole", "assignee", "assignee", "clusterrole", "newclusterrole"),
+				zanzibar.MustParseTuple("clusterrole:newclusterrole#assignee create resource:core.events"),
+			},
+		},
+		{
+			name: "tuple owned by the binding cannot be added by the clusterrole",
+			node: rbacconversion.ClusterRoleNode("newclusterrole"),
+			desiredTuples: []Tuple{
+				zanzibar.MustParseTuple("clusterrole:newclusterrole#assignee create resource:core.events"),
+				zanzibar.MustParseTuple("clusterrolebinding:newclusterrole#assignee assignee clusterrole:newclusterrole"),
+			},
+			targetErr: zanzibar.ErrTupleNotOwned,
+		},
+		{
+			name: "tuple of another node cannot be added",
+			node: rbacconversion.ClusterRoleNode("newclusterrole"),
+			desiredTuples: []Tuple{
+				zanzibar.MustParseTuple("clusterrole:otherclusterrole#assignee create resource:core.events"),
+			},
+			targetErr: zanzibar.ErrTupleNotOwned,
+		},
+		{
+			name: "tuples owned by the binding are not deleted when reconciling the clusterrole",
+			node: rbacconversion.ClusterRoleNode("system:kube-dns"),
+			desiredTuples: []Tuple{
+				zanzibar.MustParseTuple("clusterrole:system%3Akube-dns#assignee list resource:core.endpoints"),
+				zanzibar.MustParseTuple("clusterrole:system%3Akube-dns#assignee list resource:core.services"),
+				zanzibar.MustParseTuple("clusterrole:system%3Akube-dns#assignee watch resource:core.endpoints"),
+				zanzibar.MustParseTuple("clusterrole:system%3Akube-dns#assignee watch resource:core.services"),
 			},
 		},
 		{
 			name: "add one, delete one",
 			node: rbacconversion.ClusterRoleBindingNode("system:public-info-viewer"),
 			desiredTuples: []Tuple{
-				zanzibar.NewUserSetTuple("clusterrolebinding", "system%3Apublic-info-viewer", "assignee", "assignee", "clusterrole", "system%3Apublic-info-viewer"),
-				zanzibar.NewUserSetTuple("group", "system%3Aauthenticated", "members", "assignee", "clusterrolebinding", "system%3Apublic-info-viewer"),
+				zanzibar.MustParseTuple("clusterrolebinding:system%3Apublic-info-viewer#assignee assignee clusterrole:system%3Apublic-info-viewer"),
+				zanzibar.MustParseTuple("group:system%3Aauthenticated#members assignee clusterrolebinding:system%3Apublic-info-viewer"),
 				// deleted
-				//zanzibar.NewUserSetTuple("group", "system%3Aunauthenticated", "members", "assignee", "clusterrolebinding", "system%3Apublic-info-viewer"),
+				//zanzibar.MustParseTuple("group:system%3Aunauthenticated#members assignee clusterrolebinding:system%3Apublic-info-viewer"),
 				// added
-				zanzibar.NewTuple("user", "foo", "assignee", "clusterrolebinding", "system%3Apublic-info-viewer"),
+				zanzibar.MustParseTuple("user:foo assignee clusterrolebinding:system%3Apublic-info-viewer"),
 			},
 			wantAdded: []Tuple{
-				zanzibar.NewTuple("user", "foo", "assignee", "clusterrolebinding", "system%3Apublic-info-viewer"),
+				zanzibar.MustParseTuple("user:foo assignee clusterrolebinding:system%3Apublic-info-viewer"),
 			},
 			wantDeleted: []Tuple{
-				zanzibar.NewUserSetTuple("group", "system%3Aunauthenticated", "members", "assignee", "clusterrolebinding", "system%3Apublic-info-viewer"),
+				zanzibar.MustParseTuple("group:system%3Aunauthenticated#members assignee clusterrolebinding:system%3Apublic-info-viewer"),
 			},
 		},
 	}
 	ctx := context.Background()
-	debug, openfgaimpl := rbacconversiontesting.SetupIntegrationTest(ctx, t)
+	debug, backend := rbacconversiontesting.SetupIntegrationTest(ctx, t)
 	defer debug()
 
-	if openfgaimpl == nil {
+	if backend == nil {
 		return
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			adds, deletes, err := zanzibar.ReconcileCompute(ctx, openfgaimpl, tt.node, tt.desiredTuples)
+			adds, deletes, err := zanzibar.ReconcileCompute(ctx, backend, tt.node, tt.desiredTuples)
 			if !reflect.DeepEqual(adds, tt.wantAdded) {
 				t.Errorf("openfga.ReconcileCompute(added) = %v, want %v",
-					zanzibar.PrintTuples(adds), zanzibar.PrintTuples(tt.wantAdded))
+					zanzibar.Tuples(adds), zanzibar.Tuples(tt.wantAdded))
 			}
 			// TODO: Move this DeepEqual into testing utils.
 			if !reflect.DeepEqual(deletes, tt.wantDeleted) {
 				t.Errorf("openfga.ReconcileCompute(deleted) = %v, want %v",
-					zanzibar.PrintTuples(deletes), zanzibar.PrintTuples(tt.wantDeleted))
+					zanzibar.Tuples(deletes), zanzibar.Tuples(tt.wantDeleted))
 			}
 			assert.ErrorIs(t, err, tt.targetErr, "Reconcile")
 		})
 	}
 }
+
+func TestReconcileCompute_selfReferencing(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.NewTupleStoreAndChecker(zanzibar.AuthorizationSchema{
+		Types: []zanzibar.TypeRelation{
+			{
+				TypeName: "folder",
+				Outgoing: []zanzibar.OutgoingRelation{{ObjectType: "folder", Relations: []string{"parent"}}},
+			},
+			{
+				TypeName: "group",
+				Incoming: []zanzibar.IncomingRelation{{UserType: "group", UserSetRelation: "members", Relation: "members"}},
+			},
+		},
+	})
+	_, err := backend.WriteTuples(ctx, []Tuple{
+		zanzibar.MustParseTuple("folder:a parent folder:b"),
+		zanzibar.MustParseTuple("folder:b parent folder:c"),
+		zanzibar.MustParseTuple("group:x#members members group:y"),
+		zanzibar.MustParseTuple("group:y#members members group:z"),
+	}, nil)
+	assert.NilError(t, err)
+
+	// the outgoing tuples of folder:b are owned by it, but not the ones pointing to it
+	adds, deletes, err := zanzibar.ReconcileCompute(ctx, backend, zanzibar.NewNode("folder", "b"), nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(adds), 0)
+	assert.DeepEqual(t, deletes, []Tuple{zanzibar.MustParseTuple("folder:b parent folder:c")})
+
+	// the incoming tuples of group:y are owned by it, but not the ones it is the user of
+	adds, deletes, err = zanzibar.ReconcileCompute(ctx, backend, zanzibar.NewNode("group", "y"), nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(adds), 0)
+	assert.DeepEqual(t, deletes, []Tuple{zanzibar.MustParseTuple("group:x#members members group:y")})
+
+	// tuples of the node on the other side cannot be desired
+	_, _, err = zanzibar.ReconcileCompute(ctx, backend, zanzibar.NewNode("folder", "b"), []Tuple{
+		zanzibar.MustParseTuple("folder:a parent folder:b"),
+	})
+	assert.ErrorIs(t, err, zanzibar.ErrTupleNotOwned)
+}
+
+func TestWriteValidTuples(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.NewTupleStoreAndChecker(zanzibar.AuthorizationSchema{
+		Types: []zanzibar.TypeRelation{{
+			TypeName: "document",
+			Incoming: []zanzibar.IncomingRelation{{UserType: "user", Relation: "viewer"}},
+		}},
+	})
+	_, err := backend.WriteTuples(ctx, []Tuple{zanzibar.MustParseTuple("user:bob viewer document:roadmap")}, nil)
+	assert.NilError(t, err)
+
+	// the invalid tuple does not block the other write and the delete
+	invalidTuple := zanzibar.MustParseTuple("group:sales viewer document:roadmap")
+	_, invalid, err := zanzibar.WriteValidTuples(ctx, backend,
+		[]Tuple{zanzibar.MustParseTuple("user:alice viewer document:roadmap"), invalidTuple},
+		[]Tuple{zanzibar.MustParseTuple("user:bob viewer document:roadmap")})
+	assert.NilError(t, err)
+	assert.Equal(t, len(invalid), 1)
+	assert.Equal(t, invalid[0].Tuple.String(), invalidTuple.String())
+	assert.ErrorIs(t, invalid[0], zanzibar.ErrInvalidTuple)
+
+	tuples, err := backend.ReadTuples(ctx, zanzibar.TupleFilter{})
+	assert.NilError(t, err)
+	zanzibar.Tuples(tuples).AssertEqualsWanted(zanzibar.Tuples{
+		zanzibar.MustParseTuple("user:alice viewer document:roadmap"),
+	}, t, "ReadTuples")
+
+	// other errors are returned as is
+	_, invalid, err = zanzibar.WriteValidTuples(ctx, backend, nil, []Tuple{zanzibar.MustParseTuple("user:bob viewer document:roadmap")})
+	assert.ErrorIs(t, err, zanzibar.ErrTupleConflict)
+	assert.Equal(t, len(invalid), 0)
+}
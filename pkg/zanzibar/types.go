@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"slices"
 	"sort"
 	"testing"
 )
@@ -16,14 +17,34 @@ type Checker interface {
 	CheckOne(ctx context.Context, tuple Tuple, contextualTuples []Tuple) (bool, error)
 }
 
+// Lister answers which objects a user can reach, and which users can reach an object.
+// The results are sorted, and contextual tuples are taken into account, just like for CheckOne.
+type Lister interface {
+	// ListObjects returns all objects of objectType that the user is related to through the relation.
+	ListObjects(ctx context.Context, user Node, relation, objectType string, contextualTuples []Tuple) ([]Node, error)
+	// ListUsers returns all users of userType that are related to the object through the relation.
+	ListUsers(ctx context.Context, object Node, relation, userType string, contextualTuples []Tuple) ([]Node, error)
+}
+
+// Expander explains check decisions.
+type Expander interface {
+	// ExpandOne returns the resolution tree proving why the user of the given tuple is related
+	// to the object through the relation. Only the branches leading to the user are part of
+	// the tree. If the user is not related to the object, a nil tree is returned.
+	// Contextual tuples are taken into account, just like for CheckOne.
+	ExpandOne(ctx context.Context, tuple Tuple, contextualTuples []Tuple) (*UsersetTree, error)
+}
+
 // TupleStore is a store bound to a specific authorization model (TODO: can the model
 // change over time?) and set of tuples.
 type TupleStore interface {
-	// ReadTuples reads all tuples from the store matching the following predicates:
-	// - if tuple.User is set, then the
+	// ReadTuples reads all tuples from the store matching the filter. All backends support all
+	// combinations of predicates described by TupleFilter, although some filters might require the
+	// backend to scan all tuples.
 	ReadTuples(ctx context.Context, filter TupleFilter) ([]Tuple, error)
-	// WriteTuples TODO
-	WriteTuples(ctx context.Context, writes, deletes []Tuple) error
+	// WriteTuples writes and deletes the given tuples. The returned consistency token can be passed
+	// to ConsistencyWaiter.WaitForConsistency, to make sure later checks take the write into account.
+	WriteTuples(ctx context.Context, writes, deletes []Tuple) (ConsistencyToken, error)
 	// GetAuthorizationSchema gets the current authorization schema
 	// TODO: Should this be a no-op without ctx and errors?
 	GetAuthorizationSchema(ctx context.Context) (*AuthorizationSchema, error)
@@ -52,6 +73,9 @@ type TupleFilter struct {
 	// Relation filter matches tuples only with the given relation.
 	// If empty, tuples with any relation are returned.
 	Relation string
+	// Relations filter matches tuples with any of the given relations.
+	// It cannot be combined with Relation.
+	Relations []string
 
 	// ObjectType filter matches all tuples with the given object type.
 	ObjectType string
@@ -67,6 +91,7 @@ var (
 	errUserNameRequiredForUserSetRelation             = errors.New("UserName required when when UserSetRelation is set")
 	errObjectTypeRequiredForObjectName                = errors.New("ObjectType required when when ObjectName is set")
 	errObjectNameWildcardExclusiveWithUserSetRelation = errors.New("UserSetRelation cannot be a wildcard when UserName is a wildcard")
+	errRelationExclusiveWithRelations                 = errors.New("Relation and Relations cannot both be set")
 )
 
 func (tf TupleFilter) Validate() error {
@@ -83,7 +108,60 @@ func (tf TupleFilter) Validate() error {
 	if tf.UserName == TupleFilterWildcardUserName && len(tf.UserSetRelation) != 0 {
 		errs = append(errs, errObjectNameWildcardExclusiveWithUserSetRelation)
 	}
-	return errors.Join(errs...)
+	if len(tf.Relation) != 0 && len(tf.Relations) != 0 {
+		errs = append(errs, errRelationExclusiveWithRelations)
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%w: %w", ErrUnsupportedFilter, errors.Join(errs...))
+	}
+	return nil
+}
+
+// RelationSet returns the relations the filter matches, or nil if it matches any relation.
+func (tf TupleFilter) RelationSet() []string {
+	if len(tf.Relation) != 0 {
+		return []string{tf.Relation}
+	}
+	return tf.Relations
+}
+
+// Matches returns true if the tuple matches all predicates of the filter. Backends use it to
+// filter the tuples they could not filter natively.
+func (tf TupleFilter) Matches(t Tuple) bool {
+	if len(tf.UserType) != 0 && t.User.NodeType() != tf.UserType {
+		return false
+	}
+	if len(tf.UserName) != 0 {
+		if t.User.NodeName() != tf.UserName {
+			return false
+		}
+		// A fully-qualified user only matches the user itself, unless a userset relation is asked for.
+		userSetRelation := t.GetUserSetRelation()
+		switch tf.UserSetRelation {
+		case "":
+			if len(userSetRelation) != 0 {
+				return false
+			}
+		case TupleFilterWildcardUserSetRelation:
+			if len(userSetRelation) == 0 {
+				return false
+			}
+		default:
+			if userSetRelation != tf.UserSetRelation {
+				return false
+			}
+		}
+	}
+	if relations := tf.RelationSet(); len(relations) != 0 && !slices.Contains(relations, t.Relation) {
+		return false
+	}
+	if len(tf.ObjectType) != 0 && t.Object.NodeType() != tf.ObjectType {
+		return false
+	}
+	if len(tf.ObjectName) != 0 && t.Object.NodeName() != tf.ObjectName {
+		return false
+	}
+	return true
 }
 
 const (
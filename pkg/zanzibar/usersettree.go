@@ -0,0 +1,79 @@
+package zanzibar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UsersetTreeKind describes how a node in the UsersetTree resolves its userset.
+type UsersetTreeKind string
+
+const (
+	// UsersetTreeDirect means that Users are directly related to the object through the relation.
+	// For all Users that are usersets, Children contains their resolution.
+	UsersetTreeDirect UsersetTreeKind = "Direct"
+	// UsersetTreeComputed means that the only child is the resolution of another relation of the same object.
+	UsersetTreeComputed UsersetTreeKind = "Computed"
+	// UsersetTreeTupleToUserset means that Children are the resolutions of the referenced relation, for
+	// the objects related to the object through the Tupleset relation.
+	UsersetTreeTupleToUserset UsersetTreeKind = "TupleToUserset"
+	// UsersetTreeUnion means that the user is part of at least one of the Children.
+	UsersetTreeUnion UsersetTreeKind = "Union"
+	// UsersetTreeIntersection means that the user is part of all of the Children.
+	UsersetTreeIntersection UsersetTreeKind = "Intersection"
+	// UsersetTreeDifference means that the user is part of the only child (the base), but not the subtracted userset.
+	UsersetTreeDifference UsersetTreeKind = "Difference"
+)
+
+// UsersetTree describes how the users of a userset, that is, an object and a relation, are resolved.
+type UsersetTree struct {
+	// Object and Relation specify the userset this part of the tree resolves.
+	Object   Node
+	Relation string
+
+	Kind UsersetTreeKind
+
+	// Users are the users or usersets directly related to the object, set for UsersetTreeDirect.
+	Users []Node
+	// Tupleset is the relation followed from the object, set for UsersetTreeTupleToUserset.
+	Tupleset string
+	// Children are the nested resolutions, see UsersetTreeKind for what they mean for each kind.
+	Children []*UsersetTree
+
+	// Resolution is an optional backend-specific description of how the check was resolved,
+	// for example the trace of an OpenFGA check request. Only set for the root of the tree.
+	Resolution string
+}
+
+// String renders the tree in a human-readable, indented format, e.g. for explaining
+// to a user why they have access.
+func (t *UsersetTree) String() string {
+	if t == nil {
+		return "<not related>"
+	}
+	sb := &strings.Builder{}
+	t.writeTo(sb, 0)
+	if len(t.Resolution) != 0 {
+		fmt.Fprintf(sb, "resolution: %s\n", t.Resolution)
+	}
+	return sb.String()
+}
+
+func (t *UsersetTree) writeTo(sb *strings.Builder, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(sb, "%s%s#%s %s", indent, NodeString(t.Object), t.Relation, t.Kind)
+	switch t.Kind {
+	case UsersetTreeDirect:
+		users := make([]string, 0, len(t.Users))
+		for _, u := range t.Users {
+			users = append(users, NodeString(u))
+		}
+		fmt.Fprintf(sb, " [%s]", strings.Join(users, ", "))
+	case UsersetTreeTupleToUserset:
+		fmt.Fprintf(sb, " from %s", t.Tupleset)
+	}
+	sb.WriteString("\n")
+	for _, child := range t.Children {
+		child.writeTo(sb, depth+1)
+	}
+}
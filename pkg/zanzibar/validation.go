@@ -0,0 +1,271 @@
+package zanzibar
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var ErrInvalidSchema = errors.New("invalid authorization schema")
+
+// typeRelationEdge is one directly assignable relation; from a user type, possibly
+// with a userset relation, to an object type.
+type typeRelationEdge struct {
+	UserType        string
+	UserSetRelation string
+	Relation        string
+	ObjectType      string
+}
+
+type typeRelationKey struct {
+	TypeName string
+	Relation string
+}
+
+// Validate makes sure the schema can be converted into a model that behaves as expected.
+// It checks for:
+//   - empty and duplicate type names
+//   - directly assignable relations that are declared both as Incoming and Outgoing
+//   - userset relations, computed usersets and tuplesets referring to relations that do not exist
+//   - empty or ambiguous EvaluatedUsersets
+//   - DenyRelations referring to relations that do not exist
+//   - relations no user can ever be related through
+//
+// All problems found are returned, wrapping ErrInvalidSchema.
+func (as *AuthorizationSchema) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidSchema}, args...)...))
+	}
+
+	typeNames := sets.New[string]()
+	// direct contains all user types (with userset relation) directly assignable to each relation
+	direct := map[typeRelationKey]sets.Set[typeRelationEdge]{}
+	// edgeDeclaredIn tracks which type declared each edge, to detect duplicates
+	edgeDeclaredIn := map[typeRelationEdge]string{}
+	addEdge := func(declaredIn string, edge typeRelationEdge) {
+		if other, ok := edgeDeclaredIn[edge]; ok {
+			invalid("relation %s#%s from %s is declared twice, in types %q and %q", edge.ObjectType, edge.Relation, edgeUserString(edge), other, declaredIn)
+			return
+		}
+		edgeDeclaredIn[edge] = declaredIn
+		key := typeRelationKey{TypeName: edge.ObjectType, Relation: edge.Relation}
+		if _, ok := direct[key]; !ok {
+			direct[key] = sets.New[typeRelationEdge]()
+		}
+		direct[key].Insert(edge)
+	}
+
+	for _, tr := range as.Types {
+		if len(tr.TypeName) == 0 {
+			invalid("type name must not be empty")
+			continue
+		}
+		if typeNames.Has(tr.TypeName) {
+			invalid("type %q is declared more than once", tr.TypeName)
+			continue
+		}
+		typeNames.Insert(tr.TypeName)
+
+		for _, incoming := range tr.Incoming {
+			addEdge(tr.TypeName, typeRelationEdge{
+				UserType:        incoming.UserType,
+				UserSetRelation: incoming.UserSetRelation,
+				Relation:        incoming.Relation,
+				ObjectType:      tr.TypeName,
+			})
+		}
+		for _, outgoing := range tr.Outgoing {
+			for _, relation := range outgoing.Relations {
+				addEdge(tr.TypeName, typeRelationEdge{
+					UserType:        tr.TypeName,
+					UserSetRelation: outgoing.UserSetRelation,
+					Relation:        relation,
+					ObjectType:      outgoing.ObjectType,
+				})
+			}
+		}
+	}
+
+	rewrites := map[typeRelationKey]EvaluatedUserset{}
+	for _, tr := range as.Types {
+		for relation, eu := range tr.EvaluatedUsersets {
+			rewrites[typeRelationKey{TypeName: tr.TypeName, Relation: relation}] = eu
+		}
+	}
+	relationExists := func(typeName, relation string) bool {
+		key := typeRelationKey{TypeName: typeName, Relation: relation}
+		_, isDirect := direct[key]
+		_, isRewrite := rewrites[key]
+		return isDirect || isRewrite
+	}
+
+	// all types and userset relations referred to by the edges must exist
+	for _, edge := range sortedEdges(edgeDeclaredIn) {
+		if len(edge.UserType) == 0 || len(edge.ObjectType) == 0 || len(edge.Relation) == 0 {
+			invalid("relation %s#%s from %s must have a user type, relation and object type", edge.ObjectType, edge.Relation, edgeUserString(edge))
+			continue
+		}
+		if len(edge.UserSetRelation) != 0 && !relationExists(edge.UserType, edge.UserSetRelation) {
+			invalid("relation %s#%s refers to userset relation %s#%s, which does not exist", edge.ObjectType, edge.Relation, edge.UserType, edge.UserSetRelation)
+		}
+	}
+
+	// verify all references of the rewrites
+	for _, key := range sortedKeys(rewrites) {
+		validateEvaluatedUserset(key, rewrites[key], direct, relationExists, invalid)
+	}
+
+	for _, tr := range as.Types {
+		for _, relation := range sets.List(sets.KeySet(tr.DenyRelations)) {
+			denyRelation := tr.DenyRelations[relation]
+			if denyRelation == relation {
+				invalid("relation %s#%s must not deny itself", tr.TypeName, relation)
+			} else if !relationExists(tr.TypeName, denyRelation) {
+				invalid("relation %s#%s is denied by relation %s#%s, which does not exist", tr.TypeName, relation, tr.TypeName, denyRelation)
+			}
+		}
+	}
+
+	// Find all relations some user can be related through, that is, all directly assignable relations,
+	// and all rewrites that refer to such relations, until no more relations can be found.
+	satisfiable := sets.New[typeRelationKey]()
+	for key := range direct {
+		satisfiable.Insert(key)
+	}
+	for changed := true; changed; {
+		changed = false
+		for key, eu := range rewrites {
+			if !satisfiable.Has(key) && isSatisfiable(key.TypeName, eu, direct, satisfiable) {
+				satisfiable.Insert(key)
+				changed = true
+			}
+		}
+	}
+	for _, key := range sortedKeys(rewrites) {
+		if !satisfiable.Has(key) {
+			invalid("relation %s#%s can never be satisfied by any user", key.TypeName, key.Relation)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateEvaluatedUserset(key typeRelationKey, eu EvaluatedUserset, direct map[typeRelationKey]sets.Set[typeRelationEdge], relationExists func(typeName, relation string) bool, invalid func(format string, args ...any)) {
+	set := 0
+	for _, isSet := range []bool{len(eu.Union) != 0, len(eu.Intersection) != 0, eu.Difference != nil, len(eu.Relation) != 0, eu.TupleToUserset != nil} {
+		if isSet {
+			set++
+		}
+	}
+	switch set {
+	case 0:
+		invalid("relation %s#%s has an empty evaluated userset", key.TypeName, key.Relation)
+		return
+	case 1:
+	default:
+		invalid("relation %s#%s has an evaluated userset with more than one option set", key.TypeName, key.Relation)
+		return
+	}
+
+	switch {
+	case len(eu.Union) != 0:
+		for _, child := range eu.Union {
+			validateEvaluatedUserset(key, child, direct, relationExists, invalid)
+		}
+	case len(eu.Intersection) != 0:
+		for _, child := range eu.Intersection {
+			validateEvaluatedUserset(key, child, direct, relationExists, invalid)
+		}
+	case eu.Difference != nil:
+		validateEvaluatedUserset(key, eu.Difference.Base, direct, relationExists, invalid)
+		validateEvaluatedUserset(key, eu.Difference.Subtract, direct, relationExists, invalid)
+	case len(eu.Relation) != 0:
+		if !relationExists(key.TypeName, eu.Relation) {
+			invalid("relation %s#%s refers to relation %q, which does not exist", key.TypeName, key.Relation, eu.Relation)
+		}
+	case eu.TupleToUserset != nil:
+		ttu := eu.TupleToUserset
+		tuplesetTypes, ok := direct[typeRelationKey{TypeName: key.TypeName, Relation: ttu.FromRelation}]
+		if !ok {
+			invalid("relation %s#%s refers to tupleset relation %q, which does not exist or is not directly assignable", key.TypeName, key.Relation, ttu.FromRelation)
+			return
+		}
+		found := false
+		for edge := range tuplesetTypes {
+			if relationExists(edge.UserType, ttu.ReferencedRelation) {
+				found = true
+			}
+		}
+		if !found {
+			invalid("relation %s#%s refers to relation %q through tupleset %q, but no type related through the tupleset has that relation", key.TypeName, key.Relation, ttu.ReferencedRelation, ttu.FromRelation)
+		}
+	}
+}
+
+// isSatisfiable returns whether some user can be part of the evaluated userset, given the satisfiable relations
+func isSatisfiable(typeName string, eu EvaluatedUserset, direct map[typeRelationKey]sets.Set[typeRelationEdge], satisfiable sets.Set[typeRelationKey]) bool {
+	switch {
+	case len(eu.Union) != 0:
+		for _, child := range eu.Union {
+			if isSatisfiable(typeName, child, direct, satisfiable) {
+				return true
+			}
+		}
+		return false
+	case len(eu.Intersection) != 0:
+		for _, child := range eu.Intersection {
+			if !isSatisfiable(typeName, child, direct, satisfiable) {
+				return false
+			}
+		}
+		return true
+	case eu.Difference != nil:
+		return isSatisfiable(typeName, eu.Difference.Base, direct, satisfiable)
+	case len(eu.Relation) != 0:
+		return satisfiable.Has(typeRelationKey{TypeName: typeName, Relation: eu.Relation})
+	case eu.TupleToUserset != nil:
+		for edge := range direct[typeRelationKey{TypeName: typeName, Relation: eu.TupleToUserset.FromRelation}] {
+			if satisfiable.Has(typeRelationKey{TypeName: edge.UserType, Relation: eu.TupleToUserset.ReferencedRelation}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func edgeUserString(edge typeRelationEdge) string {
+	if len(edge.UserSetRelation) != 0 {
+		return edge.UserType + "#" + edge.UserSetRelation
+	}
+	return edge.UserType
+}
+
+// sortedEdges and sortedKeys make the order of the errors deterministic
+
+func sortedEdges(m map[typeRelationEdge]string) []typeRelationEdge {
+	result := make([]typeRelationEdge, 0, len(m))
+	for edge := range m {
+		result = append(result, edge)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return fmt.Sprint(result[i]) < fmt.Sprint(result[j])
+	})
+	return result
+}
+
+func sortedKeys(m map[typeRelationKey]EvaluatedUserset) []typeRelationKey {
+	result := make([]typeRelationKey, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TypeName != result[j].TypeName {
+			return result[i].TypeName < result[j].TypeName
+		}
+		return result[i].Relation < result[j].Relation
+	})
+	return result
+}
@@ -0,0 +1,152 @@
+package zanzibar_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/luxas/kube-rebac-authorizer/pkg/nodeauth"
+	"github.com/luxas/kube-rebac-authorizer/pkg/rbacconversion"
+	"github.com/luxas/kube-rebac-authorizer/pkg/zanzibar"
+	"gotest.tools/v3/assert"
+)
+
+func TestAuthorizationSchemaValidate(t *testing.T) {
+	fullSchema := rbacconversion.GetSchema()
+	fullSchema.Types = append(fullSchema.Types, nodeauth.GetSchema().Types...)
+
+	document := func(incoming []zanzibar.IncomingRelation, evaluatedUsersets map[string]zanzibar.EvaluatedUserset) zanzibar.AuthorizationSchema {
+		return zanzibar.AuthorizationSchema{
+			Types: []zanzibar.TypeRelation{
+				{
+					TypeName: "folder",
+					Incoming: []zanzibar.IncomingRelation{{UserType: "user", Relation: "owner"}},
+				},
+				{
+					TypeName:          "document",
+					Incoming:          incoming,
+					EvaluatedUsersets: evaluatedUsersets,
+				},
+			},
+		}
+	}
+	parent := zanzibar.IncomingRelation{UserType: "folder", Relation: "parent"}
+	editor := zanzibar.IncomingRelation{UserType: "user", Relation: "editor"}
+
+	tests := []struct {
+		name    string
+		as      zanzibar.AuthorizationSchema
+		wantErr bool
+	}{
+		{
+			name: "rbac and node authorizer schemas",
+			as:   fullSchema,
+		},
+		{
+			name: "valid",
+			as: document([]zanzibar.IncomingRelation{parent, editor}, map[string]zanzibar.EvaluatedUserset{
+				"editor": {TupleToUserset: &zanzibar.TupleToUserset{FromRelation: "parent", ReferencedRelation: "owner"}},
+				"viewer": {Relation: "editor"},
+			}),
+		},
+		{
+			name: "duplicate type name",
+			as: zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{
+				{TypeName: "user"},
+				{TypeName: "user"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "edge declared both as incoming and outgoing",
+			as: zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{
+				{
+					TypeName: "user",
+					Outgoing: []zanzibar.OutgoingRelation{{ObjectType: "document", Relations: []string{"editor"}}},
+				},
+				{
+					TypeName: "document",
+					Incoming: []zanzibar.IncomingRelation{editor},
+				},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "userset relation does not exist",
+			as:      document([]zanzibar.IncomingRelation{{UserType: "folder", UserSetRelation: "notexist", Relation: "editor"}}, nil),
+			wantErr: true,
+		},
+		{
+			name: "computed userset does not exist",
+			as: document([]zanzibar.IncomingRelation{editor}, map[string]zanzibar.EvaluatedUserset{
+				"viewer": {Relation: "notexist"},
+			}),
+			wantErr: true,
+		},
+		{
+			name: "tupleset does not exist",
+			as: document([]zanzibar.IncomingRelation{editor}, map[string]zanzibar.EvaluatedUserset{
+				"viewer": {TupleToUserset: &zanzibar.TupleToUserset{FromRelation: "notexist", ReferencedRelation: "owner"}},
+			}),
+			wantErr: true,
+		},
+		{
+			name: "referenced relation does not exist",
+			as: document([]zanzibar.IncomingRelation{parent}, map[string]zanzibar.EvaluatedUserset{
+				"viewer": {TupleToUserset: &zanzibar.TupleToUserset{FromRelation: "parent", ReferencedRelation: "notexist"}},
+			}),
+			wantErr: true,
+		},
+		{
+			name: "empty set operation",
+			as: document([]zanzibar.IncomingRelation{editor}, map[string]zanzibar.EvaluatedUserset{
+				"viewer": {Union: []zanzibar.EvaluatedUserset{{Relation: "editor"}, {}}},
+			}),
+			wantErr: true,
+		},
+		{
+			name: "never satisfiable",
+			as: document([]zanzibar.IncomingRelation{editor}, map[string]zanzibar.EvaluatedUserset{
+				"a": {Relation: "b"},
+				"b": {Intersection: []zanzibar.EvaluatedUserset{{Relation: "a"}, {Relation: "editor"}}},
+			}),
+			wantErr: true,
+		},
+		{
+			name: "valid deny relation",
+			as: zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{{
+				TypeName:      "document",
+				Incoming:      []zanzibar.IncomingRelation{editor, {UserType: "user", Relation: "banned"}},
+				DenyRelations: map[string]string{"editor": "banned"},
+			}}},
+		},
+		{
+			name: "deny relation does not exist",
+			as: zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{{
+				TypeName:      "document",
+				Incoming:      []zanzibar.IncomingRelation{editor},
+				DenyRelations: map[string]string{"editor": "notexist"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "relation denies itself",
+			as: zanzibar.AuthorizationSchema{Types: []zanzibar.TypeRelation{{
+				TypeName:      "document",
+				Incoming:      []zanzibar.IncomingRelation{editor},
+				DenyRelations: map[string]string{"editor": "editor"},
+			}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.as.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AuthorizationSchema.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				assert.Assert(t, errors.Is(err, zanzibar.ErrInvalidSchema))
+			}
+		})
+	}
+}
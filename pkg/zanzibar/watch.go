@@ -0,0 +1,41 @@
+package zanzibar
+
+import (
+	"context"
+	"time"
+)
+
+// TupleOperation is the kind of change made to a tuple.
+type TupleOperation string
+
+const (
+	TupleOperationWrite  TupleOperation = "Write"
+	TupleOperationDelete TupleOperation = "Delete"
+)
+
+// TupleChange is a tuple that was written to or deleted from the store.
+type TupleChange struct {
+	Tuple     Tuple
+	Operation TupleOperation
+	// Timestamp is when the change was made in the store
+	Timestamp time.Time
+}
+
+// TupleChanges is a batch of changes, in the order they were made in the store.
+type TupleChanges struct {
+	Changes []TupleChange
+	// ContinuationToken can be passed to WatchTuples to resume watching after the last change
+	// of this batch, e.g. after a restart.
+	ContinuationToken string
+	// Err is set if watching failed. Err is always sent last, right before the channel is closed.
+	Err error
+}
+
+// TupleWatcher streams all changes made to the tuples in a TupleStore.
+type TupleWatcher interface {
+	// WatchTuples sends all changes made to tuples with the given object type, or all tuples if objectType
+	// is empty, after the given continuation token. If the continuation token is empty, all changes since
+	// the store was created are sent. Watching continues until ctx is canceled or an error occurs, after
+	// which the channel is closed.
+	WatchTuples(ctx context.Context, objectType, continuationToken string) <-chan TupleChanges
+}